@@ -0,0 +1,178 @@
+// Package audit writes append-only audit trail as JSON lines, optionally chained by SHA-256 hashes.
+// Пакет audit пишет журнал аудита только на добавление строками JSON, при необходимости связанными хешами SHA-256.
+//
+// In chained log each record carries hash of previous one in "prev" and ends with its own "hash",
+// SHA-256 of line up to hash field. Changed, removed or reordered records break chain
+// В связанном журнале запись содержит хеш предыдущей в "prev" и завершается собственным "hash",
+// SHA-256 строки до поля хеша. Измененные, удаленные или переставленные записи разрывают цепочку
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Events of records. События записей
+const (
+	EventStreamOpen  = "stream_open"
+	EventOrder       = "order"
+	EventShipment    = "shipment"
+	EventStreamClose = "stream_close"
+)
+
+// Decisions of orders. Решения по заказам
+const (
+	Accepted = "accepted"
+	Rejected = "rejected"
+)
+
+// Record of audit trail. Запись журнала аудита
+type Record struct {
+	Time     time.Time `json:"time"`
+	Event    string    `json:"event"`
+	Identity string    `json:"identity,omitempty"` // Identity of peer. Удостоверение клиента
+	Peer     string    `json:"peer,omitempty"`     // Address of peer. Адрес клиента
+	Stream   string    `json:"stream,omitempty"`   // ID of stream. Идентификатор потока
+	Method   string    `json:"method,omitempty"`
+	OrderID  string    `json:"order_id,omitempty"`
+	Decision string    `json:"decision,omitempty"` // Accepted or Rejected
+	Reason   string    `json:"reason,omitempty"`
+	Shipment string    `json:"shipment,omitempty"` // ID of CombinedShipment
+	Sequence uint64    `json:"sequence,omitempty"`
+	Trigger  string    `json:"trigger,omitempty"` // Cause of flush of shipment. Причина отправки партии
+	Orders   []string  `json:"orders,omitempty"`  // Order IDs of shipment. ID заказов партии
+	Prev     string    `json:"prev,omitempty"`    // Hash of previous record. Хеш предыдущей записи
+}
+
+// Logger writes each record as one line with single Write. Nil logger drops records. Safe for concurrent use
+// Записывает каждую запись одной строкой за один вызов Write. Пустой журнал отбрасывает записи
+type Logger struct {
+	mu    sync.Mutex
+	w     io.Writer
+	chain bool
+	prev  string
+	now   func() time.Time
+}
+
+// New returns logger of plain JSON lines. Возвращает журнал строк JSON без цепочки
+func New(w io.Writer) *Logger {
+	return &Logger{w: w, now: time.Now}
+}
+
+// NewChained returns logger chaining records to prev, hash of last record written before, "" - new chain
+// Возвращает журнал, продолжающий цепочку от prev, хеша последней записанной записи, "" - новая цепочка
+func NewChained(w io.Writer, prev string) *Logger {
+	return &Logger{w: w, chain: true, prev: prev, now: time.Now}
+}
+
+// Log writes record, zero time is set to now. Записывает запись, пустое время заменяется текущим
+func (l *Logger) Log(rec Record) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if rec.Time.IsZero() {
+		rec.Time = l.now()
+	}
+	rec.Time = rec.Time.UTC()
+	rec.Prev = ""
+	if l.chain {
+		rec.Prev = l.prev
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	var hash string
+	if l.chain {
+		hash = hashOf(line)
+		line = append(line[:len(line)-1], `,"hash":"`+hash+`"}`...)
+	}
+	if _, err := l.w.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+	if l.chain {
+		l.prev = hash
+	}
+	return nil
+}
+
+// Last returns hash of last chained record. Хеш последней записи цепочки
+func (l *Logger) Last() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.prev
+}
+
+func hashOf(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// Suffix of chained line before hash. Окончание связанной строки перед хешем
+var hashField = []byte(`,"hash":"`)
+
+// Splits chained line to body, as it was hashed, and hash. Разделяет строку на тело, как оно хешировалось, и хеш
+func splitHash(line []byte) (body []byte, hash string, err error) {
+	i := bytes.LastIndex(line, hashField)
+	if i < 0 || !bytes.HasSuffix(line, []byte(`"}`)) {
+		return nil, "", errors.New("no hash")
+	}
+	hash = string(line[i+len(hashField) : len(line)-2])
+	body = append(append([]byte{}, line[:i]...), '}')
+	return body, hash, nil
+}
+
+// ChainError reports first broken record. Сообщает о первой нарушенной записи
+type ChainError struct {
+	Record int // From 1 within reader. С 1 в пределах потока чтения
+	Err    error
+}
+
+func (e *ChainError) Error() string { return fmt.Sprintf("record %d: %v", e.Record, e.Err) }
+func (e *ChainError) Unwrap() error { return e.Err }
+
+// Verify checks chained records of r following prev and returns hash of last record and number of records.
+// Empty prev trusts "prev" of first record, e.g. when older files were removed by rotation
+// Проверяет связанные записи r после prev и возвращает хеш последней записи и число записей.
+// Пустой prev доверяет "prev" первой записи, например, когда старые файлы удалены ротацией
+func Verify(r io.Reader, prev string) (last string, n int, err error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	last = prev
+	for sc.Scan() {
+		line := sc.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		body, hash, err := splitHash(line)
+		if err != nil {
+			return last, n, &ChainError{n + 1, err}
+		}
+		var rec Record
+		if err := json.Unmarshal(body, &rec); err != nil {
+			return last, n, &ChainError{n + 1, err}
+		}
+		if rec.Prev != last && (n > 0 || prev != "") {
+			return last, n, &ChainError{n + 1, fmt.Errorf("prev %.12s, want %.12s", rec.Prev, last)}
+		}
+		if hashOf(body) != hash {
+			return last, n, &ChainError{n + 1, errors.New("hash mismatch, record was changed")}
+		}
+		last = hash
+		n++
+	}
+	if err := sc.Err(); err != nil {
+		return last, n, err
+	}
+	return last, n, nil
+}
@@ -0,0 +1,176 @@
+// Тестирование журнала аудита. Testing of audit log
+
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeRecords(t *testing.T, l *Logger, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		rec := Record{Event: EventOrder, Identity: "cn:bs-client", Stream: "s1", OrderID: string(rune('a' + i)), Decision: Accepted}
+		if err := l.Log(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestChain(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewChained(&buf, "")
+	writeRecords(t, l, 3)
+	last, n, err := Verify(bytes.NewReader(buf.Bytes()), "")
+	if err != nil || n != 3 || last != l.Last() {
+		t.Fatalf("Verify() = %.12s, %d, %v, want %.12s, 3", last, n, err, l.Last())
+	}
+	lines := strings.SplitAfter(buf.String(), "\n")[:3]
+
+	for name, tampered := range map[string]string{
+		"changed":   lines[0] + strings.Replace(lines[1], `"order_id":"b"`, `"order_id":"x"`, 1) + lines[2],
+		"removed":   lines[0] + lines[2],
+		"reordered": lines[1] + lines[0] + lines[2],
+		"no hash":   lines[0] + `{"event":"order"}` + "\n",
+	} {
+		_, _, err := Verify(strings.NewReader(tampered), "")
+		var ce *ChainError
+		if !errors.As(err, &ce) {
+			t.Errorf("Verify(%s) = %v, want ChainError", name, err)
+		}
+	}
+
+	// Chain is continued from prev. Цепочка продолжается от prev
+	var next bytes.Buffer
+	writeRecords(t, NewChained(&next, last), 2)
+	if _, n, err := Verify(&next, last); err != nil || n != 2 {
+		t.Errorf("Verify(continued) = %d, %v", n, err)
+	}
+	if _, _, err := Verify(strings.NewReader(lines[2]), last); err == nil {
+		t.Error("Verify() with wrong prev = nil error")
+	}
+}
+
+// Failed rotation keeps file open, next write rotates. Неудачная ротация оставляет файл открытым, следующая запись ее повторяет
+func TestRotatingFile_FailedRename(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	f, err := OpenFile(path, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	at := time.Date(2023, 2, 1, 12, 0, 0, 0, time.UTC)
+	f.now = func() time.Time { return at }
+
+	// Non-empty directory at rotated name fails rename. Непустой каталог на месте замененного файла мешает переименованию
+	blocker := path + "." + at.Format(rotatedFormat)
+	if err := os.MkdirAll(filepath.Join(blocker, "busy"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("first\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("second\n")); err == nil {
+		t.Fatal("Write() with failed rotation = nil error")
+	}
+	if err := f.Sync(); err != nil {
+		t.Errorf("Sync() after failed rotation = %v", err)
+	}
+
+	if err := os.RemoveAll(blocker); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("second\n")); err != nil {
+		t.Fatalf("Write() after obstacle is removed = %v", err)
+	}
+	for file, want := range map[string]string{blocker: "first\n", path: "second\n"} {
+		if data, err := os.ReadFile(file); err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v, want %q", file, data, err, want)
+		}
+	}
+}
+
+func TestPlainAndNil(t *testing.T) {
+	var buf bytes.Buffer
+	writeRecords(t, New(&buf), 1)
+	var rec map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec["order_id"] != "a" || rec["hash"] != nil || rec["prev"] != nil {
+		t.Errorf("plain record = %v", rec)
+	}
+	var l *Logger
+	if err := l.Log(Record{Event: EventOrder}); err != nil {
+		t.Errorf("nil Logger.Log() = %v", err)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit", "audit.log")
+	f, err := OpenFile(path, 400, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := time.Date(2023, 2, 1, 12, 0, 0, 0, time.UTC)
+	f.now = func() time.Time { clock = clock.Add(time.Second); return clock }
+	l := NewChained(f, "")
+	writeRecords(t, l, 10)
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := Files(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("Files() = %v, want 2 rotated and current", files)
+	}
+	for _, file := range files {
+		if fi, err := os.Stat(file); err != nil || fi.Size() > 400 {
+			t.Errorf("%s: %v, %v", file, fi, err)
+		}
+	}
+
+	// Restart continues chain. Перезапуск продолжает цепочку
+	prev, err := LastHash(path)
+	if err != nil || prev != l.Last() {
+		t.Fatalf("LastHash() = %.12s, %v, want %.12s", prev, err, l.Last())
+	}
+	f, err = OpenFile(path, 400, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeRecords(t, NewChained(f, prev), 1)
+	f.Close()
+
+	// Oldest files were removed, first record is trusted. Старые файлы удалены, первой записи доверяем
+	last, total := "", 0
+	if files, err = Files(path); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var n int
+		if last, n, err = Verify(bytes.NewReader(data), last); err != nil {
+			t.Fatalf("Verify(%s) = %v", file, err)
+		}
+		total += n
+	}
+	if total == 0 || total > 11 {
+		t.Errorf("verified %d records", total)
+	}
+	if h, err := LastHash(filepath.Join(t.TempDir(), "missing.log")); err != nil || h != "" {
+		t.Errorf("LastHash(missing) = %q, %v", h, err)
+	}
+}
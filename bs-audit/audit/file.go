@@ -0,0 +1,202 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Suffix format of rotated files, sorts by time. Формат суффикса замененных файлов, сортируется по времени
+const rotatedFormat = "20060102T150405.000000000Z"
+
+// RotatingFile appends to file and moves it aside as file.<UTC time> when it would exceed max bytes.
+// Writes are never split, so lines stay whole. Safe for concurrent use
+// Дописывает файл и переименовывает его в file.<время UTC>, когда он превысил бы max байт.
+// Запись не разделяется, строки остаются целыми
+type RotatingFile struct {
+	path string
+	max  int64 // 0 - no rotation. 0 - без ротации
+	keep int   // Rotated files kept, 0 - all. Хранимые замененные файлы, 0 - все
+	now  func() time.Time
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// OpenFile opens file for appending, creating it and its directory. Открывает файл для дописывания, создает его и каталог
+func OpenFile(path string, max int64, keep int) (*RotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	r := &RotatingFile{path: path, max: max, keep: keep, now: time.Now}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, fi.Size()
+	return nil
+}
+
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return 0, os.ErrClosed
+	}
+	if r.max > 0 && r.size > 0 && r.size+int64(len(p)) > r.max {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Moves file aside and opens new one. On error current file stays open and is moved back, next write retries
+// Переименовывает файл и открывает новый. При ошибке текущий файл остается открытым и возвращается на место,
+// следующая запись повторяет ротацию
+func (r *RotatingFile) rotate() error {
+	if err := r.f.Sync(); err != nil {
+		return err
+	}
+	old, size := r.f, r.size
+	rotated := r.path + "." + r.now().UTC().Format(rotatedFormat)
+	if err := os.Rename(r.path, rotated); err != nil {
+		return err
+	}
+	if err := r.open(); err != nil {
+		if rerr := os.Rename(rotated, r.path); rerr != nil {
+			err = fmt.Errorf("%w, moving back: %v", err, rerr)
+		}
+		r.f, r.size = old, size
+		return err
+	}
+	old.Close()
+	if r.keep > 0 {
+		rotated, err := rotatedFiles(r.path)
+		if err != nil {
+			return err
+		}
+		for len(rotated) > r.keep {
+			if err := os.Remove(rotated[0]); err != nil {
+				return err
+			}
+			rotated = rotated[1:]
+		}
+	}
+	return nil
+}
+
+// Sync flushes file to disk. Сбрасывает файл на диск
+func (r *RotatingFile) Sync() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return os.ErrClosed
+	}
+	return r.f.Sync()
+}
+
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return os.ErrClosed
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
+
+// Rotated files of path, oldest first. Замененные файлы path, от старых к новым
+func rotatedFiles(path string) ([]string, error) {
+	files, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	var rotated []string
+	prefix := len(path) + 1
+	for _, f := range files {
+		if _, err := time.Parse(rotatedFormat, f[prefix:]); err == nil {
+			rotated = append(rotated, f)
+		}
+	}
+	sort.Strings(rotated)
+	return rotated, nil
+}
+
+// Files returns rotated files of path, oldest first, and path itself, in order of chain
+// Возвращает замененные файлы path от старых к новым и сам path, в порядке цепочки
+func Files(path string) ([]string, error) {
+	rotated, err := rotatedFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	return append(rotated, path), nil
+}
+
+// LastHash returns hash of last chained record of path or its rotated files, "" if there are none.
+// It continues chain after restart
+// Возвращает хеш последней связанной записи path или его замененных файлов, "" если записей нет.
+// Продолжает цепочку после перезапуска
+func LastHash(path string) (string, error) {
+	files, err := Files(path)
+	if err != nil {
+		return "", err
+	}
+	for i := len(files) - 1; i >= 0; i-- {
+		line, err := lastLine(files[i])
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if line == nil {
+			continue
+		}
+		_, hash, err := splitHash(line)
+		if err != nil {
+			return "", fmt.Errorf("audit %s: last record: %w", files[i], err)
+		}
+		return hash, nil
+	}
+	return "", nil
+}
+
+// Last non-empty line of file, nil if there is none. Последняя непустая строка файла
+func lastLine(file string) ([]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var last []byte
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		if line := bytes.TrimSpace(sc.Bytes()); len(line) > 0 {
+			last = append(last[:0], line...)
+		}
+	}
+	return last, sc.Err()
+}
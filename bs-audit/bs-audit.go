@@ -0,0 +1,90 @@
+// Проверка журнала аудита gRPC-сервиса. Командная строка: bs-audit <команда> [флаги]
+// Verification of audit log of gRPC-service. Command line: bs-audit <command> [flags]
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/blablatov/bidistream-mtls-grpc/bs-audit/audit"
+)
+
+const usage = `Usage: bs-audit <command> [flags]
+
+Commands:
+  verify   check hash chain of audit log and its rotated files
+
+Run "bs-audit <command> -h" for flags of command.
+`
+
+func main() {
+	log.SetPrefix("bs-audit: ")
+	log.SetFlags(0)
+
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "verify":
+		err = runVerify(args, os.Stdout)
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(os.Stdout, usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", cmd, usage)
+		os.Exit(2)
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Verifies chain of log files, rotated files of each log are checked first
+// Проверяет цепочку файлов журнала, замененные файлы каждого журнала проверяются первыми
+func runVerify(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	prev := fs.String("prev", "", "hash of record before first one, e.g. printed by earlier verify, empty - trust first record")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: bs-audit verify [-prev hash] audit.log")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("verify: want one log file")
+	}
+	files, err := audit.Files(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	last, total := *prev, 0
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		var n int
+		last, n, err = audit.Verify(f, last)
+		f.Close()
+		total += n
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		fmt.Fprintf(w, "%s: %d records\n", file, n)
+	}
+	fmt.Fprintf(w, "chain of %d records is intact, last hash %s\n", total, last)
+	return nil
+}
@@ -0,0 +1,54 @@
+// Тестирование проверки журнала аудита. Testing of verification of audit log
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/blablatov/bidistream-mtls-grpc/bs-audit/audit"
+)
+
+func TestVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	f, err := audit.OpenFile(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := audit.NewChained(f, "")
+	for _, id := range []string{"102", "999"} {
+		if err := l.Log(audit.Record{Event: audit.EventOrder, Stream: "s1", OrderID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.Close()
+
+	var out bytes.Buffer
+	if err := runVerify([]string{path}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "chain of 2 records is intact, last hash "+l.Last()) {
+		t.Errorf("verify output = %q", &out)
+	}
+	if err := runVerify([]string{"-prev", strings.Repeat("0", 64), path}, &out); err == nil {
+		t.Error("verify with wrong -prev = nil error")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, bytes.Replace(data, []byte(`"999"`), []byte(`"998"`), 1), 0600); err != nil {
+		t.Fatal(err)
+	}
+	err = runVerify([]string{path}, &out)
+	if err == nil || !strings.Contains(err.Error(), "record 2") {
+		t.Errorf("verify of changed log = %v, want error of record 2", err)
+	}
+	if err := runVerify(nil, &out); err == nil {
+		t.Error("verify without file = nil error")
+	}
+}
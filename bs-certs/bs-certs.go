@@ -0,0 +1,350 @@
+// Генератор PKI для разработки: УЦ, сертификаты сервера и клиентов. Командная строка: bs-certs <команда> [флаги]
+// Generator of development PKI: CA, server and client certificates. Command line: bs-certs <command> [flags]
+
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/blablatov/bidistream-mtls-grpc/bs-certs/pki"
+)
+
+// Names of files in directory of PKI. Имена файлов в каталоге PKI
+const (
+	caName     = "ca"
+	serverName = "server"
+	clientName = "client"
+)
+
+const (
+	defaultDir        = "bs-mcerts"
+	defaultCAValidity = 10 * 365 * 24 * time.Hour
+)
+
+const usage = `Usage: bs-certs <command> [flags]
+
+Commands:
+  init          create CA, server and client certificates
+  issue         issue certificate signed by existing CA
+  rotate        re-issue certificates with same names, keeping keys unless -new-key
+  fingerprint   print SHA-256 fingerprints of certificates
+
+Run "bs-certs <command> -h" for flags of command.
+`
+
+func main() {
+	log.SetPrefix("bs-certs: ")
+	log.SetFlags(0)
+
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "init":
+		err = runInit(args, os.Stdout)
+	case "issue":
+		err = runIssue(args, os.Stdout)
+	case "rotate":
+		err = runRotate(args, os.Stdout)
+	case "fingerprint":
+		err = runFingerprint(args, os.Stdout)
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(os.Stdout, usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", cmd, usage)
+		os.Exit(2)
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Repeatable flag of comma separated values. Повторяемый флаг со значениями через запятую
+type listFlag []string
+
+func (l *listFlag) String() string { return strings.Join(*l, ",") }
+
+func (l *listFlag) Set(v string) error {
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			*l = append(*l, s)
+		}
+	}
+	return nil
+}
+
+// Subject alternative names of flags. Альтернативные имена из флагов
+type sanFlags struct {
+	dns, ip, uri listFlag
+}
+
+func (s *sanFlags) register(fs *flag.FlagSet) {
+	fs.Var(&s.dns, "dns", "DNS names, comma separated or repeated")
+	fs.Var(&s.ip, "ip", "IP addresses, comma separated or repeated")
+	fs.Var(&s.uri, "uri", "URIs, e.g. SPIFFE ID spiffe://example.org/ns/default/sa/bs-client")
+}
+
+// Fills names of request. Заполняет имена запроса
+func (s *sanFlags) apply(req *pki.Request) error {
+	req.DNSNames = append(req.DNSNames, s.dns...)
+	for _, v := range s.ip {
+		ip := net.ParseIP(v)
+		if ip == nil {
+			return fmt.Errorf("invalid IP address %q", v)
+		}
+		req.IPAddresses = append(req.IPAddresses, ip)
+	}
+	for _, v := range s.uri {
+		u, err := url.Parse(v)
+		if err != nil || u.Scheme == "" {
+			return fmt.Errorf("invalid URI %q", v)
+		}
+		req.URIs = append(req.URIs, u)
+	}
+	return nil
+}
+
+// Paths of certificate and key of name. Пути к сертификату и ключу
+func paths(dir, name string) (certFile, keyFile string) {
+	return filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+}
+
+// Loads CA of directory. Загружает УЦ каталога
+func loadCA(dir string) (*pki.Cert, error) {
+	ca, err := pki.Load(paths(dir, caName))
+	if err != nil {
+		return nil, fmt.Errorf("load CA: %w", err)
+	}
+	if !ca.Cert.IsCA {
+		return nil, fmt.Errorf("%s is not a CA certificate", caName+".crt")
+	}
+	return ca, nil
+}
+
+// Writes certificate of name and prints its fingerprint. Записывает сертификат и печатает отпечаток
+func save(w io.Writer, dir, name string, c *pki.Cert) error {
+	certFile, keyFile := paths(dir, name)
+	if err := c.Write(certFile, keyFile); err != nil {
+		return err
+	}
+	printCert(w, certFile, c)
+	return nil
+}
+
+func printCert(w io.Writer, file string, c *pki.Cert) {
+	fmt.Fprintf(w, "%s\n  subject:     %s\n  serial:      %X\n  not after:   %s\n  sha256:      %s\n",
+		file, c.Cert.Subject, c.Cert.SerialNumber, c.Cert.NotAfter.UTC().Format(time.RFC3339), pki.Fingerprint(c.Cert))
+}
+
+// Creates CA, server and client of directory. Создает УЦ, сертификаты сервера и клиента
+func runInit(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("init", flag.ContinueOnError)
+	dir := fs.String("dir", defaultDir, "output directory")
+	keyType := fs.String("key-type", string(pki.DefaultKeyType), "key type: ecdsa, ed25519 or rsa")
+	caValidity := fs.Duration("ca-validity", defaultCAValidity, "validity of CA")
+	validity := fs.Duration("validity", pki.DefaultValidity, "validity of server and client certificates")
+	caCN := fs.String("ca-cn", "bs-dev-ca", "common name of CA")
+	clientCN := fs.String("client-cn", "bs-client", "common name of client")
+	force := fs.Bool("force", false, "replace existing CA")
+	var srv, cli sanFlags
+	fs.Var(&srv.dns, "dns", "DNS names of server (default localhost,net-mtls-service)")
+	fs.Var(&srv.ip, "ip", "IP addresses of server (default 127.0.0.1)")
+	fs.Var(&cli.uri, "client-uri", "URIs of client, e.g. SPIFFE ID")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if len(srv.dns) == 0 && len(srv.ip) == 0 {
+		srv.dns, srv.ip = listFlag{"localhost", "net-mtls-service"}, listFlag{"127.0.0.1"}
+	}
+
+	caCert, _ := paths(*dir, caName)
+	if _, err := os.Stat(caCert); err == nil && !*force {
+		return fmt.Errorf("%s exists, use issue or rotate, or -force to replace CA", caCert)
+	}
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		return err
+	}
+
+	ca, err := pki.NewCA(*caCN, pki.KeyType(*keyType), *caValidity)
+	if err != nil {
+		return err
+	}
+	if err := save(w, *dir, caName, ca); err != nil {
+		return err
+	}
+
+	server := pki.Request{CommonName: "localhost", Server: true, KeyType: pki.KeyType(*keyType), Validity: *validity}
+	if err := srv.apply(&server); err != nil {
+		return err
+	}
+	client := pki.Request{CommonName: *clientCN, Client: true, KeyType: pki.KeyType(*keyType), Validity: *validity}
+	if err := cli.apply(&client); err != nil {
+		return err
+	}
+	for _, leaf := range []struct {
+		name string
+		req  pki.Request
+	}{{serverName, server}, {clientName, client}} {
+		c, err := ca.Issue(leaf.req)
+		if err != nil {
+			return err
+		}
+		if err := save(w, *dir, leaf.name, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Issues certificate of name signed by CA of directory. Выпускает сертификат, подписанный УЦ каталога
+func runIssue(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("issue", flag.ContinueOnError)
+	dir := fs.String("dir", defaultDir, "directory with ca.crt and ca.key")
+	name := fs.String("name", "", "base name of output files <name>.crt and <name>.key")
+	cn := fs.String("cn", "", "common name (default -name)")
+	server := fs.Bool("server", false, "allow server authentication")
+	client := fs.Bool("client", false, "allow client authentication")
+	keyType := fs.String("key-type", string(pki.DefaultKeyType), "key type: ecdsa, ed25519 or rsa")
+	validity := fs.Duration("validity", pki.DefaultValidity, "validity of certificate")
+	ocspURL := fs.String("ocsp", "", "URL of OCSP responder in AIA extension")
+	reuseKey := fs.Bool("reuse-key", false, "sign existing <name>.key instead of new key")
+	var san sanFlags
+	san.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *name == "" || *name == caName {
+		return errors.New("issue: -name is required and must not be ca")
+	}
+	if !*server && !*client {
+		return errors.New("issue: at least one of -server and -client is required")
+	}
+
+	req := pki.Request{CommonName: *cn, Server: *server, Client: *client, KeyType: pki.KeyType(*keyType), Validity: *validity}
+	if req.CommonName == "" {
+		req.CommonName = *name
+	}
+	if *ocspURL != "" {
+		req.OCSPServer = []string{*ocspURL}
+	}
+	if err := san.apply(&req); err != nil {
+		return err
+	}
+	if *reuseKey {
+		old, err := pki.Load(paths(*dir, *name))
+		if err != nil {
+			return err
+		}
+		req.Key = old.Key
+	}
+
+	ca, err := loadCA(*dir)
+	if err != nil {
+		return err
+	}
+	c, err := ca.Issue(req)
+	if err != nil {
+		return err
+	}
+	return save(w, *dir, *name, c)
+}
+
+// Re-issues certificates of names signed by CA of directory. Переиздает сертификаты, подписанные УЦ каталога
+func runRotate(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("rotate", flag.ContinueOnError)
+	dir := fs.String("dir", defaultDir, "directory with ca.crt and ca.key")
+	validity := fs.Duration("validity", pki.DefaultValidity, "validity of new certificates")
+	newKey := fs.Bool("new-key", false, "generate new keys of same type")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: bs-certs rotate [flags] [name...] (default server client)")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	names := fs.Args()
+	if len(names) == 0 {
+		names = []string{serverName, clientName}
+	}
+
+	ca, err := loadCA(*dir)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if name == caName {
+			return errors.New("rotate: CA is replaced by init -force")
+		}
+		old, err := pki.Load(paths(*dir, name))
+		if err != nil {
+			return err
+		}
+		if *newKey {
+			if old.Key, err = pki.GenerateKey(keyTypeOf(old)); err != nil {
+				return err
+			}
+		}
+		c, err := ca.Reissue(old, *validity)
+		if err != nil {
+			return err
+		}
+		if err := save(w, *dir, name, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Type of key of certificate. Тип ключа сертификата
+func keyTypeOf(c *pki.Cert) pki.KeyType {
+	switch c.Cert.PublicKeyAlgorithm {
+	case x509.Ed25519:
+		return pki.Ed25519
+	case x509.RSA:
+		return pki.RSA
+	}
+	return pki.ECDSA
+}
+
+// Prints fingerprints of PEM certificates. Печатает отпечатки сертификатов PEM
+func runFingerprint(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("fingerprint", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: bs-certs fingerprint file.crt...")
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("fingerprint: no files")
+	}
+	for _, file := range fs.Args() {
+		certs, err := pki.LoadCertificates(file)
+		if err != nil {
+			return err
+		}
+		for _, c := range certs {
+			printCert(w, file, &pki.Cert{Cert: c})
+		}
+	}
+	return nil
+}
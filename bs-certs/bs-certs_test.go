@@ -0,0 +1,130 @@
+// Тестирование команд генератора PKI. Testing of commands of PKI generator
+
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/blablatov/bidistream-mtls-grpc/bs-certs/pki"
+)
+
+func load(t *testing.T, dir, name string) *pki.Cert {
+	t.Helper()
+	c, err := pki.Load(paths(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// Certificates have same public key. Сертификаты с одним открытым ключом
+func sameKey(a, b *pki.Cert) bool {
+	return bytes.Equal(a.Cert.RawSubjectPublicKeyInfo, b.Cert.RawSubjectPublicKeyInfo)
+}
+
+func TestInitRotate(t *testing.T) {
+	dir := t.TempDir()
+	var out bytes.Buffer
+	if err := runInit([]string{"-dir", dir, "-key-type", "ed25519", "-client-uri", "spiffe://example.org/ns/default/sa/bs-client"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(out.String(), "sha256:"); n != 3 {
+		t.Errorf("init printed %d fingerprints, want 3:\n%s", n, &out)
+	}
+
+	ca, server, client := load(t, dir, caName), load(t, dir, serverName), load(t, dir, clientName)
+	if _, err := server.Cert.Verify(x509.VerifyOptions{DNSName: "net-mtls-service", Roots: ca.Pool()}); err != nil {
+		t.Errorf("server Verify() = %v", err)
+	}
+	if len(server.Cert.IPAddresses) != 1 || server.Cert.IPAddresses[0].String() != "127.0.0.1" {
+		t.Errorf("server IPAddresses = %v", server.Cert.IPAddresses)
+	}
+	if _, err := client.Cert.Verify(x509.VerifyOptions{
+		Roots:     ca.Pool(),
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		t.Errorf("client Verify() = %v", err)
+	}
+	if client.Cert.Subject.CommonName != "bs-client" || len(client.Cert.URIs) != 1 {
+		t.Errorf("client CN, URIs = %q, %v", client.Cert.Subject.CommonName, client.Cert.URIs)
+	}
+
+	// Existing CA is kept. Существующий УЦ сохраняется
+	if err := runInit([]string{"-dir", dir}, &out); err == nil {
+		t.Error("init over existing CA = nil error")
+	}
+
+	// Rotation keeps key and names, -new-key replaces key. Ротация сохраняет ключ и имена, -new-key заменяет ключ
+	if err := runRotate([]string{"-dir", dir, "server"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	rotated := load(t, dir, serverName)
+	if rotated.Cert.Equal(server.Cert) || !sameKey(rotated, server) {
+		t.Error("rotate didn't re-issue certificate for same key")
+	}
+	if err := runRotate([]string{"-dir", dir, "-new-key", "client"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	renewed := load(t, dir, clientName)
+	if renewed.Cert.PublicKeyAlgorithm != x509.Ed25519 || renewed.Cert.CheckSignatureFrom(ca.Cert) != nil {
+		t.Errorf("rotate -new-key algorithm = %v", renewed.Cert.PublicKeyAlgorithm)
+	}
+	if sameKey(renewed, client) {
+		t.Error("rotate -new-key kept key")
+	}
+}
+
+func TestIssue(t *testing.T) {
+	dir := t.TempDir()
+	if err := runInit([]string{"-dir", dir}, new(bytes.Buffer)); err != nil {
+		t.Fatal(err)
+	}
+	args := []string{"-dir", dir, "-name", "worker", "-client", "-key-type", "rsa",
+		"-uri", "spiffe://example.org/ns/jobs/sa/bs-worker", "-dns", "a.local,b.local", "-ocsp", "http://127.0.0.1:8888"}
+	if err := runIssue(args, new(bytes.Buffer)); err != nil {
+		t.Fatal(err)
+	}
+	worker := load(t, dir, "worker")
+	if worker.Cert.Subject.CommonName != "worker" || len(worker.Cert.DNSNames) != 2 ||
+		len(worker.Cert.OCSPServer) != 1 || worker.Cert.PublicKeyAlgorithm != x509.RSA {
+		t.Errorf("worker = %s %v %v %v", worker.Cert.Subject, worker.Cert.DNSNames, worker.Cert.OCSPServer, worker.Cert.PublicKeyAlgorithm)
+	}
+
+	for name, args := range map[string][]string{
+		"no name":   {"-dir", dir, "-client"},
+		"ca name":   {"-dir", dir, "-name", "ca", "-client"},
+		"no usage":  {"-dir", dir, "-name", "x"},
+		"bad ip":    {"-dir", dir, "-name", "x", "-client", "-ip", "localhost"},
+		"bad uri":   {"-dir", dir, "-name", "x", "-client", "-uri", "bs-worker"},
+		"no CA":     {"-dir", t.TempDir(), "-name", "x", "-client"},
+		"no key":    {"-dir", dir, "-name", "x", "-client", "-reuse-key"},
+		"bad key":   {"-dir", dir, "-name", "x", "-client", "-key-type", "dsa"},
+		"bad flags": {"-dir", dir, "-name", "x", "-client", "-validity", "year"},
+	} {
+		if err := runIssue(args, new(bytes.Buffer)); err == nil {
+			t.Errorf("issue %s = nil error", name)
+		}
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	dir := t.TempDir()
+	if err := runInit([]string{"-dir", dir}, new(bytes.Buffer)); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	certFile, _ := paths(dir, caName)
+	if err := runFingerprint([]string{certFile}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if want := pki.Fingerprint(load(t, dir, caName).Cert); !strings.Contains(out.String(), want) {
+		t.Errorf("fingerprint output = %q, want %s", &out, want)
+	}
+	if err := runFingerprint([]string{filepath.Join(dir, "missing.crt")}, &out); err == nil {
+		t.Error("fingerprint of missing file = nil error")
+	}
+}
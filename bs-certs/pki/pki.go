@@ -0,0 +1,325 @@
+// Package pki issues CA, server and client certificates of development PKI, e.g. for tests in t.TempDir().
+// Пакет pki выпускает сертификаты УЦ, сервера и клиентов для разработки, например для тестов в t.TempDir().
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Type of private key. Тип закрытого ключа
+type KeyType string
+
+const (
+	ECDSA   KeyType = "ecdsa" // P-256
+	Ed25519 KeyType = "ed25519"
+	RSA     KeyType = "rsa" // 2048 bits
+)
+
+// Defaults of requests. Значения по умолчанию
+const (
+	DefaultKeyType  = ECDSA
+	DefaultValidity = 365 * 24 * time.Hour
+)
+
+// Clock skew allowance of NotBefore. Допуск расхождения часов для NotBefore
+const backdate = 5 * time.Minute
+
+// Request of certificate. Запрос на сертификат
+type Request struct {
+	CommonName  string
+	DNSNames    []string
+	IPAddresses []net.IP
+	URIs        []*url.URL // SPIFFE IDs, e.g. spiffe://example.org/ns/default/sa/bs-client
+
+	Server bool // Extended key usage serverAuth. Назначение: аутентификация сервера
+	Client bool // Extended key usage clientAuth. Назначение: аутентификация клиента
+
+	OCSPServer []string // AIA URLs of OCSP responder. Адреса ответчика OCSP
+
+	KeyType  KeyType       // Empty - DefaultKeyType
+	Validity time.Duration // 0 - DefaultValidity
+
+	// Key of re-issued certificate, nil - new key. Ключ переиздаваемого сертификата, nil - новый ключ
+	Key crypto.Signer
+}
+
+// Cert is certificate with its private key. Сертификат с закрытым ключом
+type Cert struct {
+	Cert *x509.Certificate
+	Key  crypto.Signer
+}
+
+// NewCA creates self-signed CA. Создает самоподписанный УЦ
+func NewCA(commonName string, keyType KeyType, validity time.Duration) (*Cert, error) {
+	key, err := GenerateKey(keyType)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := template(commonName, validity)
+	if err != nil {
+		return nil, err
+	}
+	tmpl.IsCA = true
+	tmpl.BasicConstraintsValid = true
+	tmpl.MaxPathLenZero = true
+	tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+	return create(tmpl, tmpl, key, key)
+}
+
+// Issue signs certificate of request by CA. Подписывает сертификат запроса ключом УЦ
+func (ca *Cert) Issue(req Request) (*Cert, error) {
+	if req.CommonName == "" && len(req.DNSNames) == 0 && len(req.IPAddresses) == 0 && len(req.URIs) == 0 {
+		return nil, errors.New("pki: request has no names")
+	}
+	key := req.Key
+	if key == nil {
+		var err error
+		if key, err = GenerateKey(req.KeyType); err != nil {
+			return nil, err
+		}
+	}
+	tmpl, err := template(req.CommonName, req.Validity)
+	if err != nil {
+		return nil, err
+	}
+	tmpl.DNSNames = req.DNSNames
+	tmpl.IPAddresses = req.IPAddresses
+	tmpl.URIs = req.URIs
+	tmpl.OCSPServer = req.OCSPServer
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		tmpl.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	if req.Server {
+		tmpl.ExtKeyUsage = append(tmpl.ExtKeyUsage, x509.ExtKeyUsageServerAuth)
+	}
+	if req.Client {
+		tmpl.ExtKeyUsage = append(tmpl.ExtKeyUsage, x509.ExtKeyUsageClientAuth)
+	}
+	// Certificate doesn't outlive CA. Сертификат не переживает УЦ
+	if tmpl.NotAfter.After(ca.Cert.NotAfter) {
+		tmpl.NotAfter = ca.Cert.NotAfter
+	}
+	return create(tmpl, ca.Cert, key, ca.Key)
+}
+
+// Reissue signs new certificate for names and key of existing one. Переиздает сертификат с теми же именами и ключом
+func (ca *Cert) Reissue(old *Cert, validity time.Duration) (*Cert, error) {
+	req := Request{
+		CommonName:  old.Cert.Subject.CommonName,
+		DNSNames:    old.Cert.DNSNames,
+		IPAddresses: old.Cert.IPAddresses,
+		URIs:        old.Cert.URIs,
+		OCSPServer:  old.Cert.OCSPServer,
+		Validity:    validity,
+		Key:         old.Key,
+	}
+	for _, u := range old.Cert.ExtKeyUsage {
+		req.Server = req.Server || u == x509.ExtKeyUsageServerAuth
+		req.Client = req.Client || u == x509.ExtKeyUsageClientAuth
+	}
+	return ca.Issue(req)
+}
+
+// RevocationList returns PEM CRL of CA revoking certificates. Возвращает список отзыва УЦ в PEM
+func (ca *Cert) RevocationList(validity time.Duration, revoked ...*x509.Certificate) ([]byte, error) {
+	number, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.RevocationList{Number: number, ThisUpdate: now.Add(-backdate), NextUpdate: now.Add(validity)}
+	for _, c := range revoked {
+		tmpl.RevokedCertificates = append(tmpl.RevokedCertificates,
+			pkix.RevokedCertificate{SerialNumber: c.SerialNumber, RevocationTime: now})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, tmpl, ca.Cert, ca.Key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), nil
+}
+
+// GenerateKey creates private key of type. Создает закрытый ключ заданного типа
+func GenerateKey(keyType KeyType) (crypto.Signer, error) {
+	switch keyType {
+	case ECDSA, "":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case Ed25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	case RSA:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	}
+	return nil, fmt.Errorf("pki: unknown key type %q, want ecdsa, ed25519 or rsa", keyType)
+}
+
+func template(commonName string, validity time.Duration) (*x509.Certificate, error) {
+	if validity <= 0 {
+		validity = DefaultValidity
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    now.Add(-backdate),
+		NotAfter:     now.Add(validity),
+	}, nil
+}
+
+// Random 128-bit serial number. Случайный серийный номер длиной 128 бит
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func create(tmpl, parent *x509.Certificate, key, parentKey crypto.Signer) (*Cert, error) {
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, key.Public(), parentKey)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &Cert{Cert: cert, Key: key}, nil
+}
+
+// CertPEM encodes certificate. Кодирует сертификат в PEM
+func (c *Cert) CertPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Cert.Raw})
+}
+
+// KeyPEM encodes private key in PKCS #8. Кодирует закрытый ключ в PKCS #8
+func (c *Cert) KeyPEM() ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(c.Key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// TLSCertificate returns key pair for tls.Config. Возвращает ключевую пару для tls.Config
+func (c *Cert) TLSCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.Cert.Raw}, PrivateKey: c.Key, Leaf: c.Cert}
+}
+
+// Pool returns pool with certificate, e.g. of CA. Возвращает пул с сертификатом, например УЦ
+func (c *Cert) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(c.Cert)
+	return pool
+}
+
+// Write saves certificate and key, key is readable by owner only. Files are replaced atomically,
+// so that watchers of files never see half-written pair
+// Сохраняет сертификат и ключ, ключ доступен только владельцу. Файлы заменяются атомарно,
+// чтобы наблюдатели не видели наполовину записанную пару
+func (c *Cert) Write(certFile, keyFile string) error {
+	keyPEM, err := c.KeyPEM()
+	if err != nil {
+		return err
+	}
+	// Key first: pair with new key and old certificate fails to load and is retried
+	// Сначала ключ: пара из нового ключа и старого сертификата не загрузится и будет перечитана
+	if err := writeFile(keyFile, keyPEM, 0600); err != nil {
+		return err
+	}
+	return writeFile(certFile, c.CertPEM(), 0644)
+}
+
+func writeFile(name string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// Load reads certificate and PKCS #8, PKCS #1 or EC private key. Читает сертификат и закрытый ключ
+func Load(certFile, keyFile string) (*Cert, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("pki: key of %s can't sign", keyFile)
+	}
+	return &Cert{Cert: cert, Key: key}, nil
+}
+
+// Fingerprint returns SHA-256 of certificate as colon separated hex. Отпечаток SHA-256 сертификата
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// LoadCertificates reads PEM certificates of file. Читает сертификаты PEM из файла
+func LoadCertificates(file string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		if block, data = pem.Decode(data); block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("pki: %s: %w", file, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("pki: %s: no certificates", file)
+	}
+	return certs, nil
+}
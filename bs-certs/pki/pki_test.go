@@ -0,0 +1,185 @@
+// Тестирование выпуска сертификатов. Testing of certificate issuing
+
+package pki
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestIssue_KeyTypes(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://example.org/ns/default/sa/bs-client")
+	for _, kt := range []KeyType{ECDSA, Ed25519, RSA} {
+		t.Run(string(kt), func(t *testing.T) {
+			ca, err := NewCA("ca", kt, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ca.Cert.IsCA || ca.Cert.KeyUsage&x509.KeyUsageCRLSign == 0 {
+				t.Errorf("CA IsCA, KeyUsage = %v, %v", ca.Cert.IsCA, ca.Cert.KeyUsage)
+			}
+			leaf, err := ca.Issue(Request{
+				CommonName:  "server",
+				DNSNames:    []string{"localhost"},
+				IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+				URIs:        []*url.URL{spiffe},
+				Server:      true,
+				Client:      true,
+				KeyType:     kt,
+				Validity:    24 * time.Hour,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := leaf.Cert.Verify(x509.VerifyOptions{
+				DNSName:   "localhost",
+				Roots:     ca.Pool(),
+				KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+			}); err != nil {
+				t.Errorf("Verify() = %v", err)
+			}
+			if len(leaf.Cert.URIs) != 1 || leaf.Cert.URIs[0].String() != spiffe.String() {
+				t.Errorf("URIs = %v, want %v", leaf.Cert.URIs, spiffe)
+			}
+			// Certificate doesn't outlive CA. Сертификат не переживает УЦ
+			if leaf.Cert.NotAfter.After(ca.Cert.NotAfter) {
+				t.Errorf("NotAfter = %v after CA %v", leaf.Cert.NotAfter, ca.Cert.NotAfter)
+			}
+		})
+	}
+}
+
+func TestIssue_Errors(t *testing.T) {
+	ca, err := NewCA("ca", ECDSA, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ca.Issue(Request{Client: true}); err == nil {
+		t.Error("Issue() without names = nil error")
+	}
+	if _, err := ca.Issue(Request{CommonName: "x", KeyType: "dsa"}); err == nil {
+		t.Error("Issue(dsa) = nil error")
+	}
+}
+
+func TestWriteLoad(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := NewCA("ca", Ed25519, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key")
+	if err := ca.Write(certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(keyFile); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("key file mode = %v, %v, want 0600", fi.Mode().Perm(), err)
+	}
+	loaded, err := Load(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Cert.Equal(ca.Cert) {
+		t.Error("Load() returned other certificate")
+	}
+	if certs, err := LoadCertificates(certFile); err != nil || len(certs) != 1 {
+		t.Errorf("LoadCertificates() = %d, %v", len(certs), err)
+	}
+	// Only written files remain. Остаются только записанные файлы
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("directory has %d entries, want 2", len(entries))
+	}
+}
+
+func TestReissue(t *testing.T) {
+	ca, err := NewCA("ca", ECDSA, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := ca.Issue(Request{CommonName: "client", DNSNames: []string{"client"}, Client: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	renewed, err := ca.Reissue(old, 30*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renewed.Cert.SerialNumber.Cmp(old.Cert.SerialNumber) == 0 {
+		t.Error("Reissue() kept serial number")
+	}
+	if renewed.Cert.Subject.CommonName != "client" ||
+		len(renewed.Cert.DNSNames) != 1 || len(renewed.Cert.ExtKeyUsage) != 1 ||
+		renewed.Cert.ExtKeyUsage[0] != x509.ExtKeyUsageClientAuth {
+		t.Errorf("Reissue() names, usages = %s %v %v", renewed.Cert.Subject, renewed.Cert.DNSNames, renewed.Cert.ExtKeyUsage)
+	}
+	if Fingerprint(renewed.Cert) == Fingerprint(old.Cert) {
+		t.Error("Reissue() fingerprint unchanged")
+	}
+	if _, err := tls.X509KeyPair(renewed.CertPEM(), mustKeyPEM(t, old)); err != nil {
+		t.Errorf("reissued certificate doesn't match old key: %v", err)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	ca, err := NewCA("ca", ECDSA, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fp := Fingerprint(ca.Cert); !regexp.MustCompile(`^([0-9A-F]{2}:){31}[0-9A-F]{2}$`).MatchString(fp) {
+		t.Errorf("Fingerprint() = %q", fp)
+	}
+}
+
+// Mutual TLS handshake with generated pairs. Взаимная аутентификация TLS со сгенерированными парами
+func TestMutualTLS(t *testing.T) {
+	ca, err := NewCA("ca", ECDSA, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := ca.Issue(Request{CommonName: "localhost", DNSNames: []string{"localhost"}, Server: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := ca.Issue(Request{CommonName: "bs-client", Client: true, KeyType: Ed25519})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sc, cc := net.Pipe()
+	defer sc.Close()
+	defer cc.Close()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- tls.Server(sc, &tls.Config{
+			Certificates: []tls.Certificate{server.TLSCertificate()},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    ca.Pool(),
+		}).Handshake()
+	}()
+	if err := tls.Client(cc, &tls.Config{
+		ServerName:   "localhost",
+		Certificates: []tls.Certificate{client.TLSCertificate()},
+		RootCAs:      ca.Pool(),
+	}).Handshake(); err != nil {
+		t.Fatalf("client Handshake() = %v", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("server Handshake() = %v", err)
+	}
+}
+
+func mustKeyPEM(t *testing.T, c *Cert) []byte {
+	t.Helper()
+	key, err := c.KeyPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
@@ -0,0 +1,245 @@
+// Package certwatch reloads TLS key pair and CA bundle from files without restart.
+// Files are polled, changed material is loaded and swapped atomically, on failure last good one is kept.
+// Пакет certwatch перезагружает ключевую пару TLS и сертификаты УЦ из файлов без перезапуска.
+// Файлы опрашиваются, измененные данные загружаются и заменяются атомарно, при ошибке остаются последние исправные.
+package certwatch
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Default period of polling. Период опроса файлов по умолчанию
+const DefaultInterval = 10 * time.Second
+
+// Options of provider. Параметры поставщика сертификатов
+type Options struct {
+	CertFile string // PEM certificate chain. Цепочка сертификатов
+	KeyFile  string // PEM private key. Закрытый ключ
+	CAFile   string // PEM bundle of trusted CAs. Сертификаты доверенных УЦ
+
+	Interval time.Duration                            // Polling period, 0 - DefaultInterval. Период опроса
+	Logf     func(format string, args ...interface{}) // Reload events, nil - log.Printf. Журнал перезагрузок
+}
+
+// Loaded material. Загруженные данные
+type material struct {
+	cert *tls.Certificate
+	pool *x509.CertPool
+}
+
+// State of file, change of it triggers reload. Состояние файла, его изменение вызывает перезагрузку
+type stamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// Provider keeps current key pair and CA pool. Safe for concurrent use
+// Поставщик хранит текущую ключевую пару и пул УЦ. Безопасен для конкурентного использования
+type Provider struct {
+	opts    Options
+	current atomic.Value // *material
+
+	mu     sync.Mutex
+	stamps [3]stamp // Of files at last attempt. Файлов при последней попытке загрузки
+}
+
+// New loads files, error is returned if they are not valid
+// Загружает файлы, возвращает ошибку, если они недопустимы
+func New(o Options) (*Provider, error) {
+	if o.Interval <= 0 {
+		o.Interval = DefaultInterval
+	}
+	if o.Logf == nil {
+		o.Logf = log.Printf
+	}
+	p := &Provider{opts: o}
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Reload loads files now. On error current material is kept
+// Загружает файлы сейчас. При ошибке текущие данные сохраняются
+func (p *Provider) Reload() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stamps = p.stat()
+	m, err := load(p.opts)
+	if err != nil {
+		return err
+	}
+	p.current.Store(m)
+	return nil
+}
+
+// Run polls files until ctx is done. Changed files are reloaded, failures are logged
+// Опрашивает файлы, пока ctx не завершен. Измененные файлы перезагружаются, ошибки журналируются
+func (p *Provider) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.poll()
+		}
+	}
+}
+
+// Reloads if any file changed since last attempt. Failed state is not retried until files change again
+// Перезагружает, если файл изменился с последней попытки. Ошибочное состояние не повторяется до нового изменения
+func (p *Provider) poll() {
+	p.mu.Lock()
+	stamps := p.stat()
+	if stamps == p.stamps {
+		p.mu.Unlock()
+		return
+	}
+	p.mu.Unlock()
+
+	if err := p.Reload(); err != nil {
+		p.opts.Logf("certwatch: reload failed, keeping last good certificates: %v", err)
+		return
+	}
+	p.opts.Logf("certwatch: reloaded %s, %s, %s", p.opts.CertFile, p.opts.KeyFile, p.opts.CAFile)
+}
+
+func (p *Provider) stat() [3]stamp {
+	var stamps [3]stamp
+	for i, name := range []string{p.opts.CertFile, p.opts.KeyFile, p.opts.CAFile} {
+		// Stat follows symlinks swapped by Kubernetes. Stat следует по символическим ссылкам
+		if fi, err := os.Stat(name); err == nil {
+			stamps[i] = stamp{fi.ModTime(), fi.Size()}
+		}
+	}
+	return stamps
+}
+
+func load(o Options) (*material, error) {
+	cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not load key pair: %w", err)
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, fmt.Errorf("could not parse certificate: %w", err)
+	}
+	ca, err := os.ReadFile(o.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read ca certificate: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if ok := pool.AppendCertsFromPEM(ca); !ok {
+		return nil, errors.New("failed to append ca certs")
+	}
+	return &material{cert: &cert, pool: pool}, nil
+}
+
+func (p *Provider) material() *material {
+	return p.current.Load().(*material)
+}
+
+// Certificate returns current key pair. Возвращает текущую ключевую пару
+func (p *Provider) Certificate() *tls.Certificate {
+	return p.material().cert
+}
+
+// CertPool returns current pool of CAs. Возвращает текущий пул УЦ
+func (p *Provider) CertPool() *x509.CertPool {
+	return p.material().pool
+}
+
+// GetCertificate is tls.Config.GetCertificate of server. Для tls.Config.GetCertificate сервера
+func (p *Provider) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return p.Certificate(), nil
+}
+
+// GetClientCertificate is tls.Config.GetClientCertificate of client. Для tls.Config.GetClientCertificate клиента
+func (p *Provider) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return p.Certificate(), nil
+}
+
+// ServerConfig returns copy of base serving current certificate and verifying clients by current CAs.
+// Each handshake gets its config from GetConfigForClient. GetCertificate of base, if set, wraps Certificate
+// Возвращает копию base с текущим сертификатом сервера и проверкой клиентов текущими УЦ.
+// Каждое рукопожатие получает настройки из GetConfigForClient
+func (p *Provider) ServerConfig(base *tls.Config) *tls.Config {
+	if base == nil {
+		base = &tls.Config{}
+	}
+	cfg := base.Clone()
+	cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		c := base.Clone()
+		c.GetConfigForClient = nil
+		c.Certificates = nil
+		if c.GetCertificate == nil {
+			c.GetCertificate = p.GetCertificate
+		}
+		c.ClientCAs = p.CertPool()
+		if len(c.NextProtos) == 0 {
+			c.NextProtos = []string{"h2"} // ALPN of gRPC. Протокол gRPC
+		}
+		return c, nil
+	}
+	return cfg
+}
+
+// ClientConfig returns copy of base presenting current certificate and verifying server by current CAs.
+// RootCAs can't change after dial, so chain, serverAuth usage and name are verified in VerifyConnection,
+// then VerifyConnection of base is called with verified chains. Name is ServerName of base, else SNI of dial host;
+// SNI is empty for IP addresses, so dialing IP needs ServerName, without name handshake fails
+// Возвращает копию base с текущим сертификатом клиента и проверкой сервера текущими УЦ.
+// RootCAs нельзя заменить после подключения, поэтому цепочка, назначение serverAuth и имя проверяются в VerifyConnection.
+// Имя берется из ServerName base, иначе из SNI хоста подключения; для IP-адресов SNI пуст, поэтому нужен ServerName,
+// без имени рукопожатие завершается ошибкой
+func (p *Provider) ClientConfig(base *tls.Config) *tls.Config {
+	if base == nil {
+		base = &tls.Config{}
+	}
+	cfg := base.Clone()
+	cfg.Certificates = nil
+	cfg.GetClientCertificate = p.GetClientCertificate
+	cfg.InsecureSkipVerify = true // Replaced by VerifyConnection. Заменено проверкой VerifyConnection
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("certwatch: server presented no certificate")
+		}
+		name := base.ServerName
+		if name == "" {
+			name = cs.ServerName
+		}
+		if name == "" {
+			return errors.New("certwatch: no server name to verify, set ServerName")
+		}
+		opts := x509.VerifyOptions{
+			DNSName:       name,
+			Roots:         p.CertPool(),
+			Intermediates: x509.NewCertPool(),
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}
+		for _, cert := range cs.PeerCertificates[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		chains, err := cs.PeerCertificates[0].Verify(opts)
+		if err != nil {
+			return err
+		}
+		if base.VerifyConnection != nil {
+			// Checks of base see verified chains, e.g. OCSP. Проверки base видят проверенные цепочки
+			cs.VerifiedChains = chains
+			return base.VerifyConnection(cs)
+		}
+		return nil
+	}
+	return cfg
+}
@@ -0,0 +1,260 @@
+// Тестирование перезагрузки сертификатов. Testing of certificate reload
+
+package certwatch
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/blablatov/bidistream-mtls-grpc/bs-certs/pki"
+)
+
+// Count of written files, shifts modification time. Счетчик записей, сдвигает время изменения
+var writes int64
+
+// Writes CA and leaf of name to dir. Записывает УЦ и конечный сертификат в каталог
+func writePKI(t *testing.T, dir, name string) Options {
+	t.Helper()
+	ca, err := pki.NewCA("ca", pki.ECDSA, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := ca.Issue(pki.Request{CommonName: name, DNSNames: []string{name}, Server: true, Client: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, err := leaf.KeyPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := Options{
+		CertFile: filepath.Join(dir, name+".crt"),
+		KeyFile:  filepath.Join(dir, name+".key"),
+		CAFile:   filepath.Join(dir, name+"-ca.crt"),
+	}
+	writeFile(t, o.CertFile, leaf.CertPEM())
+	writeFile(t, o.KeyFile, keyPEM)
+	writeFile(t, o.CAFile, ca.CertPEM())
+	return o
+}
+
+// Writes file with new modification time. Записывает файл с новым временем изменения
+func writeFile(t *testing.T, name string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(name, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	writes++
+	later := time.Now().Add(time.Duration(writes) * time.Second)
+	if err := os.Chtimes(name, later, later); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNew_Invalid(t *testing.T) {
+	if _, err := New(Options{CertFile: "missing.crt", KeyFile: "missing.key", CAFile: "ca.crt"}); err == nil {
+		t.Error("New() with missing files = nil error")
+	}
+}
+
+func TestProvider_PollReloads(t *testing.T) {
+	dir := t.TempDir()
+	o := writePKI(t, dir, "server")
+	var logs []string
+	o.Logf = func(format string, args ...interface{}) { logs = append(logs, format) }
+	p, err := New(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := p.Certificate().Leaf.SerialNumber
+
+	// Unchanged files are not reloaded. Неизмененные файлы не перезагружаются
+	p.poll()
+	if len(logs) != 0 {
+		t.Fatalf("poll() of unchanged files logged %v", logs)
+	}
+
+	writePKI(t, dir, "server")
+	p.poll()
+	if p.Certificate().Leaf.SerialNumber.Cmp(first) == 0 {
+		t.Fatalf("certificate was not reloaded")
+	}
+
+	// Broken key keeps last good pair and is logged once. Испорченный ключ оставляет прежнюю пару
+	good := p.Certificate()
+	writeFile(t, o.KeyFile, []byte("not a key"))
+	p.poll()
+	p.poll()
+	if p.Certificate() != good {
+		t.Errorf("broken key replaced certificate")
+	}
+	if len(logs) != 2 {
+		t.Errorf("logs = %q, want reload and one failure", logs)
+	}
+	if err := p.Reload(); err == nil {
+		t.Errorf("Reload() with broken key = nil error")
+	}
+}
+
+func TestProvider_RunStops(t *testing.T) {
+	o := writePKI(t, t.TempDir(), "server")
+	o.Interval = time.Millisecond
+	p, err := New(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(done)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run() did not stop")
+	}
+}
+
+// Handshakes over loopback with configs of providers. Рукопожатие через loopback с настройками поставщиков
+func handshake(server, client *tls.Config) error {
+	sc, cc := tcpPipe()
+	defer sc.Close()
+	defer cc.Close()
+	errc := make(chan error, 1)
+	go func() { errc <- tls.Server(sc, server).Handshake() }()
+	cerr := tls.Client(cc, client).Handshake()
+	if cerr != nil {
+		cc.Close()
+		<-errc
+		return cerr
+	}
+	return <-errc
+}
+
+// Connected loopback TCP pair. Unlike net.Pipe it buffers, so alert of failed side doesn't block on unread flight
+// Пара соединенных TCP-соединений на loopback. В отличие от net.Pipe буферизуется, и сигнал ошибки не блокируется
+func tcpPipe() (net.Conn, net.Conn) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	defer lis.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, _ := lis.Accept()
+		accepted <- c
+	}()
+	cc, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		panic(err)
+	}
+	return <-accepted, cc
+}
+
+// Both sides rotate to new CA without new configs. Обе стороны переходят на новый УЦ без новых настроек
+func TestProvider_RotateCA(t *testing.T) {
+	dir := t.TempDir()
+	so := writePKI(t, dir, "server")
+	co := writePKI(t, dir, "client")
+	// Each side trusts CA of other. Каждая сторона доверяет УЦ другой
+	so.CAFile, co.CAFile = co.CAFile, so.CAFile
+
+	sp, err := New(so)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp, err := New(co)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverCfg := sp.ServerConfig(&tls.Config{ClientAuth: tls.RequireAndVerifyClientCert})
+	clientCfg := cp.ClientConfig(&tls.Config{ServerName: "server"})
+	if err := handshake(serverCfg, clientCfg); err != nil {
+		t.Fatalf("handshake() = %v", err)
+	}
+
+	// Server rotates to new CA, client doesn't trust it yet. Сервер перешел на новый УЦ, клиент еще не доверяет
+	rotated := writePKI(t, dir, "server")
+	sp.poll()
+	if err := handshake(serverCfg, clientCfg); err == nil {
+		t.Fatalf("handshake() with untrusted server CA = nil error")
+	}
+	writeFile(t, co.CAFile, mustRead(t, rotated.CAFile))
+	cp.poll()
+	if err := handshake(serverCfg, clientCfg); err != nil {
+		t.Fatalf("handshake() after rotation = %v", err)
+	}
+
+	// Name of server is verified. Имя сервера проверяется
+	if err := handshake(serverCfg, cp.ClientConfig(&tls.Config{ServerName: "other"})); err == nil {
+		t.Errorf("handshake() with wrong server name = nil error")
+	}
+}
+
+// Server is verified by configured name and serverAuth usage, not by SNI alone
+// Сервер проверяется по заданному имени и назначению serverAuth, а не только по SNI
+func TestClientConfig_ServerName(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := pki.NewCA("ca", pki.ECDSA, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile := filepath.Join(dir, "ca.crt")
+	writeFile(t, caFile, ca.CertPEM())
+	// Leaf of request served by server. Конечный сертификат запроса на стороне сервера
+	serve := func(req pki.Request) *tls.Config {
+		t.Helper()
+		leaf, err := ca.Issue(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert := leaf.TLSCertificate()
+		return &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	co := writePKI(t, dir, "client")
+	co.CAFile = caFile
+	cp, err := New(co)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byName := serve(pki.Request{CommonName: "server", DNSNames: []string{"server"}, Server: true})
+	byIP := serve(pki.Request{CommonName: "server", IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)}, Server: true})
+	clientOnly := serve(pki.Request{CommonName: "server", DNSNames: []string{"server"}, IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)}, Client: true})
+	for _, tc := range []struct {
+		name       string
+		server     *tls.Config
+		serverName string
+		ok         bool
+	}{
+		{"dns name", byName, "server", true},
+		{"ip san", byIP, "127.0.0.1", true},
+		{"ip without san", byName, "127.0.0.1", false},
+		{"no name", byName, "", false},
+		{"client certificate", clientOnly, "127.0.0.1", false},
+	} {
+		err := handshake(tc.server, cp.ClientConfig(&tls.Config{ServerName: tc.serverName}))
+		if tc.ok && err != nil {
+			t.Errorf("%s: handshake() = %v", tc.name, err)
+		}
+		if !tc.ok && err == nil {
+			t.Errorf("%s: handshake() = nil error", tc.name)
+		}
+	}
+}
+
+func mustRead(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,65 @@
+// Package logging builds leveled slog loggers writing text or JSON and carries logger of stream in context.
+// Пакет logging создает журналы slog с уровнем, пишущие текст или JSON, и передает журнал потока в контексте.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// Formats of output. Форматы вывода
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// ParseLevel parses debug, info, warn or error, "" is info. Разбирает уровень, "" - info
+func ParseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("logging: level %q: want debug, info, warn or error", s)
+	}
+	return l, nil
+}
+
+// ValidFormat reports whether format is text or json, "" is text. Сообщает, известен ли формат, "" - text
+func ValidFormat(format string) bool {
+	return format == "" || format == FormatText || format == FormatJSON
+}
+
+// New returns logger of level and format writing to w. Возвращает журнал уровня и формата, пишущий в w
+func New(w io.Writer, level, format string) (*slog.Logger, error) {
+	l, err := ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: l}
+	switch format {
+	case "", FormatText:
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("logging: format %q: want text or json", format)
+}
+
+type ctxKey struct{}
+
+// NewContext returns ctx carrying logger. Возвращает ctx с журналом
+func NewContext(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, l)
+}
+
+// FromContext returns logger of ctx, default logger if there is none
+// Возвращает журнал ctx, журнал по умолчанию, если его нет
+func FromContext(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(ctxKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
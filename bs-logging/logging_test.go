@@ -0,0 +1,58 @@
+// Тестирование журналов. Testing of loggers
+
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := New(&buf, "warn", FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Info("hidden")
+	l.Warn("shown", "stream", "ab12")
+	var rec map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("output %q: %v", buf.String(), err)
+	}
+	if rec["msg"] != "shown" || rec["level"] != "WARN" || rec["stream"] != "ab12" {
+		t.Errorf("record %v", rec)
+	}
+
+	buf.Reset()
+	if l, err = New(&buf, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	l.Debug("hidden")
+	l.Info("shown")
+	if got := buf.String(); !strings.Contains(got, "level=INFO msg=shown") || strings.Contains(got, "hidden") {
+		t.Errorf("text output %q", got)
+	}
+
+	for _, tc := range []struct{ level, format string }{{"verbose", FormatText}, {"info", "xml"}} {
+		if _, err := New(&buf, tc.level, tc.format); err == nil {
+			t.Errorf("New(%q, %q) is accepted", tc.level, tc.format)
+		}
+	}
+	if ValidFormat("xml") || !ValidFormat("") {
+		t.Error("ValidFormat is wrong")
+	}
+}
+
+func TestContext(t *testing.T) {
+	if FromContext(context.Background()) != slog.Default() {
+		t.Error("context without logger has no default logger")
+	}
+	l := slog.Default().With("stream", "ab12")
+	if FromContext(NewContext(context.Background(), l)) != l {
+		t.Error("logger of context is lost")
+	}
+}
@@ -10,6 +10,7 @@ import (
 
 	__ "github.com/blablatov/bidistream-mtls-grpc/bs-mtls-proto"
 	gomock "github.com/golang/mock/gomock"
+	wrappers "github.com/golang/protobuf/ptypes/wrappers"
 	grpc "google.golang.org/grpc"
 )
 
@@ -36,6 +37,66 @@ func (m *MockOrderManagementClient) EXPECT() *MockOrderManagementClientMockRecor
 	return m.recorder
 }
 
+// AddOrder mocks base method.
+func (m *MockOrderManagementClient) AddOrder(arg0 context.Context, arg1 *__.Order, arg2 ...grpc.CallOption) (*wrappers.StringValue, error) {
+	//m.ctrl.T.Helper()
+	varargs := []interface{}{arg0, arg1}
+	for _, a := range arg2 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "AddOrder", varargs...)
+	ret0, _ := ret[0].(*wrappers.StringValue)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AddOrder indicates an expected call of AddOrder.
+func (mr *MockOrderManagementClientMockRecorder) AddOrder(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+	//mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0, arg1}, arg2...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddOrder", reflect.TypeOf((*MockOrderManagementClient)(nil).AddOrder), varargs...)
+}
+
+// DeleteOrder mocks base method.
+func (m *MockOrderManagementClient) DeleteOrder(arg0 context.Context, arg1 *wrappers.StringValue, arg2 ...grpc.CallOption) (*wrappers.StringValue, error) {
+	//m.ctrl.T.Helper()
+	varargs := []interface{}{arg0, arg1}
+	for _, a := range arg2 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "DeleteOrder", varargs...)
+	ret0, _ := ret[0].(*wrappers.StringValue)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteOrder indicates an expected call of DeleteOrder.
+func (mr *MockOrderManagementClientMockRecorder) DeleteOrder(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+	//mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0, arg1}, arg2...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteOrder", reflect.TypeOf((*MockOrderManagementClient)(nil).DeleteOrder), varargs...)
+}
+
+// GetOrder mocks base method.
+func (m *MockOrderManagementClient) GetOrder(arg0 context.Context, arg1 *wrappers.StringValue, arg2 ...grpc.CallOption) (*__.Order, error) {
+	//m.ctrl.T.Helper()
+	varargs := []interface{}{arg0, arg1}
+	for _, a := range arg2 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "GetOrder", varargs...)
+	ret0, _ := ret[0].(*__.Order)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOrder indicates an expected call of GetOrder.
+func (mr *MockOrderManagementClientMockRecorder) GetOrder(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+	//mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0, arg1}, arg2...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrder", reflect.TypeOf((*MockOrderManagementClient)(nil).GetOrder), varargs...)
+}
+
 // ProcessOrders mocks base method.
 func (m *MockOrderManagementClient) ProcessOrders(arg0 context.Context, arg1 ...grpc.CallOption) (__.OrderManagement_ProcessOrdersClient, error) {
 	//m.ctrl.T.Helper()
@@ -55,3 +116,43 @@ func (mr *MockOrderManagementClientMockRecorder) ProcessOrders(arg0 interface{},
 	varargs := append([]interface{}{arg0}, arg1...)
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ProcessOrders", reflect.TypeOf((*MockOrderManagementClient)(nil).ProcessOrders), varargs...)
 }
+
+// SearchOrders mocks base method.
+func (m *MockOrderManagementClient) SearchOrders(arg0 context.Context, arg1 *wrappers.StringValue, arg2 ...grpc.CallOption) (__.OrderManagement_SearchOrdersClient, error) {
+	//m.ctrl.T.Helper()
+	varargs := []interface{}{arg0, arg1}
+	for _, a := range arg2 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "SearchOrders", varargs...)
+	ret0, _ := ret[0].(__.OrderManagement_SearchOrdersClient)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SearchOrders indicates an expected call of SearchOrders.
+func (mr *MockOrderManagementClientMockRecorder) SearchOrders(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+	//mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0, arg1}, arg2...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SearchOrders", reflect.TypeOf((*MockOrderManagementClient)(nil).SearchOrders), varargs...)
+}
+
+// UpdateOrder mocks base method.
+func (m *MockOrderManagementClient) UpdateOrder(arg0 context.Context, arg1 *__.Order, arg2 ...grpc.CallOption) (*wrappers.StringValue, error) {
+	//m.ctrl.T.Helper()
+	varargs := []interface{}{arg0, arg1}
+	for _, a := range arg2 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "UpdateOrder", varargs...)
+	ret0, _ := ret[0].(*wrappers.StringValue)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateOrder indicates an expected call of UpdateOrder.
+func (mr *MockOrderManagementClientMockRecorder) UpdateOrder(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+	//mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0, arg1}, arg2...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateOrder", reflect.TypeOf((*MockOrderManagementClient)(nil).UpdateOrder), varargs...)
+}
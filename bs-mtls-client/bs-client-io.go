@@ -0,0 +1,158 @@
+// Ввод ID заказов и вывод партий. Input of order IDs and output of shipments
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	pb "github.com/blablatov/bidistream-mtls-grpc/bs-mtls-proto"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const (
+	formatTable  = "table"
+	formatJSON   = "json"
+	formatNDJSON = "ndjson"
+)
+
+// Returns next ID or io.EOF. Возвращает следующий ID или io.EOF
+type idSource func() (string, error)
+
+// IDs from arguments. ID из аргументов командной строки
+func argIDs(args []string) idSource {
+	return func() (string, error) {
+		for len(args) > 0 {
+			id := strings.TrimSpace(args[0])
+			args = args[1:]
+			if id != "" {
+				return id, nil
+			}
+		}
+		return "", io.EOF
+	}
+}
+
+// IDs from newline or comma separated input, read lazily. Lines starting with # are skipped
+// ID из ввода, разделенного переводами строк или запятыми. Строки с # пропускаются
+func readerIDs(r io.Reader) idSource {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.Comment = '#'
+	cr.TrimLeadingSpace = true
+	var pending []string
+	return func() (string, error) {
+		for {
+			for len(pending) > 0 {
+				id := strings.TrimSpace(pending[0])
+				pending = pending[1:]
+				if id != "" {
+					return id, nil
+				}
+			}
+			record, err := cr.Read()
+			if err != nil {
+				return "", err
+			}
+			pending = record
+		}
+	}
+}
+
+// Printer of shipments. Печать партий заказов
+type shipmentPrinter interface {
+	print(*pb.CombinedShipment) error
+	close() error
+}
+
+func newShipmentPrinter(format string, w io.Writer) (shipmentPrinter, error) {
+	switch format {
+	case formatTable:
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "SEQ\tSHIPMENT\tSTATUS\tTRIGGER\tORDERS")
+		return &tablePrinter{tw: tw}, nil
+	case formatJSON:
+		return &jsonPrinter{w: w}, nil
+	case formatNDJSON:
+		return &ndjsonPrinter{w: w}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q, want table, json or ndjson", format)
+}
+
+// Table is aligned when stream ends. Таблица выравнивается по завершении потока
+type tablePrinter struct {
+	tw *tabwriter.Writer
+}
+
+func (p *tablePrinter) print(comb *pb.CombinedShipment) error {
+	var orders []string
+	for _, o := range comb.GetOrdersList() {
+		orders = append(orders, o.GetId())
+	}
+	if r := comb.GetRejected(); r != nil {
+		for _, v := range r.GetViolations() {
+			orders = append(orders, v.GetField()+": "+v.GetDescription())
+		}
+	}
+	trigger := "-"
+	if comb.GetTrigger() != pb.FlushTrigger_FLUSH_TRIGGER_UNSPECIFIED {
+		trigger = strings.TrimPrefix(comb.GetTrigger().String(), "FLUSH_TRIGGER_")
+	}
+	_, err := fmt.Fprintf(p.tw, "%d\t%s\t%s\t%s\t%s\n",
+		comb.GetSequence(), comb.GetId(), comb.GetStatus(), trigger, strings.Join(orders, ", "))
+	return err
+}
+
+func (p *tablePrinter) close() error {
+	return p.tw.Flush()
+}
+
+// One JSON array of shipments. Один JSON-массив партий
+type jsonPrinter struct {
+	w io.Writer
+	n int
+}
+
+func (p *jsonPrinter) print(comb *pb.CombinedShipment) error {
+	data, err := protojson.Marshal(comb)
+	if err != nil {
+		return err
+	}
+	sep := ",\n  "
+	if p.n == 0 {
+		sep = "[\n  "
+	}
+	p.n++
+	_, err = fmt.Fprintf(p.w, "%s%s", sep, data)
+	return err
+}
+
+func (p *jsonPrinter) close() error {
+	if p.n == 0 {
+		_, err := fmt.Fprintln(p.w, "[]")
+		return err
+	}
+	_, err := fmt.Fprintln(p.w, "\n]")
+	return err
+}
+
+// One JSON object per line, printed as shipments arrive. Один JSON-объект на строку по мере поступления
+type ndjsonPrinter struct {
+	w io.Writer
+}
+
+func (p *ndjsonPrinter) print(comb *pb.CombinedShipment) error {
+	data, err := protojson.Marshal(comb)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(p.w, "%s\n", data)
+	return err
+}
+
+func (p *ndjsonPrinter) close() error {
+	return nil
+}
@@ -0,0 +1,116 @@
+// Тестирование ввода ID и вывода партий без подключения к серверу
+// Testing of ID input and shipment output without server
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	pb "github.com/blablatov/bidistream-mtls-grpc/bs-mtls-proto"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Collects all IDs of source. Собирает все ID источника
+func collectIDs(t *testing.T, src idSource) []string {
+	t.Helper()
+	var ids []string
+	for {
+		id, err := src()
+		if err == io.EOF {
+			return ids
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+}
+
+func TestReaderIDs(t *testing.T) {
+	in := "102\n# comment\n103, 104,\n\n105\r\n106,11"
+	got := strings.Join(collectIDs(t, readerIDs(strings.NewReader(in))), " ")
+	if want := "102 103 104 105 106 11"; got != want {
+		t.Errorf("readerIDs() = %q, want %q", got, want)
+	}
+}
+
+func TestArgIDs(t *testing.T) {
+	got := strings.Join(collectIDs(t, argIDs([]string{"102", " ", "103"})), " ")
+	if want := "102 103"; got != want {
+		t.Errorf("argIDs() = %q, want %q", got, want)
+	}
+}
+
+var testShipments = []*pb.CombinedShipment{
+	{
+		Id: "cmb - Moscow", Status: "Processed!", Sequence: 1,
+		Trigger:    pb.FlushTrigger_FLUSH_TRIGGER_END_OF_STREAM,
+		OrdersList: []*pb.Order{{Id: "11", Destination: "Moscow"}},
+	},
+	{
+		Id: "rej - 999", Status: "Rejected!", Sequence: 2,
+		Rejected: &pb.RejectedOrder{Id: "999"},
+	},
+}
+
+func printShipments(t *testing.T, format string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	p, err := newShipmentPrinter(format, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, comb := range testShipments {
+		if err := p.print(comb); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestShipmentPrinter_Table(t *testing.T) {
+	out := printShipments(t, formatTable)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "SEQ") {
+		t.Fatalf("table = %q", out)
+	}
+	if !strings.Contains(lines[1], "END_OF_STREAM") || !strings.Contains(lines[1], "11") {
+		t.Errorf("row 1 = %q", lines[1])
+	}
+}
+
+func TestShipmentPrinter_JSON(t *testing.T) {
+	var arr []json.RawMessage
+	if err := json.Unmarshal([]byte(printShipments(t, formatJSON)), &arr); err != nil || len(arr) != 2 {
+		t.Fatalf("json = %v, %v", arr, err)
+	}
+	comb := &pb.CombinedShipment{}
+	if err := protojson.Unmarshal(arr[1], comb); err != nil || comb.GetRejected().GetId() != "999" {
+		t.Errorf("second shipment = %v, %v", comb, err)
+	}
+}
+
+func TestShipmentPrinter_NDJSON(t *testing.T) {
+	lines := strings.Split(strings.TrimSpace(printShipments(t, formatNDJSON)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("ndjson lines = %d, want 2", len(lines))
+	}
+	for _, l := range lines {
+		if !json.Valid([]byte(l)) {
+			t.Errorf("line is not JSON: %q", l)
+		}
+	}
+}
+
+func TestNewShipmentPrinter_Unknown(t *testing.T) {
+	if _, err := newShipmentPrinter("xml", io.Discard); err == nil {
+		t.Errorf("newShipmentPrinter(xml) = nil error")
+	}
+}
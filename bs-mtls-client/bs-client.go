@@ -1,23 +1,34 @@
+// gRPC-клиент. Командная строка: bs-client process [флаги] [ID...], bs-client health [флаги]
+// gRPC-client. Command line: bs-client process [flags] [ID...], bs-client health [flags]
+
 package main
 
 import (
 	"context"
-	"crypto/tls"
-	"crypto/x509"
-
+	"errors"
+	"flag"
+	"fmt"
 	"io"
-	"io/ioutil"
-	"log"
+	"log/slog"
+	"net/url"
+	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
-	pb "github.com/blablatov/bidistream-mtls-grpc/bs-mtls-proto"
-	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/blablatov/bidistream-mtls-grpc/bs-logging"
+	"github.com/blablatov/bidistream-mtls-grpc/bs-mtls-client/orderclient"
+	"github.com/blablatov/bidistream-mtls-grpc/bs-tracing"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
-	"google.golang.org/grpc/credentials/oauth"
 	"google.golang.org/grpc/encoding/gzip"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
 )
 
 var (
@@ -31,144 +42,323 @@ const (
 	hostname = "localhost"
 )
 
-func main() {
-	log.SetPrefix("Client event: ")
-	log.SetFlags(log.Lshortfile)
+const usage = `Usage: bs-client <command> [flags]
 
-	// Set up the credentials for the connection
-	// Значение токена OAuth2. Используем строку, прописанную в коде
-	autok := oauth.NewOauthAccess(fetchToken())
+Commands:
+  process   stream order IDs to ProcessOrders and print shipments
+  health    check serving status of server or service
 
-	// Load the client certificates from disk
-	// Создаем пары ключей X.509 непосредственно из ключа и сертификата сервера
-	certificate, err := tls.LoadX509KeyPair(crtFile, keyFile)
-	if err != nil {
-		log.Fatalf("could not load client key pair: %s", err)
+Run "bs-client <command> -h" for flags of command.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
 	}
 
-	// Create a certificate pool from the certificate authority
-	// Генерируем пул сертификатов в нашем локальном удостоверяющем центре
-	certPool := x509.NewCertPool()
-	ca, err := ioutil.ReadFile(caFile)
+	var err error
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "process":
+		err = runProcess(args, os.Stdin, os.Stdout)
+	case "health":
+		err = runHealth(args, os.Stdout)
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(os.Stdout, usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", cmd, usage)
+		os.Exit(2)
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
 	if err != nil {
-		log.Fatalf("could not read ca certificate: %s", err)
+		slog.Error("command failed", "err", err)
+		os.Exit(1)
 	}
+}
+
+// Flags of connection to server. Флаги подключения к серверу
+type connFlags struct {
+	addr       string
+	serverName string
+	crtFile    string
+	keyFile    string
+	caFile     string
+	token      string
+	timeout    time.Duration
+
+	// Token sources, precedence: token-url, token-file, token. Источники токена в порядке приоритета
+	tokenFile    string
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	scopes       string
+	audience     string
+	refreshAhead time.Duration
+
+	reload time.Duration
+	ocsp   bool
+
+	traceExporter string
+	traceEndpoint string
+
+	logLevel  string
+	logFormat string
+}
+
+func (c *connFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.addr, "addr", address, "address of server")
+	fs.StringVar(&c.serverName, "server-name", hostname, "expected name in server certificate")
+	fs.StringVar(&c.crtFile, "cert", crtFile, "client certificate")
+	fs.StringVar(&c.keyFile, "key", keyFile, "client private key")
+	fs.StringVar(&c.caFile, "ca", caFile, "CA certificate of server")
+	fs.StringVar(&c.token, "token", fetchToken().AccessToken, "static bearer token")
+	fs.StringVar(&c.tokenFile, "token-file", "", "file with bearer token or JSON oauth2 token, reread on change")
+	fs.StringVar(&c.tokenURL, "token-url", "", "OAuth2 token endpoint of client-credentials flow")
+	fs.StringVar(&c.clientID, "client-id", "", "client ID of client-credentials flow")
+	fs.StringVar(&c.clientSecret, "client-secret", "", "client secret of client-credentials flow, empty - env BS_CLIENT_SECRET")
+	fs.StringVar(&c.scopes, "scopes", "", "comma separated scopes of requested token")
+	fs.StringVar(&c.audience, "audience", "", "audience parameter of token request, if endpoint requires it")
+	fs.DurationVar(&c.refreshAhead, "token-refresh-ahead", orderclient.DefaultRefreshAhead, "renew token of -token-url this long before expiry")
+	fs.DurationVar(&c.timeout, "timeout", 30*time.Second, "deadline of call, 0 - none")
+	fs.DurationVar(&c.reload, "cert-reload", 0, "polling period of rotated cert, key and CA files, 0 - no reload")
+	fs.BoolVar(&c.ocsp, "require-ocsp", false, "require stapled OCSP response of server certificate")
+	fs.StringVar(&c.traceExporter, "trace-exporter", tracing.ExporterNone, "exporter of spans of calls: none, stdout (to stderr) or otlp")
+	fs.StringVar(&c.traceEndpoint, "trace-endpoint", "", "OTLP collector host:port, http:// prefix - without TLS, empty - OTEL_EXPORTER_OTLP_ENDPOINT")
+	fs.StringVar(&c.logLevel, "log-level", "info", "level of log to stderr: debug, info, warn or error, messages of streams are logged at debug")
+	fs.StringVar(&c.logFormat, "log-format", logging.FormatText, "format of log: text or json")
+}
 
-	// Append the certificates from the CA
-	// Добавляем клиентские сертификаты из локального удостоверяющего центра в сгенерированный пул
-	if ok := certPool.AppendCertsFromPEM(ca); !ok {
-		log.Fatalf("failed to append ca certs")
+// Sets default logger of flags writing to w, also of standard log package
+// Задает журнал по умолчанию из флагов, пишущий в w, также и для стандартного пакета log
+func (c *connFlags) logging(w io.Writer) error {
+	l, err := logging.New(w, c.logLevel, c.logFormat)
+	if err != nil {
+		return err
 	}
+	slog.SetDefault(l)
+	return nil
+}
 
-	opts := []grpc.DialOption{
-		// Register interceptor of stream. Регистрация потокового перехватчика
-		grpc.WithStreamInterceptor(clientStreamInterceptor),
-		// Указываем один и тот же токен OAuth в параметрах всех вызовов в рамках одного соединения
-		// Если нужно указывать токен для каждого вызова отдельно, используем CallOption
-		grpc.WithPerRPCCredentials(autok),
-		// Transport credentials.
-		// Указываем транспортные аутентификационные данные в виде параметров соединения
-		// Поле ServerName должно быть равно значению Common Name, указанному в сертификате
-		grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
-			ServerName:   hostname,
-			Certificates: []tls.Certificate{certificate},
-			RootCAs:      certPool,
-		})),
-	}
-
-	// Set up a connection to the server
-	// Устанавливаем безопасное соединение с сервером, передаем параметры аутентификации
-	conn, err := grpc.Dial(address, opts...)
+// Sets up tracing of calls, returned func flushes spans
+// Настраивает трассировку вызовов, возвращаемая функция сбрасывает спаны
+func (c *connFlags) tracing(ctx context.Context) (func(), error) {
+	shutdown, err := tracing.Setup(ctx, c.traceExporter, c.traceEndpoint, "bs-client", os.Stderr)
 	if err != nil {
-		log.Fatalf("Did not connect: %v", err)
+		return nil, err
 	}
-	defer conn.Close()
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdown(ctx); err != nil {
+			slog.Error("failed to flush spans", "err", err)
+		}
+	}, nil
+}
 
-	// Передаем соединение и создаем заглушку.
-	// Ее экземпляр содержит все удаленные методы, которые можно вызвать на сервере
-	client := pb.NewOrderManagementClient(conn)
+// Set up a connection to the server
+// Устанавливаем безопасное соединение с сервером, передаем параметры аутентификации
+func (c *connFlags) dial(ctx context.Context) (*orderclient.Client, error) {
+	return orderclient.Dial(ctx, orderclient.Options{
+		Addr:        c.addr,
+		ServerName:  c.serverName,
+		CertFile:    c.crtFile,
+		KeyFile:     c.keyFile,
+		CAFile:      c.caFile,
+		Token:       c.token,
+		TokenSource: c.tokenSource(ctx),
+
+		ReloadInterval:    c.reload,
+		RequireOCSPStaple: c.ocsp,
+		// Register interceptor of stream. Регистрация потокового перехватчика
+		StreamInterceptors: []grpc.StreamClientInterceptor{clientStreamInterceptor},
+	})
+}
 
-	// Finding of Duration. Тестированием определить оптимальное значение для крайнего срока кпд
-	clientDeadline := time.Now().Add(time.Duration(5000 * time.Millisecond))
-	ctx, cancel := context.WithDeadline(context.Background(), clientDeadline)
-	//ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+// Token source of flags, nil - static token. Источник токена из флагов, nil - постоянный токен
+func (c *connFlags) tokenSource(ctx context.Context) oauth2.TokenSource {
+	switch {
+	case c.tokenURL != "":
+		// Secret of environment is not flag default, so that usage does not print it
+		// Секрет из окружения не задается значением флага по умолчанию, чтобы справка его не выводила
+		secret := c.clientSecret
+		if secret == "" {
+			secret = os.Getenv("BS_CLIENT_SECRET")
+		}
+		cfg := &clientcredentials.Config{
+			ClientID:     c.clientID,
+			ClientSecret: secret,
+			TokenURL:     c.tokenURL,
+		}
+		if c.scopes != "" {
+			cfg.Scopes = strings.Split(c.scopes, ",")
+		}
+		if c.audience != "" {
+			cfg.EndpointParams = url.Values{"audience": {c.audience}}
+		}
+		return orderclient.ClientCredentials(ctx, cfg, c.refreshAhead)
+	case c.tokenFile != "":
+		return orderclient.FileTokenSource(c.tokenFile)
+	}
+	return nil
+}
 
-	defer cancel()
+// Context with deadline of call. Контекст с крайним сроком вызова
+func (c *connFlags) context() (context.Context, context.CancelFunc) {
+	if c.timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), c.timeout)
+}
 
-	// Process Order : Bi-distreaming scenario
-	// Вызываем удаленный метод и получаем ссылку на поток записи и чтения на клиентской стороне
-	streamProcOrder, err := client.ProcessOrders(ctx, grpc.UseCompressor(gzip.Name))
+// Command process: reads IDs, streams them and prints shipments
+// Команда process: читает ID, передает их потоком и печатает партии
+func runProcess(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("process", flag.ContinueOnError)
+	var conn connFlags
+	conn.register(fs)
+	idFile := fs.String("file", "", `newline or comma separated file of IDs, "-" - stdin`)
+	format := fs.String("format", formatTable, "output format: table, json or ndjson")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: bs-client process [flags] [ID...]\n"+
+			"IDs are read from arguments, -file, or stdin if both are absent.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := conn.logging(os.Stderr); err != nil {
+		return err
+	}
+	printer, err := newShipmentPrinter(*format, stdout)
 	if err != nil {
-		log.Fatalf("%v.ProcessOrders(_) = _, %v", client, err)
+		return err
 	}
 
-	mp := map[string]int{
-		"101": 101,
-		"102": 102,
-		"106": 106,
-		"104": 104,
-		"105": 105,
-		"11":  11,
-		"103": 103,
+	// Source of IDs. Источник ID заказов
+	var src idSource
+	switch {
+	case *idFile == "-" || (*idFile == "" && fs.NArg() == 0):
+		src = readerIDs(stdin)
+	case *idFile != "":
+		f, err := os.Open(*idFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		src = readerIDs(f)
+	default:
+		src = argIDs(fs.Args())
 	}
 
-	for k, v := range mp {
-		if k != "" && k != "0" {
-			// Sends IDs. Отправляем сообщения с ID сервису
-			if err := streamProcOrder.Send(&wrappers.StringValue{Value: k}); err != nil {
-				log.Fatalf("%v.Send(%v) = %v", client, k, err)
-			}
-		} else {
-			log.Printf("ID not found(%s) = %b", k, v)
-		}
+	// Spans are flushed after call ends. Спаны сбрасываются после завершения вызова
+	flushSpans, err := conn.tracing(context.Background())
+	if err != nil {
+		return err
 	}
+	defer flushSpans()
 
-	// if err := streamProcOrder.Send(&wrappers.StringValue{Value: "103"}); err != nil {
-	// 	log.Fatalf("%v.Send(%v) = %v", client, "103", err)
-	// }
+	ctx, cancel := conn.context()
+	defer cancel()
 
-	chs := make(chan struct{}) // Создаем канал для горутин (create chanel for goroutines)
-	//chs := make(chan int, 1)
-	// Вызываем функцию с помощью горутин, распараллеливаем чтение сообщений, возвращаемых сервисом
+	client, err := conn.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	// Reads IDs in goroutine, error of reading cancels call
+	// Читаем ID в горутине, ошибка чтения отменяет вызов
+	ids := make(chan string)
+	readErr := make(chan error, 1)
 	go func() {
-		asncClientBidirectionalRPC(streamProcOrder, chs)
-		chs <- struct{}{}
+		defer close(ids)
+		if err := feedIDs(ctx, src, ids); err != nil {
+			readErr <- err
+			cancel()
+		}
 	}()
 
-	time.Sleep(time.Millisecond * 500) //  Wait time. Имитируем задержку при отправке сервису сообщений.
+	// Process Order : Bi-distreaming scenario
+	// Вызываем удаленный метод и читаем партии, пока сервер не завершит поток
+	shipments, errc := client.ProcessOrders(ctx, ids, grpc.UseCompressor(gzip.Name))
+	for comb := range shipments {
+		if err := printer.print(comb); err != nil {
+			cancel()
+			for range shipments {
+			}
+			return err
+		}
+	}
+	err = <-errc
+	select {
+	case rerr := <-readErr:
+		err = rerr
+	default:
+	}
+	// Received shipments are printed even if stream failed. Полученные партии печатаются и при ошибке потока
+	if cerr := printer.close(); err == nil {
+		err = cerr
+	}
+	return err
+}
 
-	// Сигнализируем о завершении клиентского потока (с ID заказов)
-	// Signal about close stream of client
-	if err := streamProcOrder.CloseSend(); err != nil {
-		log.Fatal(err)
+// Command health: prints serving status, fails unless service is serving
+// Команда health: печатает статус обслуживания, ошибка, если сервис не обслуживает
+func runHealth(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("health", flag.ContinueOnError)
+	var conn connFlags
+	conn.register(fs)
+	service := fs.String("service", "", `service to check, e.g. ecommerce.OrderManagement, "" - whole server`)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: bs-client health [flags]\n"+
+			"Exit status is 1 unless status is SERVING.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := conn.logging(os.Stderr); err != nil {
+		return err
 	}
 
-	// Cancelling the RPC. Отмена удаленного вызова gRPC на клиентской стороне
-	cancel()
-	log.Printf("RPC Status : %v", ctx.Err()) // Status of context. Состояние текущего контекста
+	ctx, cancel := conn.context()
+	defer cancel()
+	client, err := conn.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
 
-	//chs <- struct{}{}
-	<-chs
+	st, err := client.Health(ctx, *service)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, st)
+	if st != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("service %q is %v", *service, st)
+	}
+	return nil
 }
 
-func asncClientBidirectionalRPC(streamProcOrder pb.OrderManagement_ProcessOrdersClient, c chan struct{}) {
+// Passes IDs of source to channel. Передает ID источника в канал
+func feedIDs(ctx context.Context, src idSource, ids chan<- string) error {
 	for {
-		// Read messages on side of client
-		// Читаем сообщения сервиса на клиентской стороне
-		combinedShipment, errProcOrder := streamProcOrder.Recv()
-
-		if errProcOrder != nil {
-			log.Printf("Error Receiving messages: %v", errProcOrder)
-			break
-		} else {
-			if errProcOrder == io.EOF { // End of stream. Обнаружение конца потока.
-				break
-			}
-			log.Println("Combined shipment : ", combinedShipment.Status, combinedShipment.OrdersList)
+		id, err := src()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		select {
+		case ids <- id:
+		case <-ctx.Done():
+			return nil
 		}
 	}
-	//c <- struct{}{} // break
-	<-c // loop
 }
 
 // Provides OAuth2 connection token
@@ -179,44 +369,104 @@ func fetchToken() *oauth2.Token {
 	}
 }
 
-// Client stream interceptor in gRPC
-// Клиентский потоковый перехватчик в gRPC
+// Client stream interceptor in gRPC, starts span of stream and passes its trace context to server
+// Клиентский потоковый перехватчик в gRPC, начинает спан потока и передает его контекст трассировки серверу
 func clientStreamInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn,
 	method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
 
 	// Preprocessing stage, haves access to RPC request before sent to server
 	// Этап предобработки, есть доступ к RPC-запросу перед его отправкой на сервер
-	log.Println("===== [Client Interceptor] ", method)
-
-	s, err := streamer(ctx, desc, cc, method, opts...) // Call func streamer. Вызов функции streamer
+	ctx, span := tracing.Tracer().Start(ctx, method, trace.WithSpanKind(trace.SpanKindClient))
+	l := logging.FromContext(ctx).With("method", method)
+	if sc := span.SpanContext(); sc.IsValid() {
+		l = l.With("trace", sc.TraceID().String())
+	}
+	l.Debug("stream opening")
+	s, err := streamer(tracing.Inject(logging.NewContext(ctx, l)), desc, cc, method, opts...) // Call func streamer. Вызов функции streamer
 	if err != nil {
+		l.Warn("stream failed", "err", err)
+		endSpan(span, err)
 		return nil, err
 	}
 	// Creating wrapper around Client Stream interface, with intercept and go back to app
 	// Создание обертки вокруг интерфейса ClientStream, с перехватом и возвращением приложению
-	return newWrappedStream(s), nil
+	return newWrappedStream(ctx, s, span, l), nil
 }
 
 // Wrapper for interface of rpc.ClientStream
 // Обертка для интерфейса grpc.ClientStream
 type wrappedStream struct {
 	grpc.ClientStream
+	span trace.Span
+	log  *slog.Logger  // Logger of stream. Журнал потока
+	once sync.Once     // Span ends once. Спан завершается один раз
+	done chan struct{} // Closed at end of stream. Закрывается в конце потока
 }
 
 // Func for intercepting received messages of streaming gRPC
 // Функция для перехвата принимаемых сообщений потокового gRPC
 func (w *wrappedStream) RecvMsg(m interface{}) error {
-	log.Printf("===== [Client Stream Interceptor] Receive a message (Type: %T) at %v", m, time.Now().Format(time.RFC3339))
-	return w.ClientStream.RecvMsg(m)
+	err := w.ClientStream.RecvMsg(m)
+	switch {
+	case err == nil:
+		// Each message only at debug level. Каждое сообщение только на уровне debug
+		w.log.Debug("message received", "type", fmt.Sprintf("%T", m))
+		w.span.AddEvent(tracing.EventRecv, trace.WithAttributes(tracing.Attributes(m)...))
+	case err == io.EOF: // Server ended stream. Сервер завершил поток
+		w.end(nil)
+	default:
+		w.end(err)
+	}
+	return err
 }
 
 // Func for intercepting sended messages of streaming gRPC
 // Функция для перехвата отправляемых сообщений потокового gRPC
 func (w *wrappedStream) SendMsg(m interface{}) error {
-	log.Printf("===== [Client Stream Interceptor] Send a message (Type: %T) at %v", m, time.Now().Format(time.RFC3339))
-	return w.ClientStream.SendMsg(m)
+	err := w.ClientStream.SendMsg(m)
+	if err == nil {
+		w.log.Debug("message sent", "type", fmt.Sprintf("%T", m))
+		w.span.AddEvent(tracing.EventSend, trace.WithAttributes(tracing.Attributes(m)...))
+	}
+	return err
 }
 
-func newWrappedStream(s grpc.ClientStream) grpc.ClientStream {
-	return &wrappedStream{s}
+func (w *wrappedStream) end(err error) {
+	w.once.Do(func() {
+		if err != nil {
+			w.log.Warn("stream failed", "err", err)
+		} else {
+			w.log.Debug("stream closed")
+		}
+		endSpan(w.span, err)
+		close(w.done)
+	})
+}
+
+// Span of stream abandoned without reading its end is ended by cancellation of call,
+// watching goroutine exits at end of stream, so long-lived ctx does not keep it
+// Спан потока, брошенного без чтения до конца, завершается отменой вызова,
+// наблюдающая горутина выходит в конце потока, поэтому долгий ctx ее не удерживает
+func newWrappedStream(ctx context.Context, s grpc.ClientStream, span trace.Span, l *slog.Logger) grpc.ClientStream {
+	w := &wrappedStream{ClientStream: s, span: span, log: l, done: make(chan struct{})}
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				w.end(ctx.Err())
+			case <-w.done:
+			}
+		}()
+	}
+	return w
+}
+
+// Ends span with status of call. Завершает спан со статусом вызова
+func endSpan(span trace.Span, err error) {
+	span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int(int(status.Code(err))))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, status.Convert(err).Message())
+	}
+	span.End()
 }
@@ -5,227 +5,104 @@
 package main
 
 import (
+	"bytes"
 	"context"
-	"crypto/tls"
-	"crypto/x509"
+	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/blablatov/bidistream-mtls-grpc/bs-mtls-client/orderclient"
 	pb "github.com/blablatov/bidistream-mtls-grpc/bs-mtls-proto"
+	"github.com/blablatov/bidistream-mtls-grpc/bs-tracing"
 	"github.com/golang/protobuf/ptypes/wrappers"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/oauth2"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
-	"google.golang.org/grpc/credentials/oauth"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
-// Conventional test that starts a gRPC client test the service with RPC
-// Традиционный тест, который запускает клиент для проверки удаленного метода сервиса
-func TestClient_ProcessOrders(t *testing.T) {
-	log.SetPrefix("Client-test event: ")
-	log.SetFlags(log.Lshortfile)
-
-	tokau := oauth.NewOauthAccess(fetchToken())
-
-	// Load the client certificates from disk
-	// Создаем пары ключей X.509 непосредственно из ключа и сертификата сервера
-	certificate, err := tls.LoadX509KeyPair(crtFile, keyFile)
-	if err != nil {
-		log.Fatalf("could not load client key pair: %s", err)
-	}
-
-	// Create a certificate pool from the certificate authority
-	// Генерируем пул сертификатов в нашем локальном удостоверяющем центре
-	certPool := x509.NewCertPool()
-	ca, err := ioutil.ReadFile(caFile)
+// Connects to running server with certificates of bs-mcerts. Подключается к запущенному серверу с сертификатами bs-mcerts
+func dialTestServer(ctx context.Context) *orderclient.Client {
+	client, err := orderclient.Dial(ctx, orderclient.Options{
+		Addr:       address,
+		ServerName: hostname, // NOTE: this is required!
+		CertFile:   crtFile,
+		KeyFile:    keyFile,
+		CAFile:     caFile,
+		Token:      fetchToken().AccessToken,
+	})
 	if err != nil {
-		log.Fatalf("could not read ca certificate: %s", err)
-	}
-
-	// Append the certificates from the CA
-	// Добавляем клиентские сертификаты из локального удостоверяющего центра в сгенерированный пул
-	if ok := certPool.AppendCertsFromPEM(ca); !ok {
-		log.Fatalf("failed to append ca certs")
+		log.Fatalf("did not connect: %v", err)
 	}
+	return client
+}
 
-	// Указываем аутентификационные данные для транспортного протокола с помощью DialOption
-	opts := []grpc.DialOption{
-		// Указываем один и тот же токен OAuth в параметрах всех вызовов в рамках одного соединения
-		// Если нужно указывать токен для каждого вызова отдельно, используем CallOption
-		grpc.WithPerRPCCredentials(tokau),
-		// Указываем транспортные аутентификационные данные в виде параметров соединения
-		// Поле ServerName должно быть равно значению Common Name, указанному в сертификате
-		grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
-			ServerName:   hostname, // NOTE: this is required!
-			Certificates: []tls.Certificate{certificate},
-			RootCAs:      certPool,
-		})),
+// Streams IDs and logs shipments until server ends stream. Передает ID и пишет партии, пока сервер не завершит поток
+func processTestIDs(ctx context.Context, client *orderclient.Client, ids []string) error {
+	idc := make(chan string, len(ids))
+	for _, id := range ids {
+		idc <- id
 	}
-
-	conn, err := grpc.Dial(address, opts...)
-	if err != nil {
-		log.Fatalf("did not connect: %v", err)
+	close(idc)
+	// Process Order : Bi-distreaming scenario
+	// Вызываем удаленный метод, передаем ID из канала и читаем партии
+	shipments, errc := client.ProcessOrders(ctx, idc, grpc.UseCompressor(gzip.Name))
+	for comb := range shipments {
+		log.Printf("combined shipment: status %s, orders %d", comb.Status, len(comb.OrdersList))
 	}
-	defer conn.Close()
+	return <-errc
+}
 
-	client := pb.NewOrderManagementClient(conn)
+// Conventional test that starts a gRPC client test the service with RPC
+// Традиционный тест, который запускает клиент для проверки удаленного метода сервиса
+func TestClient_ProcessOrders(t *testing.T) {
+	log.SetPrefix("Client-test event: ")
+	log.SetFlags(log.Lshortfile)
 
 	// Finding of Duration. Тестированием определить оптимальное значение для крайнего срока кпд
-	clientDeadline := time.Now().Add(time.Duration(2000 * time.Millisecond))
-	ctx, cancel := context.WithDeadline(context.Background(), clientDeadline)
-
+	ctx, cancel := context.WithTimeout(context.Background(), 2000*time.Millisecond)
 	defer cancel()
 
-	// Process Order : Bi-distreaming scenario
-	// Вызываем удаленный метод и получаем ссылку на поток записи и чтения на клиентской стороне
-	streamProcOrder, err := client.ProcessOrders(ctx, grpc.UseCompressor(gzip.Name))
-	if err != nil {
-		log.Fatalf("%v.ProcessOrders(_) = _, %v", client, err)
-	}
-
-	// IDs for test. Мапа с тестируемыми ID
-	mp := map[string]int{
-		"10":  10,
-		"102": 102,
-		"106": 106,
-		"104": 104,
-		"101": 101,
-		"11":  11,
-		"103": 103,
-	}
+	client := dialTestServer(ctx)
+	defer client.Close()
 
-	for k, v := range mp {
-		if k != "" && v != 0 {
-			// Отправляем сообщения сервису
-			if err := streamProcOrder.Send(&wrappers.StringValue{Value: k}); err != nil {
-				log.Fatalf("%v.Send(%v) = %v", client, k, err)
-			}
-		} else {
-			log.Printf("ID not found(%s) = %b", k, v)
-		}
+	// IDs for test. ID для теста
+	// Server must be running, rejected IDs only end stream. Сервер должен быть запущен, отклоненные ID лишь завершают поток
+	err := processTestIDs(ctx, client, []string{"10", "102", "106", "104", "101", "11", "103"})
+	if status.Code(err) == codes.Unavailable {
+		log.Fatalf("%v.ProcessOrders(_) = _, %v", client, err)
 	}
-
-	chs := make(chan struct{}) // Создаем канал для горутин (create chanel for goroutines)
-
-	// Вызываем функцию с помощью горутин, распараллеливаем чтение сообщений, возвращаемых сервисом
-	go func() {
-		asncClientBidirectionalRPC(streamProcOrder, chs)
-		chs <- struct{}{}
-		close(chs)
-	}()
-
-	time.Sleep(time.Millisecond * 500) // Имитируем задержку при отправке сервису сообщений. Wait time
-
-	// Сигнализируем о завершении клиентского потока (с ID заказов)
-	// Signal about close stream of client
-	if err := streamProcOrder.CloseSend(); err != nil {
-		log.Fatal(err)
+	if err != nil {
+		log.Printf("ProcessOrders: %v", err)
 	}
-
-	chs <- struct{}{}
 }
 
 // Тестирование производительности в цикле за указанное колличество итераций
 func BenchmarkTestClient_ProcessOrders(b *testing.B) {
 	b.ReportAllocs()
 	for i := 0; i < 250; i++ {
-		tokau := oauth.NewOauthAccess(fetchToken())
-
-		// Load the client certificates from disk
-		// Создаем пары ключей X.509 непосредственно из ключа и сертификата сервера
-		certificate, err := tls.LoadX509KeyPair(crtFile, keyFile)
-		if err != nil {
-			log.Fatalf("could not load client key pair: %s", err)
-		}
-
-		// Create a certificate pool from the certificate authority
-		// Генерируем пул сертификатов в нашем локальном удостоверяющем центре
-		certPool := x509.NewCertPool()
-		ca, err := ioutil.ReadFile(caFile)
-		if err != nil {
-			log.Fatalf("could not read ca certificate: %s", err)
-		}
-
-		// Append the certificates from the CA
-		// Добавляем клиентские сертификаты из локального удостоверяющего центра в сгенерированный пул
-		if ok := certPool.AppendCertsFromPEM(ca); !ok {
-			log.Fatalf("failed to append ca certs")
-		}
-
-		opts := []grpc.DialOption{
-			grpc.WithPerRPCCredentials(tokau),
-			// Указываем транспортные аутентификационные данные в виде параметров соединения
-			// Поле ServerName должно быть равно значению Common Name, указанному в сертификате
-			grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
-				ServerName:   hostname, // NOTE: this is required!
-				Certificates: []tls.Certificate{certificate},
-				RootCAs:      certPool,
-			})),
-		}
-
-		conn, err := grpc.Dial(address, opts...) // Подключаемся к серверному приложению
-		if err != nil {
-			log.Fatalf("did not connect: %v", err)
-		}
-		defer conn.Close()
-
-		client := pb.NewOrderManagementClient(conn)
-
-		// Finding of Duration. Тестированием определить оптимальное значение для крайнего срока кпд
-		clientDeadline := time.Now().Add(time.Duration(2000 * time.Millisecond))
-		ctx, cancel := context.WithDeadline(context.Background(), clientDeadline)
-
-		defer cancel()
-
-		// Process Order : Bi-distreaming scenario
-		// Вызываем удаленный метод и получаем ссылку на поток записи и чтения на клиентской стороне
-		streamProcOrder, err := client.ProcessOrders(ctx, grpc.UseCompressor(gzip.Name))
-		if err != nil {
-			log.Fatalf("%v.ProcessOrders(_) = _, %v", client, err)
-		}
-
-		// IDs for test. Мапа с тестируемыми ID
-		mp := map[string]int{
-			"101": 101,
-			"102": 102,
-			"106": 106,
-			"104": 104,
-			"105": 105,
-			"11":  11,
-			"103": 103,
-		}
-
-		for k, v := range mp {
-			if k != "" && v != 0 {
-				// Отправляем сообщения сервису
-				if err := streamProcOrder.Send(&wrappers.StringValue{Value: k}); err != nil {
-					log.Fatalf("%v.Send(%v) = %v", client, k, err)
-				}
-			} else {
-				log.Printf("ID not found(%s) = %b", k, v)
-			}
-		}
-
-		chs := make(chan struct{}) // Создаем канал для горутин (create chanel for goroutines)
-		// Вызываем функцию с помощью горутин, распараллеливаем чтение сообщений, возвращаемых сервисом
-		go asncClientBidirectionalRPC(streamProcOrder, chs)
-		time.Sleep(time.Millisecond * 100) // Имитируем задержку при отправке сервису сообщений. Wait time
-
-		// Сигнализируем о завершении клиентского потока (с ID заказов)
-		// Signal about close stream of client
-		if err := streamProcOrder.CloseSend(); err != nil {
-			log.Fatal(err)
+		ctx, cancel := context.WithTimeout(context.Background(), 2000*time.Millisecond)
+		client := dialTestServer(ctx)
+		if err := processTestIDs(ctx, client, []string{"101", "102", "106", "104", "105", "11", "103"}); err != nil {
+			log.Printf("ProcessOrders: %v", err)
 		}
-
+		client.Close()
 		// Cancelling the RPC. Отмена удаленного вызова gRPC на клиентской стороне
 		cancel()
-		log.Printf("RPC Status : %v", ctx.Err()) // Status of context. Состояние текущего контекста
-
-		chs <- struct{}{}
 	}
 }
 
@@ -241,6 +118,79 @@ func TestFetchToken(t *testing.T) {
 	}()
 }
 
+// Token source of flags. Источник токена из флагов
+func TestConnFlags_TokenSource(t *testing.T) {
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token": "cc-%s-%s", "token_type": "Bearer", "expires_in": 3600}`,
+			r.FormValue("scope"), r.FormValue("audience"))
+	}))
+	defer endpoint.Close()
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("file-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{nil, ""},
+		{[]string{"-token-file", tokenFile}, "file-token"},
+		{[]string{"-token-file", tokenFile, "-token-url", endpoint.URL, "-client-id", "bs-client",
+			"-scopes", "orders:read,orders:write", "-audience", "bs-mtls-service"}, "cc-orders:read orders:write-bs-mtls-service"},
+	} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		var conn connFlags
+		conn.register(fs)
+		if err := fs.Parse(tt.args); err != nil {
+			t.Fatal(err)
+		}
+		ts := conn.tokenSource(context.Background())
+		if ts == nil {
+			if tt.want != "" {
+				t.Errorf("tokenSource(%q) = nil", tt.args)
+			}
+			continue
+		}
+		tok, err := ts.Token()
+		if err != nil || tok.AccessToken != tt.want {
+			t.Errorf("tokenSource(%q).Token() = %v, %v, want %s", tt.args, tok, err, tt.want)
+		}
+	}
+}
+
+// Secret of environment is used, but not printed by usage. Секрет из окружения используется, но не выводится справкой
+func TestConnFlags_ClientSecretEnv(t *testing.T) {
+	t.Setenv("BS_CLIENT_SECRET", "s3cret")
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret := r.FormValue("client_secret")
+		if _, p, ok := r.BasicAuth(); ok {
+			secret = p
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token": "cc-%s", "token_type": "Bearer", "expires_in": 3600}`, secret)
+	}))
+	defer endpoint.Close()
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	var usage bytes.Buffer
+	fs.SetOutput(&usage)
+	var conn connFlags
+	conn.register(fs)
+	fs.PrintDefaults()
+	if strings.Contains(usage.String(), "s3cret") {
+		t.Errorf("usage prints client secret: %s", usage.String())
+	}
+	if err := fs.Parse([]string{"-token-url", endpoint.URL, "-client-id", "bs-client"}); err != nil {
+		t.Fatal(err)
+	}
+	tok, err := conn.tokenSource(context.Background()).Token()
+	if err != nil || tok.AccessToken != "cc-s3cret" {
+		t.Errorf("Token() = %v, %v, want cc-s3cret", tok, err)
+	}
+}
+
 // Сигнатура для тестирования типа Streamer. Signature of method to test
 type strmer interface {
 	Streamer(context.Context, *grpc.StreamDesc, *grpc.ClientConn, string, ...grpc.CallOption) (grpc.ClientStream, error)
@@ -273,3 +223,119 @@ func TestClientStreamInterceptor(t *testing.T) {
 		log.Println("Error Interceptor", err)
 	}
 }
+
+// Stream of one shipment, records outgoing metadata. Поток одной партии, запоминает исходящие метаданные
+type fakeClientStream struct {
+	grpc.ClientStream
+	md   metadata.MD
+	recv int
+}
+
+func (f *fakeClientStream) SendMsg(interface{}) error { return nil }
+
+func (f *fakeClientStream) RecvMsg(m interface{}) error {
+	if f.recv++; f.recv > 1 {
+		return io.EOF
+	}
+	*m.(*pb.CombinedShipment) = pb.CombinedShipment{Id: "cmb - 1", OrdersList: []*pb.Order{{Id: "102"}}}
+	return nil
+}
+
+// Span of stream carries its messages and is passed to server. Спан потока содержит его сообщения и передается серверу
+func TestClientStreamInterceptor_Trace(t *testing.T) {
+	exp, restore := tracing.SetupMemory()
+	defer restore()
+
+	fake := &fakeClientStream{}
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		fake.md, _ = metadata.FromOutgoingContext(ctx)
+		return fake, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s, err := clientStreamInterceptor(ctx, &grpc.StreamDesc{}, nil, "/ecommerce.OrderManagement/processOrders", streamer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SendMsg(&wrappers.StringValue{Value: "102"}); err != nil {
+		t.Fatal(err)
+	}
+	for err == nil {
+		err = s.RecvMsg(&pb.CombinedShipment{})
+	}
+
+	spans := exp.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("recorded %d spans, want 1", len(spans))
+	}
+	span := spans[0]
+	if span.SpanKind != trace.SpanKindClient || span.Status.Code != otelcodes.Unset {
+		t.Errorf("span kind %v, status %v", span.SpanKind, span.Status)
+	}
+	if tp := fake.md.Get("traceparent"); len(tp) != 1 || !strings.Contains(tp[0], span.SpanContext.SpanID().String()) {
+		t.Errorf("traceparent %v, want span %v", tp, span.SpanContext.SpanID())
+	}
+	if len(span.Events) != 2 ||
+		span.Events[0].Name != tracing.EventSend || span.Events[0].Attributes[0] != tracing.OrderID.String("102") ||
+		span.Events[1].Name != tracing.EventRecv || span.Events[1].Attributes[0] != tracing.ShipmentID.String("cmb - 1") {
+		t.Errorf("events %v, want send of 102 and recv of cmb - 1", span.Events)
+	}
+}
+
+// Streams read to end leave no goroutines with long-lived ctx. Потоки, прочитанные до конца, не оставляют горутин при долгом ctx
+func TestClientStreamInterceptor_NoLeak(t *testing.T) {
+	streamer := func(context.Context, *grpc.StreamDesc, *grpc.ClientConn, string, ...grpc.CallOption) (grpc.ClientStream, error) {
+		return &fakeClientStream{}, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	before := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		s, err := clientStreamInterceptor(ctx, &grpc.StreamDesc{}, nil, "/ecommerce.OrderManagement/processOrders", streamer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for err == nil {
+			err = s.RecvMsg(&pb.CombinedShipment{})
+		}
+	}
+	for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > before+10; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("goroutines %d after 100 ended streams, were %d", runtime.NumGoroutine(), before)
+		}
+	}
+}
+
+// Logger of flags writes messages of stream at debug. Журнал из флагов пишет сообщения потока на уровне debug
+func TestConnFlags_Logging(t *testing.T) {
+	var conn connFlags
+	fs := flag.NewFlagSet("process", flag.ContinueOnError)
+	conn.register(fs)
+	if err := fs.Parse([]string{"-log-level", "verbose"}); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := conn.logging(&out); err == nil {
+		t.Error("unknown level is accepted")
+	}
+	prev := slog.Default()
+	defer slog.SetDefault(prev)
+	conn.logLevel, conn.logFormat = "debug", "json"
+	if err := conn.logging(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	streamer := func(context.Context, *grpc.StreamDesc, *grpc.ClientConn, string, ...grpc.CallOption) (grpc.ClientStream, error) {
+		return &fakeClientStream{}, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s, err := clientStreamInterceptor(ctx, &grpc.StreamDesc{}, nil, "/ecommerce.OrderManagement/processOrders", streamer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SendMsg(&wrappers.StringValue{Value: "102"})
+	if !strings.Contains(out.String(), `"level":"DEBUG","msg":"message sent","method":"/ecommerce.OrderManagement/processOrders"`) {
+		t.Errorf("log %s has no debug record of sent message", out.String())
+	}
+}
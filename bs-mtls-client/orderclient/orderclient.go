@@ -0,0 +1,258 @@
+// Package orderclient is importable client of OrderManagement service with mTLS and OAuth2.
+// Пакет orderclient - импортируемый клиент сервиса OrderManagement с mTLS и OAuth2.
+package orderclient
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/blablatov/bidistream-mtls-grpc/bs-certwatch"
+	pb "github.com/blablatov/bidistream-mtls-grpc/bs-mtls-proto"
+	"github.com/blablatov/bidistream-mtls-grpc/bs-revocation"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"golang.org/x/oauth2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/oauth"
+	_ "google.golang.org/grpc/encoding/gzip" // Registers gzip compressor. Регистрация сжатия gzip
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// Options of connection to service. Параметры подключения к сервису
+type Options struct {
+	// Address of server, host:port. Адрес сервера
+	Addr string
+
+	// Name expected in server certificate, empty - host of Addr. Имя в сертификате сервера, пусто - хост из Addr
+	ServerName string
+
+	// Client key pair and CA of server, PEM files. Ключевая пара клиента и сертификат УЦ сервера
+	CertFile string
+	KeyFile  string
+	CAFile   string
+
+	// Polling period of rotated files while connection is open, 0 - files are loaded once
+	// Период опроса замененных файлов, пока соединение открыто, 0 - файлы загружаются однократно
+	ReloadInterval time.Duration
+
+	// Server must staple OCSP response, stapled response is verified anyway
+	// Сервер обязан прикрепить ответ OCSP, прикрепленный ответ проверяется в любом случае
+	RequireOCSPStaple bool
+
+	// Used instead of files and OCSP options, if set. Используется вместо файлов и параметров OCSP, если задан
+	TLSConfig *tls.Config
+
+	// Bearer token of all calls, TokenSource takes precedence over Token. Token is taken from source
+	// at start of every call, e.g. ClientCredentials or FileTokenSource renew it between calls and reconnects
+	// Токен OAuth в параметрах всех вызовов, TokenSource приоритетнее Token. Токен берется из источника
+	// в начале каждого вызова, например ClientCredentials или FileTokenSource обновляют его между вызовами
+	Token       string
+	TokenSource oauth2.TokenSource
+
+	// Interceptors of calls, in order of chaining. Перехватчики вызовов в порядке цепочки
+	UnaryInterceptors  []grpc.UnaryClientInterceptor
+	StreamInterceptors []grpc.StreamClientInterceptor
+
+	// Extra options, e.g. grpc.WithBlock(). Дополнительные параметры соединения
+	DialOptions []grpc.DialOption
+}
+
+// Client of service. Methods of pb.OrderManagementClient are promoted, except ProcessOrders,
+// which is replaced by channel helper. Raw stream is c.OrderManagementClient.ProcessOrders
+// Клиент сервиса. Методы pb.OrderManagementClient доступны напрямую, кроме ProcessOrders,
+// замененного помощником на каналах
+type Client struct {
+	pb.OrderManagementClient
+	health     healthpb.HealthClient
+	cc         *grpc.ClientConn
+	stopReload context.CancelFunc
+}
+
+// Dial sets up secure connection with client certificate and per-RPC credentials
+// Устанавливает безопасное соединение с сертификатом клиента и учетными данными вызовов
+func Dial(ctx context.Context, o Options) (*Client, error) {
+	if o.Addr == "" {
+		return nil, errors.New("orderclient: empty address")
+	}
+	tlsConfig, certs, err := o.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)),
+		grpc.WithChainUnaryInterceptor(o.UnaryInterceptors...),
+		grpc.WithChainStreamInterceptor(o.StreamInterceptors...),
+	}
+	ts := o.TokenSource
+	if ts == nil && o.Token != "" {
+		ts = oauth2.StaticTokenSource(&oauth2.Token{AccessToken: o.Token})
+	}
+	if ts != nil {
+		// Same token source for all calls of connection. Один источник токена для всех вызовов соединения
+		opts = append(opts, grpc.WithPerRPCCredentials(oauth.TokenSource{TokenSource: ts}))
+	}
+	opts = append(opts, o.DialOptions...)
+
+	cc, err := grpc.DialContext(ctx, o.Addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c := New(cc)
+	c.cc = cc
+	if certs != nil && o.ReloadInterval > 0 {
+		// Reload lives until Close, not until ctx of dial. Перезагрузка работает до Close, а не до конца ctx
+		var reloadCtx context.Context
+		reloadCtx, c.stopReload = context.WithCancel(context.Background())
+		go certs.Run(reloadCtx)
+	}
+	return c, nil
+}
+
+// New wraps existing connection, which is not closed by Client.Close
+// Оборачивает существующее соединение, Close его не закрывает
+func New(cc grpc.ClientConnInterface) *Client {
+	return &Client{OrderManagementClient: pb.NewOrderManagementClient(cc), health: healthpb.NewHealthClient(cc)}
+}
+
+// Health returns serving status of service, "" - whole server. Status is checked by client certificate,
+// token is sent if set
+// Возвращает статус обслуживания сервиса, "" - весь сервер. Статус проверяется по сертификату клиента,
+// токен передается, если задан
+func (c *Client) Health(ctx context.Context, service string, opts ...grpc.CallOption) (healthpb.HealthCheckResponse_ServingStatus, error) {
+	resp, err := c.health.Check(ctx, &healthpb.HealthCheckRequest{Service: service}, opts...)
+	if err != nil {
+		return healthpb.HealthCheckResponse_UNKNOWN, err
+	}
+	return resp.Status, nil
+}
+
+// Close closes connection created by Dial. Закрывает соединение, созданное Dial
+func (c *Client) Close() error {
+	if c.stopReload != nil {
+		c.stopReload()
+	}
+	if c.cc == nil {
+		return nil
+	}
+	return c.cc.Close()
+}
+
+// TLS configuration from options, provider is nil if TLSConfig is given
+// Настройки TLS из параметров, поставщик равен nil, если задан TLSConfig
+func (o Options) tlsConfig() (*tls.Config, *certwatch.Provider, error) {
+	if o.TLSConfig != nil {
+		return o.TLSConfig.Clone(), nil, nil
+	}
+
+	// Load the client certificates and CA from disk
+	// Загружаем ключевую пару клиента и сертификаты локального удостоверяющего центра
+	certs, err := certwatch.New(certwatch.Options{
+		CertFile: o.CertFile,
+		KeyFile:  o.KeyFile,
+		CAFile:   o.CAFile,
+		Interval: o.ReloadInterval,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	// Without ServerName certificate must match host of address. Без ServerName сертификат должен подходить хосту адреса
+	serverName := o.ServerName
+	if serverName == "" {
+		serverName, _, _ = net.SplitHostPort(o.Addr)
+	}
+	return certs.ClientConfig(&tls.Config{
+		ServerName:       serverName,
+		VerifyConnection: revocation.VerifyStaple(o.RequireOCSPStaple),
+	}), certs, nil
+}
+
+// ProcessOrders streams IDs until ids is closed and delivers shipments until server ends stream.
+// Shipments channel is closed at end, then error channel gets error of stream, if any, and is closed.
+// Caller must drain shipments or cancel ctx
+// Передает ID, пока канал ids не закрыт, и доставляет партии, пока сервер не завершит поток.
+// В конце закрывается канал партий, затем канал ошибок получает ошибку потока, если она есть, и закрывается.
+// Вызывающий должен читать партии или отменить ctx
+func (c *Client) ProcessOrders(ctx context.Context, ids <-chan string, opts ...grpc.CallOption) (<-chan *pb.CombinedShipment, <-chan error) {
+	shipments := make(chan *pb.CombinedShipment)
+	errc := make(chan error, 1)
+
+	// Cancel unblocks Send and Recv if other side fails. Отмена разблокирует Send и Recv при сбое другой стороны
+	ctx, cancel := context.WithCancel(ctx)
+	stream, err := c.OrderManagementClient.ProcessOrders(ctx, opts...)
+	if err != nil {
+		cancel()
+		close(shipments)
+		errc <- err
+		close(errc)
+		return shipments, errc
+	}
+
+	sendErr := make(chan error, 1)
+	go func() {
+		err := sendIDs(ctx, stream, ids)
+		if err != nil {
+			cancel()
+		}
+		sendErr <- err
+	}()
+
+	go func() {
+		err := recvShipments(ctx, stream, shipments)
+		cancel()
+		// Error of sending is cause of cancellation seen by receiver. Ошибка отправки - причина отмены приема
+		if serr := <-sendErr; serr != nil {
+			err = serr
+		}
+		close(shipments)
+		if err != nil {
+			errc <- err
+		}
+		close(errc)
+	}()
+	return shipments, errc
+}
+
+// Sends IDs and closes sending side of stream. Status of stream is returned by Recv
+// Отправляет ID и сигнализирует о завершении клиентского потока. Статус потока возвращает Recv
+func sendIDs(ctx context.Context, stream pb.OrderManagement_ProcessOrdersClient, ids <-chan string) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case id, ok := <-ids:
+			if !ok {
+				return stream.CloseSend()
+			}
+			if err := stream.Send(&wrappers.StringValue{Value: id}); err != nil {
+				if err == io.EOF || ctx.Err() != nil {
+					return nil
+				}
+				return err
+			}
+		}
+	}
+}
+
+// Receives shipments until end of stream. Принимает партии до конца потока
+func recvShipments(ctx context.Context, stream pb.OrderManagement_ProcessOrdersClient, out chan<- *pb.CombinedShipment) error {
+	for {
+		comb, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		select {
+		case out <- comb:
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
@@ -0,0 +1,167 @@
+// Тестирование клиента на имитации сервера поверх буфера
+// Testing of client with fake server over buffer
+
+package orderclient
+
+import (
+	"context"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/blablatov/bidistream-mtls-grpc/bs-mtls-proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// Fake service: one shipment per ID, ID "bad" ends stream with error
+// Имитация сервиса: одна партия на ID, ID "bad" завершает поток ошибкой
+type fakeServer struct {
+	pb.OrderManagementServer
+}
+
+func (fakeServer) ProcessOrders(stream pb.OrderManagement_ProcessOrdersServer) error {
+	for {
+		id, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if id.GetValue() == "bad" {
+			return status.Error(codes.InvalidArgument, "bad order")
+		}
+		if err := stream.Send(&pb.CombinedShipment{Id: "cmb - " + id.GetValue(), Status: "Processed!"}); err != nil {
+			return err
+		}
+	}
+}
+
+func newTestClient(t *testing.T) *Client {
+	t.Helper()
+	lis := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer()
+	pb.RegisterOrderManagementServer(s, fakeServer{})
+	hs := health.NewServer()
+	hs.SetServingStatus(pb.OrderManagement_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_NOT_SERVING)
+	healthpb.RegisterHealthServer(s, hs)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	cc, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cc.Close() })
+	return New(cc)
+}
+
+// Collects shipments and error of stream. Собирает партии и ошибку потока
+func collect(t *testing.T, shipments <-chan *pb.CombinedShipment, errc <-chan error) ([]string, error) {
+	t.Helper()
+	var got []string
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case comb, ok := <-shipments:
+			if !ok {
+				return got, <-errc
+			}
+			got = append(got, comb.GetId())
+		case <-timeout:
+			t.Fatal("stream did not end")
+		}
+	}
+}
+
+func TestProcessOrders(t *testing.T) {
+	c := newTestClient(t)
+	ids := make(chan string)
+	go func() {
+		for _, id := range []string{"102", "103", "104"} {
+			ids <- id
+		}
+		close(ids)
+	}()
+	shipments, errc := c.ProcessOrders(context.Background(), ids)
+	got, err := collect(t, shipments, errc)
+	if err != nil {
+		t.Fatalf("ProcessOrders() error = %v", err)
+	}
+	if want := "cmb - 102,cmb - 103,cmb - 104"; strings.Join(got, ",") != want {
+		t.Errorf("shipments = %v, want %s", got, want)
+	}
+}
+
+// Server error ends call even if IDs are not closed. Ошибка сервера завершает вызов, даже если канал ID открыт
+func TestProcessOrders_ServerError(t *testing.T) {
+	c := newTestClient(t)
+	ids := make(chan string, 2)
+	ids <- "102"
+	ids <- "bad"
+	shipments, errc := c.ProcessOrders(context.Background(), ids)
+	got, err := collect(t, shipments, errc)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("ProcessOrders() error = %v, want InvalidArgument", err)
+	}
+	if len(got) != 1 {
+		t.Errorf("shipments = %v, want one before error", got)
+	}
+}
+
+func TestProcessOrders_Cancel(t *testing.T) {
+	c := newTestClient(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	ids := make(chan string)
+	shipments, errc := c.ProcessOrders(ctx, ids)
+	ids <- "102"
+	cancel()
+	_, err := collect(t, shipments, errc)
+	if status.Code(err) != codes.Canceled {
+		t.Fatalf("ProcessOrders() error = %v, want Canceled", err)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	c := newTestClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for service, want := range map[string]healthpb.HealthCheckResponse_ServingStatus{
+		"": healthpb.HealthCheckResponse_SERVING,
+		pb.OrderManagement_ServiceDesc.ServiceName: healthpb.HealthCheckResponse_NOT_SERVING,
+	} {
+		if st, err := c.Health(ctx, service); err != nil || st != want {
+			t.Errorf("Health(%q) = %v, %v, want %v", service, st, err, want)
+		}
+	}
+	if _, err := c.Health(ctx, "unknown"); status.Code(err) != codes.NotFound {
+		t.Errorf("unknown service: %v, want NotFound", err)
+	}
+}
+
+func TestDial_Errors(t *testing.T) {
+	if _, err := Dial(context.Background(), Options{}); err == nil {
+		t.Error("Dial() without address = nil error")
+	}
+	dir := t.TempDir()
+	_, err := Dial(context.Background(), Options{
+		Addr:     "localhost:50051",
+		CertFile: filepath.Join(dir, "client.crt"),
+		KeyFile:  filepath.Join(dir, "client.key"),
+		CAFile:   filepath.Join(dir, "ca.crt"),
+	})
+	if err == nil || !strings.Contains(err.Error(), "key pair") {
+		t.Errorf("Dial() with missing files error = %v", err)
+	}
+}
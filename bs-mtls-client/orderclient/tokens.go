@@ -0,0 +1,129 @@
+package orderclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// Token is renewed this long before expiry by default. Токен обновляется за это время до истечения срока
+const DefaultRefreshAhead = time.Minute
+
+// RefreshAhead returns token source renewing token of src early before its expiry, so that calls
+// and reconnects never carry token expiring in flight. If renewal fails, token still valid is kept.
+// Token without expiry is reused forever. Safe for concurrent use
+// Возвращает источник, обновляющий токен src за early до истечения срока, чтобы вызовы и переподключения
+// не несли истекающий токен. При ошибке обновления остается еще действующий токен. Токен без срока не обновляется
+func RefreshAhead(src oauth2.TokenSource, early time.Duration) oauth2.TokenSource {
+	if early <= 0 {
+		early = DefaultRefreshAhead
+	}
+	return &refreshAheadSource{src: src, early: early}
+}
+
+type refreshAheadSource struct {
+	src   oauth2.TokenSource
+	early time.Duration
+
+	mu  sync.Mutex
+	tok *oauth2.Token
+}
+
+func (s *refreshAheadSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tok != nil && (s.tok.Expiry.IsZero() || time.Until(s.tok.Expiry) > s.early) {
+		return s.tok, nil
+	}
+	tok, err := s.src.Token()
+	if err != nil {
+		if s.tok.Valid() {
+			return s.tok, nil
+		}
+		return nil, err
+	}
+	s.tok = tok
+	return tok, nil
+}
+
+// ClientCredentials returns token source of OAuth2 client-credentials flow, RFC 6749 4.4,
+// renewed early before expiry. Context is used by token requests
+// Возвращает источник токенов потока client credentials, обновляемых за early до истечения срока.
+// Контекст используется запросами токена
+func ClientCredentials(ctx context.Context, cfg *clientcredentials.Config, early time.Duration) oauth2.TokenSource {
+	// Config.TokenSource caches token until expiry, so each renewal asks endpoint directly
+	// Config.TokenSource хранит токен до истечения срока, поэтому обновление запрашивает сервер напрямую
+	return RefreshAhead(tokenSourceFunc(func() (*oauth2.Token, error) { return cfg.Token(ctx) }), early)
+}
+
+type tokenSourceFunc func() (*oauth2.Token, error)
+
+func (f tokenSourceFunc) Token() (*oauth2.Token, error) { return f() }
+
+// FileTokenSource returns token source reading file again when it is changed, e.g. by sidecar agent.
+// File holds raw access token or JSON of oauth2.Token with access_token and expiry
+// Возвращает источник, перечитывающий измененный файл, например агентом-спутником.
+// Файл содержит токен доступа или JSON oauth2.Token с полями access_token и expiry
+func FileTokenSource(file string) oauth2.TokenSource {
+	return &fileTokenSource{file: file}
+}
+
+type fileTokenSource struct {
+	file string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	tok     *oauth2.Token
+}
+
+func (s *fileTokenSource) Token() (*oauth2.Token, error) {
+	fi, err := os.Stat(s.file)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tok == nil || !fi.ModTime().Equal(s.modTime) || fi.Size() != s.size {
+		data, err := os.ReadFile(s.file)
+		if err != nil {
+			return nil, err
+		}
+		tok, err := parseTokenFile(data)
+		if err != nil {
+			return nil, fmt.Errorf("orderclient: token file %s: %w", s.file, err)
+		}
+		s.tok, s.modTime, s.size = tok, fi.ModTime(), fi.Size()
+	}
+	if !s.tok.Valid() {
+		return nil, fmt.Errorf("orderclient: token of %s expired at %s", s.file, s.tok.Expiry.Format(time.RFC3339))
+	}
+	return s.tok, nil
+}
+
+// Raw token or JSON of oauth2.Token. Токен или JSON oauth2.Token
+func parseTokenFile(data []byte) (*oauth2.Token, error) {
+	text := strings.TrimSpace(string(data))
+	if text == "" {
+		return nil, errors.New("empty")
+	}
+	if !strings.HasPrefix(text, "{") {
+		return &oauth2.Token{AccessToken: text, TokenType: "Bearer"}, nil
+	}
+	var tok oauth2.Token
+	if err := json.Unmarshal([]byte(text), &tok); err != nil {
+		return nil, err
+	}
+	if tok.AccessToken == "" {
+		return nil, errors.New("no access_token")
+	}
+	return &tok, nil
+}
@@ -0,0 +1,151 @@
+// Тестирование источников токенов OAuth2. Testing of OAuth2 token sources
+
+package orderclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// Token endpoint issuing numbered tokens, fails while *failing is set
+// Сервер токенов, выдающий пронумерованные токены, отвечает ошибкой, пока задан *failing
+func newTokenEndpoint(t *testing.T, expiresIn int, issued, failing *int32) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(failing) != 0 {
+			http.Error(w, `{"error":"temporarily_unavailable"}`, http.StatusServiceUnavailable)
+			return
+		}
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "bs-client" || secret != "s3cret" || r.FormValue("grant_type") != "client_credentials" {
+			http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+		n := atomic.AddInt32(issued, 1)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": fmt.Sprintf("token-%d-%s", n, r.FormValue("scope")),
+			"token_type":   "Bearer",
+			"expires_in":   expiresIn,
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestClientCredentials(t *testing.T) {
+	var issued, failing int32
+	srv := newTokenEndpoint(t, 3600, &issued, &failing)
+	cfg := &clientcredentials.Config{
+		ClientID:     "bs-client",
+		ClientSecret: "s3cret",
+		TokenURL:     srv.URL,
+		Scopes:       []string{"orders:write"},
+		AuthStyle:    oauth2.AuthStyleInHeader,
+	}
+
+	// Fresh token is reused. Свежий токен используется повторно
+	ts := ClientCredentials(context.Background(), cfg, time.Minute)
+	for i := 0; i < 3; i++ {
+		tok, err := ts.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tok.AccessToken != "token-1-orders:write" {
+			t.Fatalf("Token() = %q", tok.AccessToken)
+		}
+	}
+	if issued != 1 {
+		t.Errorf("endpoint issued %d tokens, want 1", issued)
+	}
+
+	// Token expiring within early period is renewed. Истекающий токен обновляется заранее
+	ts = ClientCredentials(context.Background(), cfg, 2*time.Hour)
+	first, _ := ts.Token()
+	second, err := ts.Token()
+	if err != nil || first.AccessToken == second.AccessToken {
+		t.Errorf("Token() before expiry = %v, %v, want renewed token", second, err)
+	}
+
+	// Failed renewal keeps token still valid. При сбое обновления остается действующий токен
+	atomic.StoreInt32(&failing, 1)
+	third, err := ts.Token()
+	if err != nil || third.AccessToken != second.AccessToken {
+		t.Errorf("Token() with failing endpoint = %v, %v, want last token", third, err)
+	}
+
+	cfg.ClientSecret = "wrong"
+	atomic.StoreInt32(&failing, 0)
+	if _, err := ClientCredentials(context.Background(), cfg, 0).Token(); err == nil {
+		t.Error("Token() with wrong secret = nil error")
+	}
+}
+
+func TestFileTokenSource(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "token")
+	write := func(data string, shift time.Duration) {
+		t.Helper()
+		if err := os.WriteFile(file, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		mtime := time.Now().Add(shift)
+		if err := os.Chtimes(file, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ts := FileTokenSource(file)
+	if _, err := ts.Token(); err == nil {
+		t.Error("Token() of missing file = nil error")
+	}
+
+	write("raw-token\n", 0)
+	if tok, err := ts.Token(); err != nil || tok.AccessToken != "raw-token" {
+		t.Fatalf("Token() of raw file = %v, %v", tok, err)
+	}
+
+	// Changed file is read again. Измененный файл перечитывается
+	expiry := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	write(`{"access_token": "json-token", "token_type": "Bearer", "expiry": "`+expiry+`"}`, time.Second)
+	if tok, err := ts.Token(); err != nil || tok.AccessToken != "json-token" {
+		t.Fatalf("Token() of rewritten file = %v, %v", tok, err)
+	}
+
+	expired := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	write(`{"access_token": "old-token", "expiry": "`+expired+`"}`, 2*time.Second)
+	if _, err := ts.Token(); err == nil {
+		t.Error("Token() of expired token = nil error")
+	}
+	for _, data := range []string{"", "{", `{"token_type": "Bearer"}`} {
+		if _, err := parseTokenFile([]byte(data)); err == nil {
+			t.Errorf("parseTokenFile(%q) = nil error", data)
+		}
+	}
+}
+
+// Token without expiry is fetched once. Токен без срока запрашивается однократно
+func TestRefreshAhead_NoExpiry(t *testing.T) {
+	var calls int32
+	ts := RefreshAhead(tokenSourceFunc(func() (*oauth2.Token, error) {
+		atomic.AddInt32(&calls, 1)
+		return &oauth2.Token{AccessToken: "static"}, nil
+	}), 0)
+	for i := 0; i < 3; i++ {
+		if _, err := ts.Token(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("source called %d times, want 1", calls)
+	}
+}
@@ -8,6 +8,7 @@ package __
 
 import (
 	wrappers "github.com/golang/protobuf/ptypes/wrappers"
+	errdetails "google.golang.org/genproto/googleapis/rpc/errdetails"
 	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
 	reflect "reflect"
@@ -21,6 +22,121 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+// Reason of shipment flush. Причина отправки партии заказов
+type FlushTrigger int32
+
+const (
+	FlushTrigger_FLUSH_TRIGGER_UNSPECIFIED   FlushTrigger = 0
+	FlushTrigger_FLUSH_TRIGGER_MAX_ORDERS    FlushTrigger = 1 // Max order count per destination. Предел числа заказов
+	FlushTrigger_FLUSH_TRIGGER_MAX_WAIT      FlushTrigger = 2 // Max wait time. Предел времени ожидания
+	FlushTrigger_FLUSH_TRIGGER_MAX_PRICE     FlushTrigger = 3 // Max total price. Предел общей стоимости
+	FlushTrigger_FLUSH_TRIGGER_MAX_ITEMS     FlushTrigger = 4 // Max total item count. Предел числа товаров
+	FlushTrigger_FLUSH_TRIGGER_END_OF_STREAM FlushTrigger = 5 // Client closed stream. Клиент завершил поток
+	FlushTrigger_FLUSH_TRIGGER_SHUTDOWN      FlushTrigger = 6 // Server is shutting down. Сервер останавливается
+	FlushTrigger_FLUSH_TRIGGER_ADMIN         FlushTrigger = 7 // Forced by administrator. Принудительно администратором
+)
+
+// Enum value maps for FlushTrigger.
+var (
+	FlushTrigger_name = map[int32]string{
+		0: "FLUSH_TRIGGER_UNSPECIFIED",
+		1: "FLUSH_TRIGGER_MAX_ORDERS",
+		2: "FLUSH_TRIGGER_MAX_WAIT",
+		3: "FLUSH_TRIGGER_MAX_PRICE",
+		4: "FLUSH_TRIGGER_MAX_ITEMS",
+		5: "FLUSH_TRIGGER_END_OF_STREAM",
+		6: "FLUSH_TRIGGER_SHUTDOWN",
+		7: "FLUSH_TRIGGER_ADMIN",
+	}
+	FlushTrigger_value = map[string]int32{
+		"FLUSH_TRIGGER_UNSPECIFIED":   0,
+		"FLUSH_TRIGGER_MAX_ORDERS":    1,
+		"FLUSH_TRIGGER_MAX_WAIT":      2,
+		"FLUSH_TRIGGER_MAX_PRICE":     3,
+		"FLUSH_TRIGGER_MAX_ITEMS":     4,
+		"FLUSH_TRIGGER_END_OF_STREAM": 5,
+		"FLUSH_TRIGGER_SHUTDOWN":      6,
+		"FLUSH_TRIGGER_ADMIN":         7,
+	}
+)
+
+func (x FlushTrigger) Enum() *FlushTrigger {
+	p := new(FlushTrigger)
+	*p = x
+	return p
+}
+
+func (x FlushTrigger) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (FlushTrigger) Descriptor() protoreflect.EnumDescriptor {
+	return file_order_management_proto_enumTypes[0].Descriptor()
+}
+
+func (FlushTrigger) Type() protoreflect.EnumType {
+	return &file_order_management_proto_enumTypes[0]
+}
+
+func (x FlushTrigger) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use FlushTrigger.Descriptor instead.
+func (FlushTrigger) EnumDescriptor() ([]byte, []int) {
+	return file_order_management_proto_rawDescGZIP(), []int{0}
+}
+
+// Reason of rejection of order ID. Причина отклонения ID заказа
+type RejectReason int32
+
+const (
+	RejectReason_REJECT_REASON_UNSPECIFIED RejectReason = 0
+	RejectReason_REJECT_REASON_NOT_FOUND   RejectReason = 1 // Order ID is not found. ID заказа не найден
+	RejectReason_REJECT_REASON_INVALID     RejectReason = 2 // Order failed validation. Заказ не прошел проверку
+)
+
+// Enum value maps for RejectReason.
+var (
+	RejectReason_name = map[int32]string{
+		0: "REJECT_REASON_UNSPECIFIED",
+		1: "REJECT_REASON_NOT_FOUND",
+		2: "REJECT_REASON_INVALID",
+	}
+	RejectReason_value = map[string]int32{
+		"REJECT_REASON_UNSPECIFIED": 0,
+		"REJECT_REASON_NOT_FOUND":   1,
+		"REJECT_REASON_INVALID":     2,
+	}
+)
+
+func (x RejectReason) Enum() *RejectReason {
+	p := new(RejectReason)
+	*p = x
+	return p
+}
+
+func (x RejectReason) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (RejectReason) Descriptor() protoreflect.EnumDescriptor {
+	return file_order_management_proto_enumTypes[1].Descriptor()
+}
+
+func (RejectReason) Type() protoreflect.EnumType {
+	return &file_order_management_proto_enumTypes[1]
+}
+
+func (x RejectReason) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use RejectReason.Descriptor instead.
+func (RejectReason) EnumDescriptor() ([]byte, []int) {
+	return file_order_management_proto_rawDescGZIP(), []int{1}
+}
+
 type Order struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -105,9 +221,12 @@ type CombinedShipment struct {
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Id         string   `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
-	Status     string   `protobuf:"bytes,2,opt,name=status,proto3" json:"status,omitempty"`
-	OrdersList []*Order `protobuf:"bytes,3,rep,name=ordersList,proto3" json:"ordersList,omitempty"`
+	Id         string         `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Status     string         `protobuf:"bytes,2,opt,name=status,proto3" json:"status,omitempty"`
+	OrdersList []*Order       `protobuf:"bytes,3,rep,name=ordersList,proto3" json:"ordersList,omitempty"`
+	Trigger    FlushTrigger   `protobuf:"varint,4,opt,name=trigger,proto3,enum=ecommerce.FlushTrigger" json:"trigger,omitempty"`
+	Sequence   uint64         `protobuf:"varint,5,opt,name=sequence,proto3" json:"sequence,omitempty"` // Number of shipment in stream, starts at 1. Номер партии в потоке, начиная с 1
+	Rejected   *RejectedOrder `protobuf:"bytes,6,opt,name=rejected,proto3" json:"rejected,omitempty"`  // Set instead of ordersList for rejected ID. Задается вместо ordersList для отклоненного ID
 }
 
 func (x *CombinedShipment) Reset() {
@@ -163,6 +282,131 @@ func (x *CombinedShipment) GetOrdersList() []*Order {
 	return nil
 }
 
+func (x *CombinedShipment) GetTrigger() FlushTrigger {
+	if x != nil {
+		return x.Trigger
+	}
+	return FlushTrigger_FLUSH_TRIGGER_UNSPECIFIED
+}
+
+func (x *CombinedShipment) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+func (x *CombinedShipment) GetRejected() *RejectedOrder {
+	if x != nil {
+		return x.Rejected
+	}
+	return nil
+}
+
+// Order ID rejected by processOrders: shipment in lenient mode, detail of error status in strict mode
+// Отклоненный ID заказа processOrders: партия в мягком режиме, деталь статуса ошибки в строгом режиме
+type RejectedOrder struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Id         string                                  `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Violations []*errdetails.BadRequest_FieldViolation `protobuf:"bytes,2,rep,name=violations,proto3" json:"violations,omitempty"`
+	Reason     RejectReason                            `protobuf:"varint,3,opt,name=reason,proto3,enum=ecommerce.RejectReason" json:"reason,omitempty"`
+}
+
+func (x *RejectedOrder) Reset() {
+	*x = RejectedOrder{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_order_management_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RejectedOrder) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RejectedOrder) ProtoMessage() {}
+
+func (x *RejectedOrder) ProtoReflect() protoreflect.Message {
+	mi := &file_order_management_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RejectedOrder.ProtoReflect.Descriptor instead.
+func (*RejectedOrder) Descriptor() ([]byte, []int) {
+	return file_order_management_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *RejectedOrder) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *RejectedOrder) GetViolations() []*errdetails.BadRequest_FieldViolation {
+	if x != nil {
+		return x.Violations
+	}
+	return nil
+}
+
+func (x *RejectedOrder) GetReason() RejectReason {
+	if x != nil {
+		return x.Reason
+	}
+	return RejectReason_REJECT_REASON_UNSPECIFIED
+}
+
+// Номера и имена зарезервированных полей сообщений. Don't use this
+type Res struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *Res) Reset() {
+	*x = Res{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_order_management_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Res) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Res) ProtoMessage() {}
+
+func (x *Res) ProtoReflect() protoreflect.Message {
+	mi := &file_order_management_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Res.ProtoReflect.Descriptor instead.
+func (*Res) Descriptor() ([]byte, []int) {
+	return file_order_management_proto_rawDescGZIP(), []int{3}
+}
+
 var File_order_management_proto protoreflect.FileDescriptor
 
 var file_order_management_proto_rawDesc = []byte{
@@ -170,6 +414,8 @@ var file_order_management_proto_rawDesc = []byte{
 	0x6e, 0x74, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x09, 0x65, 0x63, 0x6f, 0x6d, 0x6d, 0x65,
 	0x72, 0x63, 0x65, 0x1a, 0x1e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74,
 	0x6f, 0x62, 0x75, 0x66, 0x2f, 0x77, 0x72, 0x61, 0x70, 0x70, 0x65, 0x72, 0x73, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x1a, 0x1e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x72, 0x70, 0x63, 0x2f,
+	0x65, 0x72, 0x72, 0x6f, 0x72, 0x5f, 0x64, 0x65, 0x74, 0x61, 0x69, 0x6c, 0x73, 0x2e, 0x70, 0x72,
 	0x6f, 0x74, 0x6f, 0x22, 0x87, 0x01, 0x0a, 0x05, 0x4f, 0x72, 0x64, 0x65, 0x72, 0x12, 0x0e, 0x0a,
 	0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69, 0x64, 0x12, 0x14, 0x0a,
 	0x05, 0x69, 0x74, 0x65, 0x6d, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x09, 0x52, 0x05, 0x69, 0x74,
@@ -178,21 +424,85 @@ var file_order_management_proto_rawDesc = []byte{
 	0x70, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x14, 0x0a, 0x05, 0x70, 0x72, 0x69, 0x63, 0x65, 0x18, 0x04,
 	0x20, 0x01, 0x28, 0x02, 0x52, 0x05, 0x70, 0x72, 0x69, 0x63, 0x65, 0x12, 0x20, 0x0a, 0x0b, 0x64,
 	0x65, 0x73, 0x74, 0x69, 0x6e, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09,
-	0x52, 0x0b, 0x64, 0x65, 0x73, 0x74, 0x69, 0x6e, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x22, 0x6c, 0x0a,
-	0x10, 0x43, 0x6f, 0x6d, 0x62, 0x69, 0x6e, 0x65, 0x64, 0x53, 0x68, 0x69, 0x70, 0x6d, 0x65, 0x6e,
-	0x74, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69,
-	0x64, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28,
-	0x09, 0x52, 0x06, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x30, 0x0a, 0x0a, 0x6f, 0x72, 0x64,
-	0x65, 0x72, 0x73, 0x4c, 0x69, 0x73, 0x74, 0x18, 0x03, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x10, 0x2e,
-	0x65, 0x63, 0x6f, 0x6d, 0x6d, 0x65, 0x72, 0x63, 0x65, 0x2e, 0x4f, 0x72, 0x64, 0x65, 0x72, 0x52,
-	0x0a, 0x6f, 0x72, 0x64, 0x65, 0x72, 0x73, 0x4c, 0x69, 0x73, 0x74, 0x32, 0x61, 0x0a, 0x0f, 0x4f,
-	0x72, 0x64, 0x65, 0x72, 0x4d, 0x61, 0x6e, 0x61, 0x67, 0x65, 0x6d, 0x65, 0x6e, 0x74, 0x12, 0x4e,
-	0x0a, 0x0d, 0x70, 0x72, 0x6f, 0x63, 0x65, 0x73, 0x73, 0x4f, 0x72, 0x64, 0x65, 0x72, 0x73, 0x12,
-	0x1c, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75,
-	0x66, 0x2e, 0x53, 0x74, 0x72, 0x69, 0x6e, 0x67, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x1a, 0x1b, 0x2e,
-	0x65, 0x63, 0x6f, 0x6d, 0x6d, 0x65, 0x72, 0x63, 0x65, 0x2e, 0x43, 0x6f, 0x6d, 0x62, 0x69, 0x6e,
-	0x65, 0x64, 0x53, 0x68, 0x69, 0x70, 0x6d, 0x65, 0x6e, 0x74, 0x28, 0x01, 0x30, 0x01, 0x42, 0x04,
-	0x5a, 0x02, 0x2e, 0x2f, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x52, 0x0b, 0x64, 0x65, 0x73, 0x74, 0x69, 0x6e, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x22, 0xf1, 0x01,
+	0x0a, 0x10, 0x43, 0x6f, 0x6d, 0x62, 0x69, 0x6e, 0x65, 0x64, 0x53, 0x68, 0x69, 0x70, 0x6d, 0x65,
+	0x6e, 0x74, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02,
+	0x69, 0x64, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x06, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x30, 0x0a, 0x0a, 0x6f, 0x72,
+	0x64, 0x65, 0x72, 0x73, 0x4c, 0x69, 0x73, 0x74, 0x18, 0x03, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x10,
+	0x2e, 0x65, 0x63, 0x6f, 0x6d, 0x6d, 0x65, 0x72, 0x63, 0x65, 0x2e, 0x4f, 0x72, 0x64, 0x65, 0x72,
+	0x52, 0x0a, 0x6f, 0x72, 0x64, 0x65, 0x72, 0x73, 0x4c, 0x69, 0x73, 0x74, 0x12, 0x31, 0x0a, 0x07,
+	0x74, 0x72, 0x69, 0x67, 0x67, 0x65, 0x72, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x17, 0x2e,
+	0x65, 0x63, 0x6f, 0x6d, 0x6d, 0x65, 0x72, 0x63, 0x65, 0x2e, 0x46, 0x6c, 0x75, 0x73, 0x68, 0x54,
+	0x72, 0x69, 0x67, 0x67, 0x65, 0x72, 0x52, 0x07, 0x74, 0x72, 0x69, 0x67, 0x67, 0x65, 0x72, 0x12,
+	0x1a, 0x0a, 0x08, 0x73, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x65, 0x18, 0x05, 0x20, 0x01, 0x28,
+	0x04, 0x52, 0x08, 0x73, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x65, 0x12, 0x34, 0x0a, 0x08, 0x72,
+	0x65, 0x6a, 0x65, 0x63, 0x74, 0x65, 0x64, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x18, 0x2e,
+	0x65, 0x63, 0x6f, 0x6d, 0x6d, 0x65, 0x72, 0x63, 0x65, 0x2e, 0x52, 0x65, 0x6a, 0x65, 0x63, 0x74,
+	0x65, 0x64, 0x4f, 0x72, 0x64, 0x65, 0x72, 0x52, 0x08, 0x72, 0x65, 0x6a, 0x65, 0x63, 0x74, 0x65,
+	0x64, 0x22, 0x97, 0x01, 0x0a, 0x0d, 0x52, 0x65, 0x6a, 0x65, 0x63, 0x74, 0x65, 0x64, 0x4f, 0x72,
+	0x64, 0x65, 0x72, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x02, 0x69, 0x64, 0x12, 0x45, 0x0a, 0x0a, 0x76, 0x69, 0x6f, 0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e,
+	0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x25, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65,
+	0x2e, 0x72, 0x70, 0x63, 0x2e, 0x42, 0x61, 0x64, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x2e,
+	0x46, 0x69, 0x65, 0x6c, 0x64, 0x56, 0x69, 0x6f, 0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x0a,
+	0x76, 0x69, 0x6f, 0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x12, 0x2f, 0x0a, 0x06, 0x72, 0x65,
+	0x61, 0x73, 0x6f, 0x6e, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x17, 0x2e, 0x65, 0x63, 0x6f,
+	0x6d, 0x6d, 0x65, 0x72, 0x63, 0x65, 0x2e, 0x52, 0x65, 0x6a, 0x65, 0x63, 0x74, 0x52, 0x65, 0x61,
+	0x73, 0x6f, 0x6e, 0x52, 0x06, 0x72, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x22, 0x2d, 0x0a, 0x03, 0x52,
+	0x65, 0x73, 0x4a, 0x04, 0x08, 0x07, 0x10, 0x08, 0x4a, 0x04, 0x08, 0x08, 0x10, 0x09, 0x4a, 0x04,
+	0x08, 0x09, 0x10, 0x11, 0x4a, 0x08, 0x08, 0x78, 0x10, 0x80, 0x80, 0x80, 0x80, 0x02, 0x52, 0x06,
+	0x61, 0x6d, 0x6f, 0x75, 0x6e, 0x74, 0x52, 0x02, 0x67, 0x6f, 0x2a, 0xf7, 0x01, 0x0a, 0x0c, 0x46,
+	0x6c, 0x75, 0x73, 0x68, 0x54, 0x72, 0x69, 0x67, 0x67, 0x65, 0x72, 0x12, 0x1d, 0x0a, 0x19, 0x46,
+	0x4c, 0x55, 0x53, 0x48, 0x5f, 0x54, 0x52, 0x49, 0x47, 0x47, 0x45, 0x52, 0x5f, 0x55, 0x4e, 0x53,
+	0x50, 0x45, 0x43, 0x49, 0x46, 0x49, 0x45, 0x44, 0x10, 0x00, 0x12, 0x1c, 0x0a, 0x18, 0x46, 0x4c,
+	0x55, 0x53, 0x48, 0x5f, 0x54, 0x52, 0x49, 0x47, 0x47, 0x45, 0x52, 0x5f, 0x4d, 0x41, 0x58, 0x5f,
+	0x4f, 0x52, 0x44, 0x45, 0x52, 0x53, 0x10, 0x01, 0x12, 0x1a, 0x0a, 0x16, 0x46, 0x4c, 0x55, 0x53,
+	0x48, 0x5f, 0x54, 0x52, 0x49, 0x47, 0x47, 0x45, 0x52, 0x5f, 0x4d, 0x41, 0x58, 0x5f, 0x57, 0x41,
+	0x49, 0x54, 0x10, 0x02, 0x12, 0x1b, 0x0a, 0x17, 0x46, 0x4c, 0x55, 0x53, 0x48, 0x5f, 0x54, 0x52,
+	0x49, 0x47, 0x47, 0x45, 0x52, 0x5f, 0x4d, 0x41, 0x58, 0x5f, 0x50, 0x52, 0x49, 0x43, 0x45, 0x10,
+	0x03, 0x12, 0x1b, 0x0a, 0x17, 0x46, 0x4c, 0x55, 0x53, 0x48, 0x5f, 0x54, 0x52, 0x49, 0x47, 0x47,
+	0x45, 0x52, 0x5f, 0x4d, 0x41, 0x58, 0x5f, 0x49, 0x54, 0x45, 0x4d, 0x53, 0x10, 0x04, 0x12, 0x1f,
+	0x0a, 0x1b, 0x46, 0x4c, 0x55, 0x53, 0x48, 0x5f, 0x54, 0x52, 0x49, 0x47, 0x47, 0x45, 0x52, 0x5f,
+	0x45, 0x4e, 0x44, 0x5f, 0x4f, 0x46, 0x5f, 0x53, 0x54, 0x52, 0x45, 0x41, 0x4d, 0x10, 0x05, 0x12,
+	0x1a, 0x0a, 0x16, 0x46, 0x4c, 0x55, 0x53, 0x48, 0x5f, 0x54, 0x52, 0x49, 0x47, 0x47, 0x45, 0x52,
+	0x5f, 0x53, 0x48, 0x55, 0x54, 0x44, 0x4f, 0x57, 0x4e, 0x10, 0x06, 0x12, 0x17, 0x0a, 0x13, 0x46,
+	0x4c, 0x55, 0x53, 0x48, 0x5f, 0x54, 0x52, 0x49, 0x47, 0x47, 0x45, 0x52, 0x5f, 0x41, 0x44, 0x4d,
+	0x49, 0x4e, 0x10, 0x07, 0x2a, 0x65, 0x0a, 0x0c, 0x52, 0x65, 0x6a, 0x65, 0x63, 0x74, 0x52, 0x65,
+	0x61, 0x73, 0x6f, 0x6e, 0x12, 0x1d, 0x0a, 0x19, 0x52, 0x45, 0x4a, 0x45, 0x43, 0x54, 0x5f, 0x52,
+	0x45, 0x41, 0x53, 0x4f, 0x4e, 0x5f, 0x55, 0x4e, 0x53, 0x50, 0x45, 0x43, 0x49, 0x46, 0x49, 0x45,
+	0x44, 0x10, 0x00, 0x12, 0x1b, 0x0a, 0x17, 0x52, 0x45, 0x4a, 0x45, 0x43, 0x54, 0x5f, 0x52, 0x45,
+	0x41, 0x53, 0x4f, 0x4e, 0x5f, 0x4e, 0x4f, 0x54, 0x5f, 0x46, 0x4f, 0x55, 0x4e, 0x44, 0x10, 0x01,
+	0x12, 0x19, 0x0a, 0x15, 0x52, 0x45, 0x4a, 0x45, 0x43, 0x54, 0x5f, 0x52, 0x45, 0x41, 0x53, 0x4f,
+	0x4e, 0x5f, 0x49, 0x4e, 0x56, 0x41, 0x4c, 0x49, 0x44, 0x10, 0x02, 0x32, 0xa5, 0x03, 0x0a, 0x0f,
+	0x4f, 0x72, 0x64, 0x65, 0x72, 0x4d, 0x61, 0x6e, 0x61, 0x67, 0x65, 0x6d, 0x65, 0x6e, 0x74, 0x12,
+	0x3a, 0x0a, 0x08, 0x61, 0x64, 0x64, 0x4f, 0x72, 0x64, 0x65, 0x72, 0x12, 0x10, 0x2e, 0x65, 0x63,
+	0x6f, 0x6d, 0x6d, 0x65, 0x72, 0x63, 0x65, 0x2e, 0x4f, 0x72, 0x64, 0x65, 0x72, 0x1a, 0x1c, 0x2e,
+	0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e,
+	0x53, 0x74, 0x72, 0x69, 0x6e, 0x67, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x12, 0x3a, 0x0a, 0x08, 0x67,
+	0x65, 0x74, 0x4f, 0x72, 0x64, 0x65, 0x72, 0x12, 0x1c, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x53, 0x74, 0x72, 0x69, 0x6e, 0x67,
+	0x56, 0x61, 0x6c, 0x75, 0x65, 0x1a, 0x10, 0x2e, 0x65, 0x63, 0x6f, 0x6d, 0x6d, 0x65, 0x72, 0x63,
+	0x65, 0x2e, 0x4f, 0x72, 0x64, 0x65, 0x72, 0x12, 0x3d, 0x0a, 0x0b, 0x75, 0x70, 0x64, 0x61, 0x74,
+	0x65, 0x4f, 0x72, 0x64, 0x65, 0x72, 0x12, 0x10, 0x2e, 0x65, 0x63, 0x6f, 0x6d, 0x6d, 0x65, 0x72,
+	0x63, 0x65, 0x2e, 0x4f, 0x72, 0x64, 0x65, 0x72, 0x1a, 0x1c, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c,
+	0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x53, 0x74, 0x72, 0x69, 0x6e,
+	0x67, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x12, 0x49, 0x0a, 0x0b, 0x64, 0x65, 0x6c, 0x65, 0x74, 0x65,
+	0x4f, 0x72, 0x64, 0x65, 0x72, 0x12, 0x1c, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x53, 0x74, 0x72, 0x69, 0x6e, 0x67, 0x56, 0x61,
+	0x6c, 0x75, 0x65, 0x1a, 0x1c, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x53, 0x74, 0x72, 0x69, 0x6e, 0x67, 0x56, 0x61, 0x6c, 0x75,
+	0x65, 0x12, 0x40, 0x0a, 0x0c, 0x73, 0x65, 0x61, 0x72, 0x63, 0x68, 0x4f, 0x72, 0x64, 0x65, 0x72,
+	0x73, 0x12, 0x1c, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x62, 0x75, 0x66, 0x2e, 0x53, 0x74, 0x72, 0x69, 0x6e, 0x67, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x1a,
+	0x10, 0x2e, 0x65, 0x63, 0x6f, 0x6d, 0x6d, 0x65, 0x72, 0x63, 0x65, 0x2e, 0x4f, 0x72, 0x64, 0x65,
+	0x72, 0x30, 0x01, 0x12, 0x4e, 0x0a, 0x0d, 0x70, 0x72, 0x6f, 0x63, 0x65, 0x73, 0x73, 0x4f, 0x72,
+	0x64, 0x65, 0x72, 0x73, 0x12, 0x1c, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x53, 0x74, 0x72, 0x69, 0x6e, 0x67, 0x56, 0x61, 0x6c,
+	0x75, 0x65, 0x1a, 0x1b, 0x2e, 0x65, 0x63, 0x6f, 0x6d, 0x6d, 0x65, 0x72, 0x63, 0x65, 0x2e, 0x43,
+	0x6f, 0x6d, 0x62, 0x69, 0x6e, 0x65, 0x64, 0x53, 0x68, 0x69, 0x70, 0x6d, 0x65, 0x6e, 0x74, 0x28,
+	0x01, 0x30, 0x01, 0x42, 0x04, 0x5a, 0x02, 0x2e, 0x2f, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x33,
 }
 
 var (
@@ -207,21 +517,41 @@ func file_order_management_proto_rawDescGZIP() []byte {
 	return file_order_management_proto_rawDescData
 }
 
-var file_order_management_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_order_management_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
+var file_order_management_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
 var file_order_management_proto_goTypes = []interface{}{
-	(*Order)(nil),                // 0: ecommerce.Order
-	(*CombinedShipment)(nil),     // 1: ecommerce.CombinedShipment
-	(*wrappers.StringValue)(nil), // 2: google.protobuf.StringValue
+	(FlushTrigger)(0),        // 0: ecommerce.FlushTrigger
+	(RejectReason)(0),        // 1: ecommerce.RejectReason
+	(*Order)(nil),            // 2: ecommerce.Order
+	(*CombinedShipment)(nil), // 3: ecommerce.CombinedShipment
+	(*RejectedOrder)(nil),    // 4: ecommerce.RejectedOrder
+	(*Res)(nil),              // 5: ecommerce.Res
+	(*errdetails.BadRequest_FieldViolation)(nil), // 6: google.rpc.BadRequest.FieldViolation
+	(*wrappers.StringValue)(nil),                 // 7: google.protobuf.StringValue
 }
 var file_order_management_proto_depIdxs = []int32{
-	0, // 0: ecommerce.CombinedShipment.ordersList:type_name -> ecommerce.Order
-	2, // 1: ecommerce.OrderManagement.processOrders:input_type -> google.protobuf.StringValue
-	1, // 2: ecommerce.OrderManagement.processOrders:output_type -> ecommerce.CombinedShipment
-	2, // [2:3] is the sub-list for method output_type
-	1, // [1:2] is the sub-list for method input_type
-	1, // [1:1] is the sub-list for extension type_name
-	1, // [1:1] is the sub-list for extension extendee
-	0, // [0:1] is the sub-list for field type_name
+	2,  // 0: ecommerce.CombinedShipment.ordersList:type_name -> ecommerce.Order
+	0,  // 1: ecommerce.CombinedShipment.trigger:type_name -> ecommerce.FlushTrigger
+	4,  // 2: ecommerce.CombinedShipment.rejected:type_name -> ecommerce.RejectedOrder
+	6,  // 3: ecommerce.RejectedOrder.violations:type_name -> google.rpc.BadRequest.FieldViolation
+	1,  // 4: ecommerce.RejectedOrder.reason:type_name -> ecommerce.RejectReason
+	2,  // 5: ecommerce.OrderManagement.addOrder:input_type -> ecommerce.Order
+	7,  // 6: ecommerce.OrderManagement.getOrder:input_type -> google.protobuf.StringValue
+	2,  // 7: ecommerce.OrderManagement.updateOrder:input_type -> ecommerce.Order
+	7,  // 8: ecommerce.OrderManagement.deleteOrder:input_type -> google.protobuf.StringValue
+	7,  // 9: ecommerce.OrderManagement.searchOrders:input_type -> google.protobuf.StringValue
+	7,  // 10: ecommerce.OrderManagement.processOrders:input_type -> google.protobuf.StringValue
+	7,  // 11: ecommerce.OrderManagement.addOrder:output_type -> google.protobuf.StringValue
+	2,  // 12: ecommerce.OrderManagement.getOrder:output_type -> ecommerce.Order
+	7,  // 13: ecommerce.OrderManagement.updateOrder:output_type -> google.protobuf.StringValue
+	7,  // 14: ecommerce.OrderManagement.deleteOrder:output_type -> google.protobuf.StringValue
+	2,  // 15: ecommerce.OrderManagement.searchOrders:output_type -> ecommerce.Order
+	3,  // 16: ecommerce.OrderManagement.processOrders:output_type -> ecommerce.CombinedShipment
+	11, // [11:17] is the sub-list for method output_type
+	5,  // [5:11] is the sub-list for method input_type
+	5,  // [5:5] is the sub-list for extension type_name
+	5,  // [5:5] is the sub-list for extension extendee
+	0,  // [0:5] is the sub-list for field type_name
 }
 
 func init() { file_order_management_proto_init() }
@@ -254,19 +584,44 @@ func file_order_management_proto_init() {
 				return nil
 			}
 		}
+		file_order_management_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*RejectedOrder); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_order_management_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Res); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_order_management_proto_rawDesc,
-			NumEnums:      0,
-			NumMessages:   2,
+			NumEnums:      2,
+			NumMessages:   4,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
 		GoTypes:           file_order_management_proto_goTypes,
 		DependencyIndexes: file_order_management_proto_depIdxs,
+		EnumInfos:         file_order_management_proto_enumTypes,
 		MessageInfos:      file_order_management_proto_msgTypes,
 	}.Build()
 	File_order_management_proto = out.File
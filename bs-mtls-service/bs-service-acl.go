@@ -0,0 +1,225 @@
+// Авторизация по сертификату клиента. Authorization by identity of client certificate
+// Политика сопоставляет удостоверения (CN, DNS/URI SAN, SPIFFE ID) с разрешенными методами
+// Policy maps identities (CN, DNS/URI SAN, SPIFFE ID) to allowed methods
+
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/blablatov/bidistream-mtls-grpc/bs-logging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"gopkg.in/yaml.v3"
+)
+
+// Policy of access. Политика доступа
+//
+//	rules:
+//	  - identities: ["cn:bs-client", "dns:*.svc.cluster.local", "spiffe://example.org/ns/*/sa/bs-client"]
+//	    methods: ["/ecommerce.OrderManagement/*"]
+//
+// Identities are "cn:<common name>", "dns:<DNS SAN>", "uri:<URI SAN>" and SPIFFE IDs "spiffe://...",
+// patterns of identities and methods use path.Match syntax. Call is allowed if any rule matches
+// Удостоверения: "cn:<имя>", "dns:<DNS SAN>", "uri:<URI SAN>" и SPIFFE ID "spiffe://...",
+// шаблоны удостоверений и методов в синтаксисе path.Match. Вызов разрешен, если подходит любое правило
+type aclPolicy struct {
+	Rules []aclRule `yaml:"rules"`
+}
+
+type aclRule struct {
+	Identities []string `yaml:"identities"`
+	Methods    []string `yaml:"methods"`
+}
+
+// Parses and checks policy. Разбирает и проверяет политику
+func parseACL(data []byte) (*aclPolicy, error) {
+	var p aclPolicy
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+	for i, r := range p.Rules {
+		if len(r.Identities) == 0 || len(r.Methods) == 0 {
+			return nil, fmt.Errorf("rule %d: identities and methods are required", i)
+		}
+		for _, pattern := range append(append([]string{}, r.Identities...), r.Methods...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("rule %d: pattern %q: %w", i, pattern, err)
+			}
+		}
+	}
+	return &p, nil
+}
+
+// Reports whether any identity may call method. Разрешен ли вызов метода любому из удостоверений
+func (p *aclPolicy) allowed(identities []string, method string) bool {
+	for _, r := range p.Rules {
+		if matchAny(r.Methods, method) {
+			for _, id := range identities {
+				if matchAny(r.Identities, id) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []string, s string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Identities of verified certificate. Удостоверения проверенного сертификата
+func certIdentities(cert *x509.Certificate) []string {
+	var ids []string
+	if cert.Subject.CommonName != "" {
+		ids = append(ids, "cn:"+cert.Subject.CommonName)
+	}
+	for _, name := range cert.DNSNames {
+		ids = append(ids, "dns:"+name)
+	}
+	for _, u := range cert.URIs {
+		if u.Scheme == "spiffe" {
+			ids = append(ids, u.String())
+		}
+		ids = append(ids, "uri:"+u.String())
+	}
+	return ids
+}
+
+// Verified leaf certificate of peer. Проверенный конечный сертификат клиента
+func peerCertificate(ctx context.Context) (*x509.Certificate, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, errors.New("no peer")
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return nil, errors.New("peer is not authenticated by TLS")
+	}
+	if len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return nil, errors.New("no verified client certificate")
+	}
+	return tlsInfo.State.VerifiedChains[0][0], nil
+}
+
+// Authorizer reloads policy file on change, last good policy is kept on error
+// Авторизатор перечитывает измененный файл политики, при ошибке остается последняя исправная
+type aclAuthorizer struct {
+	file    string
+	policy  atomic.Value // *aclPolicy
+	mu      sync.Mutex
+	modTime time.Time
+}
+
+// Loads policy file. Загружает файл политики
+func newACLAuthorizer(file string) (*aclAuthorizer, error) {
+	a := &aclAuthorizer{file: file}
+	if err := a.reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *aclAuthorizer) reload() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if fi, err := os.Stat(a.file); err == nil {
+		a.modTime = fi.ModTime()
+	}
+	data, err := os.ReadFile(a.file)
+	if err != nil {
+		return err
+	}
+	p, err := parseACL(data)
+	if err != nil {
+		return fmt.Errorf("acl %s: %w", a.file, err)
+	}
+	a.policy.Store(p)
+	return nil
+}
+
+// Polls policy file until ctx is done. Опрашивает файл политики, пока ctx не завершен
+func (a *aclAuthorizer) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.poll()
+		}
+	}
+}
+
+// Reloads changed file. Перечитывает измененный файл
+func (a *aclAuthorizer) poll() {
+	fi, err := os.Stat(a.file)
+	a.mu.Lock()
+	changed := err == nil && !fi.ModTime().Equal(a.modTime)
+	a.mu.Unlock()
+	if !changed {
+		return
+	}
+	if err := a.reload(); err != nil {
+		slog.Error("ACL reload failed, keeping last good policy", "file", a.file, "err", err)
+		return
+	}
+	slog.Info("ACL reloaded", "file", a.file)
+}
+
+// Checks peer of call. Nil authorizer allows all verified clients
+// Проверяет клиента вызова. Пустой авторизатор разрешает всех проверенных клиентов
+func (a *aclAuthorizer) authorize(ctx context.Context, method string) error {
+	if a == nil {
+		return nil
+	}
+	cert, err := peerCertificate(ctx)
+	if err != nil {
+		return status.Errorf(codes.PermissionDenied, "access to %s denied: %v", method, err)
+	}
+	ids := certIdentities(cert)
+	if !a.policy.Load().(*aclPolicy).allowed(ids, method) {
+		logging.FromContext(ctx).Warn("access denied", "identities", strings.Join(ids, ", "), "method", method)
+		return status.Errorf(codes.PermissionDenied, "identity %s is not allowed to call %s",
+			strings.Join(ids, ", "), method)
+	}
+	return nil
+}
+
+// Unary interceptor of authorization. Унарный перехватчик авторизации
+func (a *aclAuthorizer) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	if err := a.authorize(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// Stream interceptor of authorization. Потоковый перехватчик авторизации
+func (a *aclAuthorizer) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler) error {
+	if err := a.authorize(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
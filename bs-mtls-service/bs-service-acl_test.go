@@ -0,0 +1,171 @@
+// Тестирование авторизации по сертификату клиента. Testing of authorization by client certificate
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	testACL = `
+rules:
+  - identities: ["cn:bs-client", "dns:*.svc.cluster.local"]
+    methods: ["/ecommerce.OrderManagement/*"]
+  - identities: ["spiffe://example.org/ns/*/sa/worker"]
+    methods: ["/ecommerce.OrderManagement/processOrders"]
+`
+	methodProcess = "/ecommerce.OrderManagement/processOrders"
+	methodAdd     = "/ecommerce.OrderManagement/addOrder"
+)
+
+// Context of call from peer with verified certificate. Контекст вызова клиента с проверенным сертификатом
+func peerContext(cert *x509.Certificate) context.Context {
+	state := tls.ConnectionState{}
+	if cert != nil {
+		state.VerifiedChains = [][]*x509.Certificate{{cert}}
+	}
+	return peer.NewContext(context.Background(), &peer.Peer{AuthInfo: credentials.TLSInfo{State: state}})
+}
+
+func writeACL(t *testing.T, data string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "acl.yaml")
+	if err := os.WriteFile(file, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestCertIdentities(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://example.org/ns/prod/sa/worker")
+	cert := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "bs-client"},
+		DNSNames: []string{"bs.svc.cluster.local"},
+		URIs:     []*url.URL{spiffe},
+	}
+	got := certIdentities(cert)
+	want := []string{"cn:bs-client", "dns:bs.svc.cluster.local",
+		"spiffe://example.org/ns/prod/sa/worker", "uri:spiffe://example.org/ns/prod/sa/worker"}
+	if len(got) != len(want) {
+		t.Fatalf("certIdentities() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("certIdentities()[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestParseACL_Invalid(t *testing.T) {
+	for name, data := range map[string]string{
+		"yaml":       "rules: [",
+		"no methods": "rules:\n  - identities: [\"cn:a\"]\n",
+		"pattern":    "rules:\n  - identities: [\"cn:[\"]\n    methods: [\"*\"]\n",
+	} {
+		if _, err := parseACL([]byte(data)); err == nil {
+			t.Errorf("parseACL(%s) = nil error", name)
+		}
+	}
+}
+
+func TestACLAuthorizer_Authorize(t *testing.T) {
+	acl, err := newACLAuthorizer(writeACL(t, testACL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	spiffe, _ := url.Parse("spiffe://example.org/ns/prod/sa/worker")
+	tests := []struct {
+		name   string
+		cert   *x509.Certificate
+		method string
+		want   codes.Code
+	}{
+		{"cn", &x509.Certificate{Subject: pkix.Name{CommonName: "bs-client"}}, methodAdd, codes.OK},
+		{"dns san", &x509.Certificate{DNSNames: []string{"bs.svc.cluster.local"}}, methodAdd, codes.OK},
+		{"spiffe allowed", &x509.Certificate{URIs: []*url.URL{spiffe}}, methodProcess, codes.OK},
+		{"spiffe other method", &x509.Certificate{URIs: []*url.URL{spiffe}}, methodAdd, codes.PermissionDenied},
+		{"unknown cn", &x509.Certificate{Subject: pkix.Name{CommonName: "intruder"}}, methodProcess, codes.PermissionDenied},
+		{"no certificate", nil, methodProcess, codes.PermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := status.Code(acl.authorize(peerContext(tt.cert), tt.method)); got != tt.want {
+				t.Errorf("authorize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Nil authorizer allows all. Пустой авторизатор разрешает все вызовы
+	var none *aclAuthorizer
+	if err := none.authorize(context.Background(), methodAdd); err != nil {
+		t.Errorf("nil authorize() = %v", err)
+	}
+}
+
+func TestACLAuthorizer_Reload(t *testing.T) {
+	file := writeACL(t, testACL)
+	acl, err := newACLAuthorizer(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := peerContext(&x509.Certificate{Subject: pkix.Name{CommonName: "bs-client"}})
+
+	rewrite := func(data string) {
+		if err := os.WriteFile(file, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		later := time.Now().Add(time.Hour)
+		if err := os.Chtimes(file, later, later); err != nil {
+			t.Fatal(err)
+		}
+		acl.poll()
+	}
+
+	// Revoked access. Доступ отозван
+	rewrite("rules:\n  - identities: [\"cn:other\"]\n    methods: [\"*\"]\n")
+	if status.Code(acl.authorize(ctx, methodAdd)) != codes.PermissionDenied {
+		t.Fatalf("authorize() after revoke is not denied")
+	}
+
+	// Broken file keeps last good policy. Испорченный файл оставляет прежнюю политику
+	rewrite("rules: [")
+	if status.Code(acl.authorize(ctx, methodAdd)) != codes.PermissionDenied {
+		t.Errorf("broken policy replaced last good one")
+	}
+}
+
+func TestACLAuthorizer_StreamInterceptor(t *testing.T) {
+	acl, err := newACLAuthorizer(writeACL(t, testACL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	called := false
+	handler := func(interface{}, grpc.ServerStream) error { called = true; return nil }
+	ss := &fakeServerStream{ctx: peerContext(&x509.Certificate{Subject: pkix.Name{CommonName: "intruder"}})}
+	err = acl.streamInterceptor(nil, ss, &grpc.StreamServerInfo{FullMethod: methodProcess}, handler)
+	if status.Code(err) != codes.PermissionDenied || called {
+		t.Errorf("streamInterceptor() = %v, handler called %v", err, called)
+	}
+}
+
+// Server stream with given context. Серверный поток с заданным контекстом
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *fakeServerStream) Context() context.Context { return s.ctx }
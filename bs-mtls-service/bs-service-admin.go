@@ -0,0 +1,313 @@
+// Служебный порт: channelz по gRPC, pprof и активные потоки ProcessOrders по HTTP, отправка партий и отмена потока
+// Admin port: channelz over gRPC, pprof and active ProcessOrders streams over HTTP, force-flush and cancel of stream
+// gRPC и HTTP/1 обслуживаются на одном порту без TLS, поэтому по умолчанию порт доступен только с loopback
+// gRPC and HTTP/1 share one port without TLS, so by default it is reachable from loopback only
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	channelzsvc "google.golang.org/grpc/channelz/service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Ends stream cancelled at admin port. Завершает поток, отмененный через служебный порт
+var errCancelledByAdmin = status.Error(codes.Aborted, "stream is cancelled by administrator")
+
+// Listen address of admin port: host defaults to 127.0.0.1, other hosts than loopback need remote
+// Адрес служебного порта: хост по умолчанию 127.0.0.1, хосты кроме loopback требуют remote
+func adminListenAddr(addr string, remote bool) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if host == "" {
+		return net.JoinHostPort("127.0.0.1", port), nil
+	}
+	if ip := net.ParseIP(host); !remote && host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return "", errors.New("not loopback, set admin_remote to allow")
+	}
+	return addr, nil
+}
+
+// Active ProcessOrders streams. Nil registry tracks nothing
+// Активные потоки ProcessOrders. Пустой реестр ничего не отслеживает
+type streamRegistry struct {
+	mu      sync.Mutex
+	streams map[string]*activeStream
+}
+
+func newStreamRegistry() *streamRegistry {
+	return &streamRegistry{streams: make(map[string]*activeStream)}
+}
+
+// Sets registry of active streams. Задает реестр активных потоков
+func withStreamRegistry(r *streamRegistry) serverOption {
+	return func(s *mserver) { s.streams = r }
+}
+
+// State of one stream. Nil stream has no requests and records nothing
+// Состояние одного потока. Пустой поток не получает запросов и ничего не записывает
+type activeStream struct {
+	r        *streamRegistry
+	id       string
+	identity string
+	peer     string
+	started  time.Time
+
+	flushc     chan struct{} // Force-flush requests. Запросы принудительной отправки
+	cancelc    chan struct{} // Closed by cancel. Закрывается отменой
+	cancelOnce sync.Once
+
+	mu       sync.Mutex
+	received int
+	pending  map[string]int // Orders per destination. Заказы по адресам доставки
+	last     time.Time
+}
+
+// View of stream at /streams. Представление потока в /streams
+type streamInfo struct {
+	ID           string         `json:"id"`
+	Identity     string         `json:"identity"`
+	Peer         string         `json:"peer"`
+	Started      time.Time      `json:"started"`
+	Received     int            `json:"received"`
+	Pending      map[string]int `json:"pending"`
+	LastActivity time.Time      `json:"last_activity"`
+}
+
+// Registers stream of ctx. Регистрирует поток ctx
+func (r *streamRegistry) open(ctx context.Context) *activeStream {
+	if r == nil {
+		return nil
+	}
+	now := time.Now()
+	st := &activeStream{
+		r:        r,
+		id:       streamID(ctx),
+		identity: callerIdentity(ctx),
+		peer:     peerAddr(ctx),
+		started:  now,
+		flushc:   make(chan struct{}, 1),
+		cancelc:  make(chan struct{}),
+		last:     now,
+	}
+	r.mu.Lock()
+	r.streams[st.id] = st
+	r.mu.Unlock()
+	return st
+}
+
+// Removes ended stream. Удаляет завершенный поток
+func (st *activeStream) close() {
+	if st == nil {
+		return
+	}
+	st.r.mu.Lock()
+	delete(st.r.streams, st.id)
+	st.r.mu.Unlock()
+}
+
+// Records state after event of stream. Записывает состояние после события потока
+func (st *activeStream) update(received int, b *shipmentBatcher) {
+	if st == nil {
+		return
+	}
+	pending := b.pendingOrders()
+	st.mu.Lock()
+	st.received, st.pending, st.last = received, pending, time.Now()
+	st.mu.Unlock()
+}
+
+// Force-flush requests, nil channel of nil stream never fires
+// Запросы принудительной отправки, пустой канал пустого потока не срабатывает
+func (st *activeStream) flushRequests() <-chan struct{} {
+	if st == nil {
+		return nil
+	}
+	return st.flushc
+}
+
+// Closed when stream is cancelled. Закрывается при отмене потока
+func (st *activeStream) cancelled() <-chan struct{} {
+	if st == nil {
+		return nil
+	}
+	return st.cancelc
+}
+
+func (st *activeStream) info() streamInfo {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	pending := make(map[string]int, len(st.pending))
+	for d, n := range st.pending {
+		pending[d] = n
+	}
+	return streamInfo{
+		ID: st.id, Identity: st.identity, Peer: st.peer, Started: st.started,
+		Received: st.received, Pending: pending, LastActivity: st.last,
+	}
+}
+
+// Active streams, oldest first. Активные потоки, начиная с самого старого
+func (r *streamRegistry) list() []streamInfo {
+	r.mu.Lock()
+	streams := make([]*activeStream, 0, len(r.streams))
+	for _, st := range r.streams {
+		streams = append(streams, st)
+	}
+	r.mu.Unlock()
+	out := make([]streamInfo, 0, len(streams))
+	for _, st := range streams {
+		out = append(out, st.info())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Started.Before(out[j].Started) })
+	return out
+}
+
+func (r *streamRegistry) get(id string) (*activeStream, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	st, ok := r.streams[id]
+	return st, ok
+}
+
+// Asks stream to send pending shipments, repeated requests before it are merged
+// Просит поток отправить ожидающие партии, повторные запросы до отправки объединяются
+func (r *streamRegistry) flush(id string) bool {
+	st, ok := r.get(id)
+	if ok {
+		select {
+		case st.flushc <- struct{}{}:
+		default:
+		}
+	}
+	return ok
+}
+
+// Ends stream with Aborted, pending orders are not shipped
+// Завершает поток с Aborted, ожидающие заказы не отправляются
+func (r *streamRegistry) cancel(id string) bool {
+	st, ok := r.get(id)
+	if ok {
+		st.cancelOnce.Do(func() { close(st.cancelc) })
+	}
+	return ok
+}
+
+// Admin endpoint: gRPC channelz and HTTP pprof and /streams on one port
+// Служебная точка: gRPC channelz и HTTP pprof и /streams на одном порту
+type adminServer struct {
+	streams *streamRegistry
+	grpc    *grpc.Server
+	mux     *http.ServeMux
+}
+
+func newAdminServer(streams *streamRegistry) *adminServer {
+	a := &adminServer{streams: streams, grpc: grpc.NewServer(), mux: http.NewServeMux()}
+	channelzsvc.RegisterChannelzServiceToServer(a.grpc)
+
+	a.mux.HandleFunc("/debug/pprof/", pprof.Index)
+	a.mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	a.mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	a.mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	a.mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	a.mux.HandleFunc("/streams", a.handleStreams)
+	a.mux.HandleFunc("/streams/", a.handleStreamAction)
+	return a
+}
+
+// gRPC over HTTP/2 without TLS goes to channelz, rest to HTTP handlers
+// gRPC поверх HTTP/2 без TLS идет в channelz, остальное - в обработчики HTTP
+func (a *adminServer) handler() http.Handler {
+	return h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			a.grpc.ServeHTTP(w, r)
+			return
+		}
+		a.mux.ServeHTTP(w, r)
+	}), &http2.Server{})
+}
+
+// GET /streams lists active streams. GET /streams выводит активные потоки
+func (a *adminServer) handleStreams(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, a.streams.list())
+}
+
+// POST /streams/{id}/flush or /streams/{id}/cancel. Отправка партий или отмена потока
+func (a *adminServer) handleStreamAction(w http.ResponseWriter, r *http.Request) {
+	id, action, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/streams/"), "/")
+	if !ok || id == "" || (action != "flush" && action != "cancel") {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	done := a.streams.flush
+	if action == "cancel" {
+		done = a.streams.cancel
+	}
+	if !done(id) {
+		http.Error(w, fmt.Sprintf("stream %q is not active", id), http.StatusNotFound)
+		return
+	}
+	slog.Info("admin action", "action", action, "stream", id, "remote", r.RemoteAddr)
+	writeJSON(w, http.StatusAccepted, map[string]string{"stream": id, "action": action})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// Serves admin port until ctx is done. Обслуживает служебный порт, пока ctx не завершен
+func (a *adminServer) serve(ctx context.Context, addr string, remote bool) error {
+	addr, err := adminListenAddr(addr, remote)
+	if err != nil {
+		return err
+	}
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: a.handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+		a.grpc.Stop()
+	}()
+	go func() {
+		if err := srv.Serve(lis); !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("admin server failed", "err", err)
+		}
+	}()
+	slog.Info("serving admin", "url", "http://"+lis.Addr().String()+"/streams")
+	return nil
+}
@@ -0,0 +1,149 @@
+// Тестирование служебного порта. Testing of admin port
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	pb "github.com/blablatov/bidistream-mtls-grpc/bs-mtls-proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/grpc"
+	channelzpb "google.golang.org/grpc/channelz/grpc_channelz_v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func TestAdminListenAddr(t *testing.T) {
+	for _, tc := range []struct {
+		addr   string
+		remote bool
+		want   string
+	}{
+		{":9091", false, "127.0.0.1:9091"},
+		{"localhost:9091", false, "localhost:9091"},
+		{"[::1]:9091", false, "[::1]:9091"},
+		{"0.0.0.0:9091", true, "0.0.0.0:9091"},
+		{"0.0.0.0:9091", false, ""},
+		{"admin.example.org:9091", false, ""},
+		{"9091", false, ""},
+	} {
+		got, err := adminListenAddr(tc.addr, tc.remote)
+		if tc.want == "" && err == nil {
+			t.Errorf("%q, remote %v: %q is accepted", tc.addr, tc.remote, got)
+		}
+		if tc.want != "" && (err != nil || got != tc.want) {
+			t.Errorf("%q, remote %v: %q, %v, want %q", tc.addr, tc.remote, got, err, tc.want)
+		}
+	}
+}
+
+// Sends admin request, decodes JSON response. Отправляет служебный запрос, разбирает ответ JSON
+func adminRequest(t *testing.T, h http.Handler, method, path string, v interface{}) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+	if v != nil && rec.Code < 300 {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+	return rec.Code
+}
+
+func TestAdmin_Streams(t *testing.T) {
+	reg := newStreamRegistry()
+	h := newAdminServer(reg).handler()
+	client := newMetricsClient(t, nil, withStreamRegistry(reg), withBatchPolicy(batchPolicy{MaxOrders: 10}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := client.ProcessOrders(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"102", "103", "104"} {
+		if err := stream.Send(&wrappers.StringValue{Value: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Received IDs wait in batches. Полученные ID ждут в партиях
+	var streams []streamInfo
+	waitFor(t, func() bool {
+		adminRequest(t, h, http.MethodGet, "/streams", &streams)
+		return len(streams) == 1 && streams[0].Received == 3
+	})
+	got := streams[0]
+	if got.Pending["Mountain View, CA"] != 2 || got.Pending["San Jose, CA"] != 1 || got.Peer == "" || got.Identity == "" ||
+		got.Started.IsZero() || got.LastActivity.Before(got.Started) {
+		t.Errorf("stream %+v", got)
+	}
+
+	// Force-flush ships pending batches. Принудительная отправка выпускает ожидающие партии
+	if code := adminRequest(t, h, http.MethodPost, "/streams/"+got.ID+"/flush", nil); code != http.StatusAccepted {
+		t.Fatalf("flush: status %d", code)
+	}
+	for i := 0; i < 2; i++ {
+		comb, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if comb.GetTrigger() != pb.FlushTrigger_FLUSH_TRIGGER_ADMIN {
+			t.Errorf("shipment %v, want trigger ADMIN", comb)
+		}
+	}
+
+	// Cancel ends stream with Aborted. Отмена завершает поток с Aborted
+	if code := adminRequest(t, h, http.MethodPost, "/streams/"+got.ID+"/cancel", nil); code != http.StatusAccepted {
+		t.Fatalf("cancel: status %d", code)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.Aborted {
+		t.Errorf("after cancel: %v, want Aborted", err)
+	}
+	waitFor(t, func() bool {
+		adminRequest(t, h, http.MethodGet, "/streams", &streams)
+		return len(streams) == 0
+	})
+
+	for _, tc := range []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodPost, "/streams/" + got.ID + "/flush", http.StatusNotFound},
+		{http.MethodGet, "/streams/" + got.ID + "/cancel", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/streams/" + got.ID + "/pause", http.StatusNotFound},
+		{http.MethodPost, "/streams", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/debug/pprof/", http.StatusOK},
+	} {
+		if code := adminRequest(t, h, tc.method, tc.path, nil); code != tc.want {
+			t.Errorf("%s %s: status %d, want %d", tc.method, tc.path, code, tc.want)
+		}
+	}
+}
+
+// Channelz is served over gRPC without TLS on same port. Channelz обслуживается по gRPC без TLS на том же порту
+func TestAdmin_Channelz(t *testing.T) {
+	ts := httptest.NewServer(newAdminServer(newStreamRegistry()).handler())
+	defer ts.Close()
+
+	conn, err := grpc.Dial(ts.Listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	resp, err := channelzpb.NewChannelzClient(conn).GetServers(ctx, &channelzpb.GetServersRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.GetServer()) == 0 {
+		t.Error("channelz has no servers")
+	}
+}
@@ -0,0 +1,133 @@
+// Журнал аудита потоков заказов: удостоверение клиента, поток, решения по ID заказов и отправленные партии
+// Audit trail of order streams: peer identity, stream, decisions on order IDs and emitted shipments
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"strings"
+
+	"github.com/blablatov/bidistream-mtls-grpc/bs-audit/audit"
+	pb "github.com/blablatov/bidistream-mtls-grpc/bs-mtls-proto"
+	epb "google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+)
+
+// Opens audit log of configuration, nil logger if it is off. Closer is nil then too
+// Открывает журнал аудита из настроек, пустой журнал, если аудит выключен. Тогда и closer пустой
+func openAudit(cfg config) (*audit.Logger, func() error, error) {
+	if cfg.AuditFile == "" {
+		return nil, nil, nil
+	}
+	prev := ""
+	if cfg.AuditChain {
+		// Chain continues after restart. Цепочка продолжается после перезапуска
+		var err error
+		if prev, err = audit.LastHash(cfg.AuditFile); err != nil {
+			return nil, nil, err
+		}
+	}
+	f, err := audit.OpenFile(cfg.AuditFile, int64(cfg.AuditMaxSize)<<20, cfg.AuditKeep)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cfg.AuditChain {
+		return audit.NewChained(f, prev), f.Close, nil
+	}
+	return audit.New(f), f.Close, nil
+}
+
+// Sets audit log of streams. Задает журнал аудита потоков
+func withAudit(l *audit.Logger) serverOption {
+	return func(s *mserver) { s.audit = l }
+}
+
+// Audit of one stream, nil if audit is off. Аудит одного потока, nil при выключенном аудите
+type streamAudit struct {
+	log  *audit.Logger
+	base audit.Record // Identity, peer and stream of all records. Общие поля записей
+}
+
+// Writes stream_open record. Записывает открытие потока
+func (s *mserver) auditStream(stream grpc.ServerStream) *streamAudit {
+	if s.audit == nil {
+		return nil
+	}
+	a := &streamAudit{log: s.audit, base: audit.Record{
+		Identity: callerIdentity(stream.Context()),
+		Peer:     peerAddr(stream.Context()),
+		Stream:   streamID(stream.Context()),
+	}}
+	a.base.Method, _ = grpc.MethodFromServerStream(stream)
+	a.write(audit.Record{Event: audit.EventStreamOpen})
+	return a
+}
+
+func (a *streamAudit) write(rec audit.Record) {
+	rec.Identity, rec.Peer, rec.Stream, rec.Method = a.base.Identity, a.base.Peer, a.base.Stream, a.base.Method
+	if err := a.log.Log(rec); err != nil {
+		slog.Error("audit record is lost", "stream", a.base.Stream, "err", err)
+	}
+}
+
+// Decision on received order ID. Решение по полученному ID заказа
+func (a *streamAudit) order(id, decision, reason string) {
+	if a != nil {
+		a.write(audit.Record{Event: audit.EventOrder, OrderID: id, Decision: decision, Reason: reason})
+	}
+}
+
+// Emitted shipment. Отправленная партия
+func (a *streamAudit) shipment(comb *pb.CombinedShipment) {
+	if a == nil {
+		return
+	}
+	ids := make([]string, 0, len(comb.GetOrdersList()))
+	for _, o := range comb.GetOrdersList() {
+		ids = append(ids, o.GetId())
+	}
+	rec := audit.Record{Event: audit.EventShipment, Shipment: comb.GetId(), Sequence: comb.GetSequence(), Orders: ids}
+	if comb.GetTrigger() != pb.FlushTrigger_FLUSH_TRIGGER_UNSPECIFIED {
+		rec.Trigger = comb.GetTrigger().String()
+	}
+	a.write(rec)
+}
+
+// End of stream with its error. Завершение потока с его ошибкой
+func (a *streamAudit) close(err error) {
+	if a == nil {
+		return
+	}
+	reason := "end of stream"
+	if err != nil {
+		reason = err.Error()
+	}
+	a.write(audit.Record{Event: audit.EventStreamClose, Reason: reason})
+}
+
+// Reason of rejection of order. Причина отклонения заказа
+func violationsReason(violations []*epb.BadRequest_FieldViolation) string {
+	reasons := make([]string, 0, len(violations))
+	for _, v := range violations {
+		reasons = append(reasons, v.GetField()+": "+v.GetDescription())
+	}
+	return strings.Join(reasons, "; ")
+}
+
+func peerAddr(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return p.Addr.String()
+	}
+	return ""
+}
+
+// Random ID of stream. Случайный идентификатор потока
+func newStreamID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
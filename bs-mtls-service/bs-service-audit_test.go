@@ -0,0 +1,130 @@
+// Тестирование журнала аудита потоков. Testing of audit trail of streams
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/blablatov/bidistream-mtls-grpc/bs-audit/audit"
+	"github.com/golang/protobuf/ptypes/wrappers"
+)
+
+// Buffer safe for concurrent use. Буфер для параллельного использования
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// Records written so far. Записанные записи
+func (b *syncBuffer) records(t *testing.T) []audit.Record {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var recs []audit.Record
+	sc := bufio.NewScanner(bytes.NewReader(b.buf.Bytes()))
+	for sc.Scan() {
+		var rec audit.Record
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatal(err)
+		}
+		recs = append(recs, rec)
+	}
+	return recs
+}
+
+func TestServer_ProcessOrdersAudit(t *testing.T) {
+	var buf syncBuffer
+	client := newBufConnClient(t, newMemoryStore(sampleOrders()...),
+		withBatchPolicy(batchPolicy{MaxOrders: 2}), withLenient(true), withAudit(audit.New(&buf)))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	stream, err := client.ProcessOrders(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"102", "999", "104"} {
+		if err := stream.Send(&wrappers.StringValue{Value: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stream.CloseSend()
+	for {
+		if _, err := stream.Recv(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Close record follows end of handler. Запись закрытия следует за завершением обработчика
+	var recs []audit.Record
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if recs = buf.records(t); len(recs) > 0 && recs[len(recs)-1].Event == audit.EventStreamClose {
+			break
+		}
+	}
+	var events []string
+	for _, r := range recs {
+		if r.Stream != recs[0].Stream || r.Stream == "" || r.Identity == "" || r.Method != methodProcess {
+			t.Errorf("record %+v lacks stream, identity or method of %+v", r, recs[0])
+		}
+		switch r.Event {
+		case audit.EventOrder:
+			events = append(events, r.OrderID+" "+r.Decision)
+			if r.Decision == audit.Rejected && !strings.Contains(r.Reason, "not found") {
+				t.Errorf("reason of rejected %s = %q", r.OrderID, r.Reason)
+			}
+		case audit.EventShipment:
+			events = append(events, r.Shipment+" "+strings.Join(r.Orders, ","))
+		default:
+			events = append(events, r.Event)
+		}
+	}
+	want := "stream_open|102 accepted|999 rejected|rej - 999 |104 accepted|cmb - Mountain View, CA 102,104|stream_close"
+	if got := strings.Join(events, "|"); got != want {
+		t.Errorf("audit events:\n%s\nwant\n%s", got, want)
+	}
+}
+
+// Chained log continues after restart. Связанный журнал продолжается после перезапуска
+func TestOpenAudit_Chain(t *testing.T) {
+	cfg := config{AuditFile: filepath.Join(t.TempDir(), "audit.log"), AuditChain: true}
+	for i := 0; i < 2; i++ {
+		l, closeAudit, err := openAudit(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Log(audit.Record{Event: audit.EventStreamOpen}); err != nil {
+			t.Fatal(err)
+		}
+		closeAudit()
+	}
+	f, err := os.Open(cfg.AuditFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, n, err := audit.Verify(f, ""); err != nil || n != 2 {
+		t.Errorf("Verify() = %d, %v, want 2 chained records", n, err)
+	}
+	if l, closeAudit, err := openAudit(config{}); l != nil || closeAudit != nil || err != nil {
+		t.Errorf("openAudit() without file = %v, %v", l, err)
+	}
+}
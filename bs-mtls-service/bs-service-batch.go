@@ -0,0 +1,192 @@
+// Объединение заказов в партии по адресу доставки. Batching of orders per destination
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	pb "github.com/blablatov/bidistream-mtls-grpc/bs-mtls-proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+// Metadata keys overriding batching policy of stream
+// Ключи метаданных, переопределяющие политику объединения для потока
+const (
+	mdBatchMaxOrders = "x-batch-max-orders"
+	mdBatchMaxWait   = "x-batch-max-wait"
+	mdBatchMaxPrice  = "x-batch-max-price"
+	mdBatchMaxItems  = "x-batch-max-items"
+)
+
+// Batching policy of ProcessOrders, zero value of limit disables it
+// Политика объединения заказов в партии, нулевое значение отключает предел
+type batchPolicy struct {
+	MaxOrders int           // Orders per destination. Число заказов на адрес доставки
+	MaxWait   time.Duration // Wait since first order of batch. Ожидание с первого заказа партии
+	MaxPrice  float32       // Total price per destination. Общая стоимость на адрес доставки
+	MaxItems  int           // Total items per destination. Общее число товаров на адрес доставки
+}
+
+var defaultBatchPolicy = batchPolicy{MaxOrders: orderBatchSize}
+
+// Returns copy of policy with overrides from metadata of stream
+// Возвращает копию политики с переопределениями из метаданных потока
+func (p batchPolicy) withMetadata(md metadata.MD) (batchPolicy, error) {
+	if v := md.Get(mdBatchMaxOrders); len(v) > 0 {
+		n, err := strconv.Atoi(v[0])
+		if err != nil || n < 0 {
+			return p, batchMetadataError(mdBatchMaxOrders, v[0])
+		}
+		p.MaxOrders = n
+	}
+	if v := md.Get(mdBatchMaxWait); len(v) > 0 {
+		d, err := time.ParseDuration(v[0])
+		if err != nil || d < 0 {
+			return p, batchMetadataError(mdBatchMaxWait, v[0])
+		}
+		p.MaxWait = d
+	}
+	if v := md.Get(mdBatchMaxPrice); len(v) > 0 {
+		f, err := strconv.ParseFloat(v[0], 32)
+		if err != nil || f < 0 {
+			return p, batchMetadataError(mdBatchMaxPrice, v[0])
+		}
+		p.MaxPrice = float32(f)
+	}
+	if v := md.Get(mdBatchMaxItems); len(v) > 0 {
+		n, err := strconv.Atoi(v[0])
+		if err != nil || n < 0 {
+			return p, batchMetadataError(mdBatchMaxItems, v[0])
+		}
+		p.MaxItems = n
+	}
+	return p, nil
+}
+
+func batchMetadataError(key, value string) error {
+	return fieldError(codes.InvalidArgument, "Batching policy in metadata is not valid - Invalid information",
+		key, fmt.Sprintf("Value of %s is not valid : %q", key, value))
+}
+
+// Pending shipment of one destination. Накапливаемая партия одного адреса доставки
+type pendingShipment struct {
+	shipment *pb.CombinedShipment
+	started  time.Time // Arrival of first order. Время поступления первого заказа
+	price    float32
+	items    int
+}
+
+// Groups orders of one stream into shipments. Объединяет заказы одного потока в партии
+// Shipments are flushed in first-seen order of destinations and numbered in sequence
+// Партии отправляются в порядке первого появления адреса и нумеруются по порядку
+type shipmentBatcher struct {
+	policy  batchPolicy
+	pending map[string]*pendingShipment
+	order   []string // Destinations in first-seen order. Адреса в порядке появления
+	seq     uint64   // Last sequence number. Последний номер партии
+}
+
+func newShipmentBatcher(policy batchPolicy) *shipmentBatcher {
+	return &shipmentBatcher{policy: policy, pending: make(map[string]*pendingShipment)}
+}
+
+// Adds order, returns shipment if a limit of its destination is reached
+// Добавляет заказ, возвращает партию, если достигнут предел ее адреса доставки
+func (b *shipmentBatcher) add(ord *pb.Order, now time.Time) *pb.CombinedShipment {
+	destination := ord.GetDestination()
+	p, found := b.pending[destination]
+	if !found {
+		p = &pendingShipment{
+			shipment: &pb.CombinedShipment{Id: "cmb - " + destination, Status: "Processed!"},
+			started:  now,
+		}
+		b.pending[destination] = p
+		b.order = append(b.order, destination)
+	}
+	p.shipment.OrdersList = append(p.shipment.OrdersList, ord)
+	p.price += ord.GetPrice()
+	p.items += len(ord.GetItems())
+
+	switch {
+	case b.policy.MaxOrders > 0 && len(p.shipment.OrdersList) >= b.policy.MaxOrders:
+		return b.flush(destination, pb.FlushTrigger_FLUSH_TRIGGER_MAX_ORDERS)
+	case b.policy.MaxPrice > 0 && p.price >= b.policy.MaxPrice:
+		return b.flush(destination, pb.FlushTrigger_FLUSH_TRIGGER_MAX_PRICE)
+	case b.policy.MaxItems > 0 && p.items >= b.policy.MaxItems:
+		return b.flush(destination, pb.FlushTrigger_FLUSH_TRIGGER_MAX_ITEMS)
+	}
+	return nil
+}
+
+func (b *shipmentBatcher) flush(destination string, trigger pb.FlushTrigger) *pb.CombinedShipment {
+	p := b.pending[destination]
+	delete(b.pending, destination)
+	for i, d := range b.order {
+		if d == destination {
+			b.order = append(b.order[:i], b.order[i+1:]...)
+			break
+		}
+	}
+	p.shipment.Trigger = trigger
+	return b.stamp(p.shipment)
+}
+
+// Sets next sequence number of stream. Присваивает следующий номер партии потока
+func (b *shipmentBatcher) stamp(comb *pb.CombinedShipment) *pb.CombinedShipment {
+	b.seq++
+	comb.Sequence = b.seq
+	return comb
+}
+
+// Returns shipments waiting longer than MaxWait. Возвращает партии, ожидающие дольше MaxWait
+func (b *shipmentBatcher) expired(now time.Time) []*pb.CombinedShipment {
+	if b.policy.MaxWait <= 0 {
+		return nil
+	}
+	var due []string
+	for _, destination := range b.order {
+		if now.Sub(b.pending[destination].started) >= b.policy.MaxWait {
+			due = append(due, destination)
+		}
+	}
+	out := make([]*pb.CombinedShipment, 0, len(due))
+	for _, destination := range due {
+		out = append(out, b.flush(destination, pb.FlushTrigger_FLUSH_TRIGGER_MAX_WAIT))
+	}
+	return out
+}
+
+// Returns time of next MaxWait flush, ok is false if none is pending
+// Возвращает время ближайшей отправки по MaxWait
+func (b *shipmentBatcher) nextDeadline() (deadline time.Time, ok bool) {
+	if b.policy.MaxWait <= 0 {
+		return deadline, false
+	}
+	for _, p := range b.pending {
+		if d := p.started.Add(b.policy.MaxWait); !ok || d.Before(deadline) {
+			deadline, ok = d, true
+		}
+	}
+	return deadline, ok
+}
+
+// Flushes all pending shipments. Отправляет все накопленные партии
+func (b *shipmentBatcher) flushAll(trigger pb.FlushTrigger) []*pb.CombinedShipment {
+	out := make([]*pb.CombinedShipment, 0, len(b.order))
+	for len(b.order) > 0 {
+		out = append(out, b.flush(b.order[0], trigger))
+	}
+	return out
+}
+
+// Pending orders per destination. Ожидающие заказы по адресам доставки
+func (b *shipmentBatcher) pendingOrders() map[string]int {
+	out := make(map[string]int, len(b.pending))
+	for destination, p := range b.pending {
+		out[destination] = len(p.shipment.OrdersList)
+	}
+	return out
+}
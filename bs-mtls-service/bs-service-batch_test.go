@@ -0,0 +1,232 @@
+// Тестирование политики объединения заказов. Testing of batching policy
+
+package main
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/blablatov/bidistream-mtls-grpc/bs-mtls-proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestShipmentBatcher_Limits(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		policy  batchPolicy
+		orders  []*pb.Order
+		trigger pb.FlushTrigger
+		flushed int // Index of order causing flush. Номер заказа, вызвавшего отправку
+	}{
+		{
+			name:    "max orders per destination",
+			policy:  batchPolicy{MaxOrders: 2},
+			orders:  []*pb.Order{{Id: "1", Destination: "A"}, {Id: "2", Destination: "B"}, {Id: "3", Destination: "A"}},
+			trigger: pb.FlushTrigger_FLUSH_TRIGGER_MAX_ORDERS,
+			flushed: 2,
+		},
+		{
+			name:    "max price",
+			policy:  batchPolicy{MaxPrice: 100},
+			orders:  []*pb.Order{{Id: "1", Destination: "A", Price: 60}, {Id: "2", Destination: "A", Price: 40}},
+			trigger: pb.FlushTrigger_FLUSH_TRIGGER_MAX_PRICE,
+			flushed: 1,
+		},
+		{
+			name:    "max items",
+			policy:  batchPolicy{MaxItems: 3},
+			orders:  []*pb.Order{{Id: "1", Destination: "A", Items: []string{"a", "b"}}, {Id: "2", Destination: "A", Items: []string{"c"}}},
+			trigger: pb.FlushTrigger_FLUSH_TRIGGER_MAX_ITEMS,
+			flushed: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newShipmentBatcher(tt.policy)
+			for i, o := range tt.orders {
+				comb := b.add(o, now)
+				if i != tt.flushed {
+					if comb != nil {
+						t.Fatalf("add(%s) flushed %v", o.GetId(), comb)
+					}
+					continue
+				}
+				if comb == nil || comb.GetTrigger() != tt.trigger {
+					t.Fatalf("add(%s) = %v, want trigger %v", o.GetId(), comb, tt.trigger)
+				}
+			}
+		})
+	}
+}
+
+func TestShipmentBatcher_Expired(t *testing.T) {
+	now := time.Now()
+	b := newShipmentBatcher(batchPolicy{MaxWait: time.Second})
+	b.add(&pb.Order{Id: "1", Destination: "A"}, now)
+	b.add(&pb.Order{Id: "2", Destination: "B"}, now.Add(500*time.Millisecond))
+
+	if d, ok := b.nextDeadline(); !ok || !d.Equal(now.Add(time.Second)) {
+		t.Errorf("nextDeadline() = %v, %v, want %v", d, ok, now.Add(time.Second))
+	}
+	out := b.expired(now.Add(time.Second))
+	if len(out) != 1 || out[0].GetId() != "cmb - A" || out[0].GetTrigger() != pb.FlushTrigger_FLUSH_TRIGGER_MAX_WAIT {
+		t.Errorf("expired() = %v, want shipment to A", out)
+	}
+	if out := b.flushAll(pb.FlushTrigger_FLUSH_TRIGGER_END_OF_STREAM); len(out) != 1 || out[0].GetId() != "cmb - B" {
+		t.Errorf("flushAll() = %v, want shipment to B", out)
+	}
+}
+
+func TestBatchPolicy_WithMetadata(t *testing.T) {
+	md := metadata.Pairs(mdBatchMaxOrders, "5", mdBatchMaxWait, "2s", mdBatchMaxPrice, "99.5", mdBatchMaxItems, "7")
+	p, err := defaultBatchPolicy.withMetadata(md)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (batchPolicy{MaxOrders: 5, MaxWait: 2 * time.Second, MaxPrice: 99.5, MaxItems: 7}); p != want {
+		t.Errorf("withMetadata() = %+v, want %+v", p, want)
+	}
+
+	_, err = defaultBatchPolicy.withMetadata(metadata.Pairs(mdBatchMaxWait, "soon"))
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("withMetadata(soon) = %v, want InvalidArgument", err)
+	}
+}
+
+// Idle stream is flushed by timer, rest is flushed at EOF
+// Партия молчащего потока отправляется по таймеру, остаток - по концу потока
+func TestServer_ProcessOrdersBatchWait(t *testing.T) {
+	client := newBufConnClient(t, newMemoryStore(sampleOrders()...),
+		withBatchPolicy(batchPolicy{MaxWait: 100 * time.Millisecond}))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	streamProcOrder, err := client.ProcessOrders(ctx)
+	if err != nil {
+		t.Fatalf("ProcessOrders(_) = _, %v", err)
+	}
+	for _, id := range []string{"102", "104"} {
+		if err := streamProcOrder.Send(&wrappers.StringValue{Value: id}); err != nil {
+			t.Fatalf("Send(%s) = %v", id, err)
+		}
+	}
+	comb, err := streamProcOrder.Recv()
+	if err != nil {
+		t.Fatalf("Recv() = %v", err)
+	}
+	if comb.GetTrigger() != pb.FlushTrigger_FLUSH_TRIGGER_MAX_WAIT || len(comb.GetOrdersList()) != 2 {
+		t.Errorf("Recv() = %v, want 2 orders flushed by max wait", comb)
+	}
+
+	if err := streamProcOrder.Send(&wrappers.StringValue{Value: "103"}); err != nil {
+		t.Fatalf("Send(103) = %v", err)
+	}
+	if err := streamProcOrder.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	comb, err = streamProcOrder.Recv()
+	if err != nil || comb.GetTrigger() != pb.FlushTrigger_FLUSH_TRIGGER_END_OF_STREAM {
+		t.Errorf("Recv() = %v, %v, want flush at end of stream", comb, err)
+	}
+	if _, err := streamProcOrder.Recv(); err != io.EOF {
+		t.Errorf("Recv() = %v, want EOF", err)
+	}
+}
+
+// Policy is overridden by metadata of stream. Политика переопределяется метаданными потока
+func TestServer_ProcessOrdersBatchMetadata(t *testing.T) {
+	client := newBufConnClient(t, newMemoryStore(sampleOrders()...))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, mdBatchMaxOrders, "3")
+
+	streamProcOrder, err := client.ProcessOrders(ctx)
+	if err != nil {
+		t.Fatalf("ProcessOrders(_) = _, %v", err)
+	}
+	for _, id := range []string{"102", "103", "104", "106"} {
+		if err := streamProcOrder.Send(&wrappers.StringValue{Value: id}); err != nil {
+			t.Fatalf("Send(%s) = %v", id, err)
+		}
+	}
+	comb, err := streamProcOrder.Recv()
+	if err != nil {
+		t.Fatalf("Recv() = %v", err)
+	}
+	if comb.GetId() != "cmb - Mountain View, CA" || len(comb.GetOrdersList()) != 3 {
+		t.Errorf("Recv() = %v, want 3 orders to Mountain View, CA", comb)
+	}
+}
+
+// Shipments are flushed in first-seen order with sequence numbers
+// Партии отправляются в порядке появления адресов и нумеруются по порядку
+func TestShipmentBatcher_Order(t *testing.T) {
+	now := time.Now()
+	b := newShipmentBatcher(batchPolicy{MaxOrders: 2})
+	for _, o := range []*pb.Order{
+		{Id: "1", Destination: "C"}, {Id: "2", Destination: "A"}, {Id: "3", Destination: "B"},
+		{Id: "4", Destination: "A"}, {Id: "5", Destination: "D"}, {Id: "6", Destination: "A"},
+	} {
+		if comb := b.add(o, now); comb != nil && (comb.GetId() != "cmb - A" || comb.GetSequence() != 1) {
+			t.Fatalf("add(%s) = %v, want first shipment to A", o.GetId(), comb)
+		}
+	}
+
+	var got []string
+	for i, comb := range b.flushAll(pb.FlushTrigger_FLUSH_TRIGGER_END_OF_STREAM) {
+		if want := uint64(i + 2); comb.GetSequence() != want {
+			t.Errorf("Sequence of %s = %d, want %d", comb.GetId(), comb.GetSequence(), want)
+		}
+		got = append(got, comb.GetId())
+	}
+	want := []string{"cmb - C", "cmb - B", "cmb - D", "cmb - A"}
+	if strings.Join(got, ";") != strings.Join(want, ";") {
+		t.Errorf("flushAll() = %v, want %v", got, want)
+	}
+}
+
+// Stream receives shipments in stable order at EOF. Поток получает партии в устойчивом порядке
+func TestServer_ProcessOrdersStableOrder(t *testing.T) {
+	client := newBufConnClient(t, newMemoryStore(sampleOrders()...), withBatchPolicy(batchPolicy{}))
+	for n := 0; n < 5; n++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		streamProcOrder, err := client.ProcessOrders(ctx)
+		if err != nil {
+			t.Fatalf("ProcessOrders(_) = _, %v", err)
+		}
+		for _, id := range []string{"11", "102", "103", "10", "104", "105"} {
+			if err := streamProcOrder.Send(&wrappers.StringValue{Value: id}); err != nil {
+				t.Fatalf("Send(%s) = %v", id, err)
+			}
+		}
+		streamProcOrder.CloseSend()
+
+		var got []string
+		for {
+			comb, err := streamProcOrder.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Recv() = %v", err)
+			}
+			if want := uint64(len(got) + 1); comb.GetSequence() != want {
+				t.Errorf("Sequence of %s = %d, want %d", comb.GetId(), comb.GetSequence(), want)
+			}
+			got = append(got, comb.GetId())
+		}
+		cancel()
+		want := []string{"cmb - Moscow", "cmb - Mountain View, CA", "cmb - San Jose, CA", "cmb - Texas, CA"}
+		if strings.Join(got, ";") != strings.Join(want, ";") {
+			t.Fatalf("shipments = %v, want %v", got, want)
+		}
+	}
+}
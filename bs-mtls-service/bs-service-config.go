@@ -0,0 +1,437 @@
+// Настройки gRPC-сервиса. Configuration of gRPC-service
+// Приоритет источников: файл YAML/JSON, затем переменные окружения BS_*, затем флаги
+// Precedence of sources: YAML/JSON file, then BS_* environment, then command-line flags
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blablatov/bidistream-mtls-grpc/bs-certwatch"
+	"github.com/blablatov/bidistream-mtls-grpc/bs-logging"
+	"github.com/blablatov/bidistream-mtls-grpc/bs-tracing"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	envPrefix = "BS_"
+	redacted  = "[REDACTED]"
+)
+
+// Effective configuration of service. Действующие настройки сервиса
+type config struct {
+	Port       string        `yaml:"port"`
+	CertFile   string        `yaml:"cert_file"`
+	KeyFile    string        `yaml:"key_file"`
+	CAFile     string        `yaml:"ca_file"`
+	CertReload time.Duration `yaml:"cert_reload"` // Polling of cert files. Опрос файлов сертификатов
+	Token      string        `yaml:"token"`       // Secret. Секрет
+	ACLFile    string        `yaml:"acl_file"`    // Empty - all verified clients. Пусто - все проверенные клиенты
+
+	JWKS        string              `yaml:"jwks"`         // File or URL, empty - static token. Файл или адрес, пусто - постоянный токен
+	JWTIssuer   string              `yaml:"jwt_issuer"`   // Empty - any. Пусто - любой
+	JWTAudience string              `yaml:"jwt_audience"` // Empty - any. Пусто - любой
+	JWTScopes   map[string][]string `yaml:"jwt_scopes"`   // Scope to methods. Область - методы
+
+	CRLDir        string `yaml:"crl_dir"`        // Empty - no CRL checks. Пусто - без проверки CRL
+	OCSPStaple    bool   `yaml:"ocsp_staple"`    // Staple OCSP response. Прикреплять ответ OCSP
+	OCSPResponder string `yaml:"ocsp_responder"` // Empty - from certificate. Пусто - из сертификата
+
+	RateLimit  float64 `yaml:"rate_limit"`  // Messages per second of identity, 0 - unlimited. Сообщений в секунду
+	RateBurst  int     `yaml:"rate_burst"`  // 0 - one second of messages. 0 - сообщения за секунду
+	MaxStreams int     `yaml:"max_streams"` // Open streams of identity, 0 - unlimited. Открытых потоков
+
+	MetricsAddr string `yaml:"metrics_addr"` // HTTP /metrics, empty - off. Пусто - выключены
+
+	AdminAddr   string `yaml:"admin_addr"`   // channelz, pprof and /streams, empty - off. Пусто - выключен
+	AdminRemote bool   `yaml:"admin_remote"` // Allow non-loopback admin_addr. Разрешить адрес не на loopback
+
+	TraceExporter string `yaml:"trace_exporter"` // none, stdout or otlp
+	TraceEndpoint string `yaml:"trace_endpoint"` // OTLP host:port, empty - OTEL_EXPORTER_OTLP_ENDPOINT
+
+	AuditFile    string `yaml:"audit_file"`     // JSON lines, empty - off. Строки JSON, пусто - выключен
+	AuditMaxSize int    `yaml:"audit_max_size"` // MB before rotation, 0 - never. МБ до ротации, 0 - никогда
+	AuditKeep    int    `yaml:"audit_keep"`     // Rotated files, 0 - all. Замененные файлы, 0 - все
+	AuditChain   bool   `yaml:"audit_chain"`    // SHA-256 hash chain. Цепочка хешей SHA-256
+
+	Store string `yaml:"store"`
+
+	BatchOrders int           `yaml:"batch_orders"`
+	BatchWait   time.Duration `yaml:"batch_wait"`
+	BatchPrice  float64       `yaml:"batch_price"`
+	BatchItems  int           `yaml:"batch_items"`
+
+	Lenient           bool     `yaml:"lenient"`
+	AllowDestinations []string `yaml:"allow_destinations"`
+	DenyDestinations  []string `yaml:"deny_destinations"`
+
+	DrainGrace   time.Duration `yaml:"drain_grace"`
+	DrainTimeout time.Duration `yaml:"drain_timeout"`
+
+	LogLevel  string `yaml:"log_level"`  // debug, info, warn or error
+	LogFormat string `yaml:"log_format"` // text or json
+}
+
+// Defaults keep behaviour of service before configuration. Значения по умолчанию
+func defaultConfig() config {
+	return config{
+		Port:         port,
+		CertFile:     filepath.Join("..", "bs-mcerts", "server.crt"),
+		KeyFile:      filepath.Join("..", "bs-mcerts", "server.key"),
+		CAFile:       filepath.Join("..", "bs-mcerts", "ca.crt"),
+		CertReload:   certwatch.DefaultInterval,
+		Token:        "blablatok-tokblabla-blablatok",
+		AuditMaxSize: auditMaxSize,
+		Store:        "memory",
+		BatchOrders:  orderBatchSize,
+		DrainGrace:   drainGrace,
+		DrainTimeout: drainTimeout,
+		LogLevel:     "info",
+		LogFormat:    logging.FormatText,
+	}
+}
+
+// One setting available as environment variable and flag
+// Одна настройка, доступная как переменная окружения и флаг
+type setting struct {
+	name   string // Flag name, env is BS_ + upper snake case. Имя флага
+	usage  string
+	set    func(c *config, v string) error
+	isBool bool
+}
+
+var settings = []setting{
+	{name: "port", usage: "listen address of gRPC-server", set: setString(func(c *config) *string { return &c.Port })},
+	{name: "cert-file", usage: "server certificate", set: setString(func(c *config) *string { return &c.CertFile })},
+	{name: "key-file", usage: "server private key", set: setString(func(c *config) *string { return &c.KeyFile })},
+	{name: "ca-file", usage: "CA certificate of clients", set: setString(func(c *config) *string { return &c.CAFile })},
+	{name: "cert-reload", usage: "polling period of cert, key, CA, ACL and CRL files, 0 - no reload", set: setDuration(func(c *config) *time.Duration { return &c.CertReload })},
+	{name: "token", usage: "expected bearer token if jwks is not set", set: setString(func(c *config) *string { return &c.Token })},
+	{name: "jwks", usage: "JWKS file or http(s) URL validating bearer JWTs, empty - static token", set: setString(func(c *config) *string { return &c.JWKS })},
+	{name: "jwt-issuer", usage: "required iss of JWT, empty - any", set: setString(func(c *config) *string { return &c.JWTIssuer })},
+	{name: "jwt-audience", usage: "required aud of JWT, empty - any", set: setString(func(c *config) *string { return &c.JWTAudience })},
+	{name: "jwt-scopes", usage: "semicolon separated scope=method,method patterns, empty - orders:read and orders:write", set: setScopes(func(c *config) *map[string][]string { return &c.JWTScopes })},
+	{name: "acl-file", usage: "YAML policy of client identities and allowed methods, empty - allow all verified clients", set: setString(func(c *config) *string { return &c.ACLFile })},
+	{name: "crl-dir", usage: "directory of CRL files checking client certificates, reloaded with certificates", set: setString(func(c *config) *string { return &c.CRLDir })},
+	{name: "ocsp-staple", usage: "staple OCSP response of server certificate to handshakes", isBool: true, set: setBool(func(c *config) *bool { return &c.OCSPStaple })},
+	{name: "ocsp-responder", usage: "URL of OCSP responder, empty - from server certificate", set: setString(func(c *config) *string { return &c.OCSPResponder })},
+	{name: "rate-limit", usage: "messages per second of client identity, 0 - unlimited", set: setFloat(func(c *config) *float64 { return &c.RateLimit })},
+	{name: "rate-burst", usage: "burst of messages of client identity, 0 - rate-limit messages", set: setInt(func(c *config) *int { return &c.RateBurst })},
+	{name: "max-streams", usage: "max concurrent streams of client identity, 0 - unlimited", set: setInt(func(c *config) *int { return &c.MaxStreams })},
+	{name: "metrics-addr", usage: "listen address of HTTP /metrics of Prometheus, empty - off", set: setString(func(c *config) *string { return &c.MetricsAddr })},
+	{name: "admin-addr", usage: "listen address of admin channelz, pprof and /streams, host defaults to 127.0.0.1, empty - off", set: setString(func(c *config) *string { return &c.AdminAddr })},
+	{name: "admin-remote", usage: "allow admin-addr on non-loopback interfaces", isBool: true, set: setBool(func(c *config) *bool { return &c.AdminRemote })},
+	{name: "trace-exporter", usage: "exporter of spans of streams: none, stdout or otlp", set: setString(func(c *config) *string { return &c.TraceExporter })},
+	{name: "trace-endpoint", usage: "OTLP collector host:port, http:// prefix - without TLS, empty - OTEL_EXPORTER_OTLP_ENDPOINT", set: setString(func(c *config) *string { return &c.TraceEndpoint })},
+	{name: "audit-file", usage: "append-only JSON lines audit log of order streams, empty - off", set: setString(func(c *config) *string { return &c.AuditFile })},
+	{name: "audit-max-size", usage: "rotate audit log larger than MB, 0 - never", set: setInt(func(c *config) *int { return &c.AuditMaxSize })},
+	{name: "audit-keep", usage: "rotated audit logs kept, 0 - all", set: setInt(func(c *config) *int { return &c.AuditKeep })},
+	{name: "audit-chain", usage: "chain audit records by SHA-256 hashes, check with bs-audit verify", isBool: true, set: setBool(func(c *config) *bool { return &c.AuditChain })},
+	{name: "store", usage: "order store: memory or file:/path", set: setString(func(c *config) *string { return &c.Store })},
+	{name: "batch-orders", usage: "max orders per destination in shipment, 0 - unlimited", set: setInt(func(c *config) *int { return &c.BatchOrders })},
+	{name: "batch-wait", usage: "max wait of shipment since its first order, 0 - unlimited", set: setDuration(func(c *config) *time.Duration { return &c.BatchWait })},
+	{name: "batch-price", usage: "max total price of shipment, 0 - unlimited", set: setFloat(func(c *config) *float64 { return &c.BatchPrice })},
+	{name: "batch-items", usage: "max total items of shipment, 0 - unlimited", set: setInt(func(c *config) *int { return &c.BatchItems })},
+	{name: "lenient", usage: "report rejected order IDs in stream instead of ending it", isBool: true, set: setBool(func(c *config) *bool { return &c.Lenient })},
+	{name: "allow-destinations", usage: "semicolon separated allowed destinations, empty - any", set: setList(func(c *config) *[]string { return &c.AllowDestinations })},
+	{name: "deny-destinations", usage: "semicolon separated denied destinations", set: setList(func(c *config) *[]string { return &c.DenyDestinations })},
+	{name: "drain-grace", usage: "wait after reporting NOT_SERVING before closing listener at shutdown, 0 - none", set: setDuration(func(c *config) *time.Duration { return &c.DrainGrace })},
+	{name: "drain-timeout", usage: "max wait of active streams at shutdown, 0 - unlimited", set: setDuration(func(c *config) *time.Duration { return &c.DrainTimeout })},
+	{name: "log-level", usage: "level of log: debug, info, warn or error, messages of streams are logged at debug", set: setString(func(c *config) *string { return &c.LogLevel })},
+	{name: "log-format", usage: "format of log: text or json", set: setString(func(c *config) *string { return &c.LogFormat })},
+}
+
+// Name of environment variable of setting. Имя переменной окружения настройки
+func (s setting) env() string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(s.name, "-", "_"))
+}
+
+func setString(field func(*config) *string) func(*config, string) error {
+	return func(c *config, v string) error {
+		*field(c) = v
+		return nil
+	}
+}
+
+func setInt(field func(*config) *int) func(*config, string) error {
+	return func(c *config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+func setFloat(field func(*config) *float64) func(*config, string) error {
+	return func(c *config, v string) error {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return err
+		}
+		*field(c) = f
+		return nil
+	}
+}
+
+func setBool(field func(*config) *bool) func(*config, string) error {
+	return func(c *config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+func setDuration(field func(*config) *time.Duration) func(*config, string) error {
+	return func(c *config, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = d
+		return nil
+	}
+}
+
+func setList(field func(*config) *[]string) func(*config, string) error {
+	return func(c *config, v string) error {
+		*field(c) = splitList(v)
+		return nil
+	}
+}
+
+func setScopes(field func(*config) *map[string][]string) func(*config, string) error {
+	return func(c *config, v string) error {
+		scopes, err := parseScopes(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = scopes
+		return nil
+	}
+}
+
+// Flag value collecting explicitly set flags. Значение флага, запоминающее явно заданные флаги
+type flagValue struct {
+	s      setting
+	values map[string]string
+}
+
+func (f *flagValue) String() string   { return f.values[f.s.name] }
+func (f *flagValue) IsBoolFlag() bool { return f.s.isBool }
+func (f *flagValue) Set(v string) error {
+	if err := f.s.set(&config{}, v); err != nil {
+		return err
+	}
+	f.values[f.s.name] = v
+	return nil
+}
+
+// Loads configuration from file, environment and arguments
+// Загружает настройки из файла, окружения и аргументов командной строки
+func loadConfig(args []string, lookupEnv func(string) (string, bool), output io.Writer) (config, error) {
+	fs := flag.NewFlagSet("bs-mtls-service", flag.ContinueOnError)
+	fs.SetOutput(output)
+	configFile := fs.String("config", "", "YAML or JSON configuration file, env "+envPrefix+"CONFIG")
+	flags := make(map[string]string)
+	for _, s := range settings {
+		fs.Var(&flagValue{s: s, values: flags}, s.name, s.usage+", env "+s.env())
+	}
+	if err := fs.Parse(args); err != nil {
+		return config{}, err
+	}
+
+	cfg := defaultConfig()
+	if *configFile == "" {
+		*configFile, _ = lookupEnv(envPrefix + "CONFIG")
+	}
+	if *configFile != "" {
+		if err := cfg.loadFile(*configFile); err != nil {
+			return config{}, err
+		}
+	}
+	for _, s := range settings {
+		if v, ok := lookupEnv(s.env()); ok {
+			if err := s.set(&cfg, v); err != nil {
+				return config{}, fmt.Errorf("%s: %w", s.env(), err)
+			}
+		}
+	}
+	for _, s := range settings {
+		if v, ok := flags[s.name]; ok {
+			s.set(&cfg, v) // Checked by flagValue.Set. Проверено в flagValue.Set
+		}
+	}
+	return cfg, cfg.validate()
+}
+
+// Reads file over current values. Relative paths are resolved against directory of file
+// Читает файл поверх текущих значений. Относительные пути отсчитываются от каталога файла
+func (c *config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	fileCfg := *c
+	// YAML parser reads JSON too. Парсер YAML читает и JSON
+	if err := yaml.Unmarshal(data, &fileCfg); err != nil {
+		return fmt.Errorf("config %s: %w", path, err)
+	}
+	dir := filepath.Dir(path)
+	resolve := func(p *string, old string) {
+		if *p != old && *p != "" && !filepath.IsAbs(*p) {
+			*p = filepath.Join(dir, *p)
+		}
+	}
+	resolve(&fileCfg.CertFile, c.CertFile)
+	resolve(&fileCfg.KeyFile, c.KeyFile)
+	resolve(&fileCfg.CAFile, c.CAFile)
+	resolve(&fileCfg.ACLFile, c.ACLFile)
+	if !isURL(fileCfg.JWKS) {
+		resolve(&fileCfg.JWKS, c.JWKS)
+	}
+	resolve(&fileCfg.CRLDir, c.CRLDir)
+	resolve(&fileCfg.AuditFile, c.AuditFile)
+	if strings.HasPrefix(fileCfg.Store, "file:") && fileCfg.Store != c.Store {
+		if p := strings.TrimPrefix(fileCfg.Store, "file:"); !filepath.IsAbs(p) {
+			fileCfg.Store = "file:" + filepath.Join(dir, p)
+		}
+	}
+	*c = fileCfg
+	return nil
+}
+
+// Checks settings at startup. Проверяет настройки при запуске
+func (c config) validate() error {
+	var errs []string
+	if _, _, err := net.SplitHostPort(c.Port); err != nil {
+		errs = append(errs, fmt.Sprintf("port %q: %v", c.Port, err))
+	}
+	for name, path := range map[string]string{"cert_file": c.CertFile, "key_file": c.KeyFile, "ca_file": c.CAFile} {
+		if _, err := os.Stat(path); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	for name, path := range map[string]string{"acl_file": c.ACLFile, "crl_dir": c.CRLDir} {
+		if _, err := os.Stat(path); path != "" && err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	if _, _, err := net.SplitHostPort(c.MetricsAddr); c.MetricsAddr != "" && err != nil {
+		errs = append(errs, fmt.Sprintf("metrics_addr %q: %v", c.MetricsAddr, err))
+	}
+	if c.MetricsAddr != "" && c.MetricsAddr == c.Port {
+		errs = append(errs, "metrics_addr must differ from port")
+	}
+	if c.AdminAddr != "" {
+		if addr, err := adminListenAddr(c.AdminAddr, c.AdminRemote); err != nil {
+			errs = append(errs, fmt.Sprintf("admin_addr %q: %v", c.AdminAddr, err))
+		} else if addr == c.Port || addr == c.MetricsAddr {
+			errs = append(errs, "admin_addr must differ from port and metrics_addr")
+		}
+	}
+	if _, err := logging.ParseLevel(c.LogLevel); err != nil {
+		errs = append(errs, fmt.Sprintf("log_level %q: want debug, info, warn or error", c.LogLevel))
+	}
+	if !logging.ValidFormat(c.LogFormat) {
+		errs = append(errs, fmt.Sprintf("log_format %q: want text or json", c.LogFormat))
+	}
+	if !tracing.ValidExporter(c.TraceExporter) {
+		errs = append(errs, fmt.Sprintf("trace_exporter %q: want none, stdout or otlp", c.TraceExporter))
+	}
+	if c.Token == "" && c.JWKS == "" {
+		errs = append(errs, "token is empty")
+	}
+	if _, err := os.Stat(c.JWKS); c.JWKS != "" && !isURL(c.JWKS) && err != nil {
+		errs = append(errs, fmt.Sprintf("jwks: %v", err))
+	}
+	for scope, patterns := range c.JWTScopes {
+		for _, p := range patterns {
+			if _, err := path.Match(p, ""); err != nil {
+				errs = append(errs, fmt.Sprintf("jwt_scopes %s: pattern %q: %v", scope, p, err))
+			}
+		}
+	}
+	if c.Store != "memory" && (!strings.HasPrefix(c.Store, "file:") || c.Store == "file:") {
+		errs = append(errs, fmt.Sprintf("store %q: want memory or file:/path", c.Store))
+	}
+	if c.BatchOrders < 0 || c.BatchWait < 0 || c.BatchPrice < 0 || c.BatchItems < 0 {
+		errs = append(errs, "batch limits must not be negative")
+	}
+	if c.RateLimit < 0 || c.RateBurst < 0 || c.MaxStreams < 0 {
+		errs = append(errs, "rate and stream limits must not be negative")
+	}
+	if c.AuditMaxSize < 0 || c.AuditKeep < 0 {
+		errs = append(errs, "audit size and keep must not be negative")
+	}
+	if c.CertReload < 0 {
+		errs = append(errs, "cert reload period must not be negative")
+	}
+	if c.DrainGrace < 0 {
+		errs = append(errs, "drain grace must not be negative")
+	}
+	if c.DrainTimeout < 0 {
+		errs = append(errs, "drain timeout must not be negative")
+	}
+	if len(errs) > 0 {
+		return errors.New("invalid config: " + strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Batching policy of configuration. Политика объединения заказов из настроек
+func (c config) batchPolicy() batchPolicy {
+	return batchPolicy{
+		MaxOrders: c.BatchOrders,
+		MaxWait:   c.BatchWait,
+		MaxPrice:  float32(c.BatchPrice),
+		MaxItems:  c.BatchItems,
+	}
+}
+
+// Validation chain of orders. Цепочка проверок заказов
+func (c config) validators() []OrderValidator {
+	v := append([]OrderValidator{}, defaultValidators...)
+	if len(c.AllowDestinations) > 0 || len(c.DenyDestinations) > 0 {
+		v = append(v, validateDestination(c.AllowDestinations, c.DenyDestinations))
+	}
+	return v
+}
+
+// Effective configuration with secrets redacted. Действующие настройки со скрытыми секретами
+func (c config) String() string {
+	if c.Token != "" {
+		c.Token = redacted
+	}
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return err.Error()
+	}
+	return string(data)
+}
+
+// Splits semicolon separated list, destinations may contain commas
+// Разбивает список, разделенный точкой с запятой, адреса могут содержать запятые
+func splitList(s string) []string {
+	var list []string
+	for _, e := range strings.Split(s, ";") {
+		if e = strings.TrimSpace(e); e != "" {
+			list = append(list, e)
+		}
+	}
+	return list
+}
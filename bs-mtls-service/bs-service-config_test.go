@@ -0,0 +1,130 @@
+// Тестирование настроек сервиса. Testing of service configuration
+
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blablatov/bidistream-mtls-grpc/bs-certs/pki"
+)
+
+// Environment lookup from map. Поиск переменных окружения в мапе
+func envMap(m map[string]string) func(string) (string, bool) {
+	return func(k string) (string, bool) {
+		v, ok := m[k]
+		return v, ok
+	}
+}
+
+// Writes config file with absolute paths of committed certificates
+// Пишет файл настроек с путями к сертификатам репозитория
+func writeConfig(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "bs-service.yaml")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig_Precedence(t *testing.T) {
+	certs, _ := filepath.Abs(filepath.Join("..", "bs-mcerts"))
+	path := writeConfig(t, `
+port: ":50060"
+cert_file: `+filepath.Join(certs, "server.crt")+`
+key_file: `+filepath.Join(certs, "server.key")+`
+ca_file: `+filepath.Join(certs, "ca.crt")+`
+batch_orders: 5
+batch_wait: 2s
+lenient: true
+deny_destinations: ["Mount RU, CA"]
+`)
+	env := map[string]string{
+		"BS_BATCH_ORDERS": "7",
+		"BS_PORT":         ":50061",
+	}
+	cfg, err := loadConfig([]string{"-config", path, "-port", ":50062"}, envMap(env), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != ":50062" {
+		t.Errorf("Port = %q, want flag value", cfg.Port)
+	}
+	if cfg.BatchOrders != 7 {
+		t.Errorf("BatchOrders = %d, want env value", cfg.BatchOrders)
+	}
+	if cfg.BatchWait != 2*time.Second || !cfg.Lenient {
+		t.Errorf("BatchWait, Lenient = %v, %v, want file values", cfg.BatchWait, cfg.Lenient)
+	}
+	if len(cfg.DenyDestinations) != 1 || cfg.DenyDestinations[0] != "Mount RU, CA" {
+		t.Errorf("DenyDestinations = %q", cfg.DenyDestinations)
+	}
+}
+
+// JSON file is read, relative paths are resolved against it
+// Читается JSON-файл, относительные пути отсчитываются от его каталога
+func TestLoadConfig_JSONRelativePaths(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := pki.NewCA("ca", pki.ECDSA, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := ca.Issue(pki.Request{CommonName: "localhost", DNSNames: []string{"localhost"}, Server: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Write(filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(dir, "ca.crt"), ca.CertPEM(), 0o600)
+	path := filepath.Join(dir, "bs-service.json")
+	os.WriteFile(path, []byte(`{"cert_file": "server.crt", "key_file": "server.key", "ca_file": "ca.crt", "store": "file:orders.log"}`), 0o600)
+
+	cfg, err := loadConfig(nil, envMap(map[string]string{"BS_CONFIG": path}), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.CertFile != filepath.Join(dir, "server.crt") {
+		t.Errorf("CertFile = %q, want resolved against %s", cfg.CertFile, dir)
+	}
+	if cfg.Store != "file:"+filepath.Join(dir, "orders.log") {
+		t.Errorf("Store = %q, want resolved against %s", cfg.Store, dir)
+	}
+}
+
+func TestLoadConfig_Invalid(t *testing.T) {
+	for name, args := range map[string][]string{
+		"bad port":       {"-port", "50051"},
+		"missing cert":   {"-cert-file", "/nonexistent/server.crt"},
+		"bad store":      {"-store", "redis:6379"},
+		"negative batch": {"-batch-items", "-1"},
+		"bad duration":   {"-batch-wait", "soon"},
+		"missing jwks":   {"-jwks", "/nonexistent/jwks.json"},
+		"bad scopes":     {"-jwt-scopes", "orders:read"},
+		"negative rate":  {"-rate-limit", "-5"},
+		"remote admin":   {"-admin-addr", "0.0.0.0:9091"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := loadConfig(args, envMap(nil), io.Discard); err == nil {
+				t.Errorf("loadConfig(%q) = nil error", args)
+			}
+		})
+	}
+	if _, err := loadConfig(nil, envMap(map[string]string{"BS_LENIENT": "maybe"}), io.Discard); err == nil {
+		t.Errorf("loadConfig(BS_LENIENT=maybe) = nil error")
+	}
+}
+
+// Secrets are redacted in report of config. Секреты скрываются при выводе настроек
+func TestConfig_StringRedacted(t *testing.T) {
+	cfg := defaultConfig()
+	out := cfg.String()
+	if strings.Contains(out, cfg.Token) || !strings.Contains(out, redacted) {
+		t.Errorf("String() = %s, want token redacted", out)
+	}
+}
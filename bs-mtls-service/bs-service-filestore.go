@@ -0,0 +1,378 @@
+// Файловое хранилище заказов. File-backed order storage
+// Журнал только на дозапись: каждая строка "<crc32> <json>\n".
+// Append-only log: every line is "<crc32> <json>\n". Torn tail is skipped on reload
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	pb "github.com/blablatov/bidistream-mtls-grpc/bs-mtls-proto"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const (
+	opPut = "put"
+	opDel = "del"
+
+	compactMinRecords = 1024 // Log is not compacted below this size. Минимальный размер журнала для сжатия
+)
+
+// Record of log. Запись журнала
+type logRecord struct {
+	Op    string          `json:"op"`
+	ID    string          `json:"id,omitempty"`
+	Order json.RawMessage `json:"order,omitempty"`
+}
+
+// Log file, replaced in tests to fail writes. Файл журнала, в тестах заменяется для сбоев записи
+type logFile interface {
+	io.ReadWriteSeeker
+	io.Closer
+	Sync() error
+	Truncate(size int64) error
+}
+
+// File store keeps index in memory and writes every change to log
+// Файловое хранилище держит индекс в памяти и пишет каждое изменение в журнал
+type fileStore struct {
+	mem *memoryStore
+
+	mu      sync.Mutex // Serializes writes of log. Упорядочивает запись журнала
+	path    string
+	f       logFile
+	records int   // Records in log. Число записей в журнале
+	failed  error // Log could not be repaired, writes are refused. Журнал не восстановлен, запись запрещена
+}
+
+// Opens store selected by spec: "memory" or "file:/path". Открывает хранилище по спецификации
+// New stores are filled with sample orders. Новое хранилище заполняется тестовыми заказами
+func openStore(spec string) (OrderStore, error) {
+	switch {
+	case spec == "" || spec == "memory":
+		store := newMemoryStore()
+		return store, initSampleData(store)
+	case strings.HasPrefix(spec, "file:"):
+		path := strings.TrimPrefix(spec, "file:")
+		_, err := os.Stat(path)
+		fresh := errors.Is(err, os.ErrNotExist)
+		store, err := openFileStore(path)
+		if err != nil {
+			return nil, err
+		}
+		if fresh {
+			if err := initSampleData(store); err != nil {
+				store.Close()
+				return nil, err
+			}
+		}
+		return store, nil
+	}
+	return nil, fmt.Errorf("unknown store %q, want memory or file:/path", spec)
+}
+
+// Opens log and replays it. Открывает журнал и восстанавливает по нему состояние
+func openFileStore(path string) (*fileStore, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	fs := &fileStore{mem: newMemoryStore(), path: path, f: f}
+	good, err := fs.replay()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	// Torn write at the end is cut off. Недописанный хвост журнала отбрасывается
+	if err := f.Truncate(good); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(good, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := fs.maybeCompact(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return fs, nil
+}
+
+// Replays log and returns offset after last good record
+// Восстанавливает состояние и возвращает смещение после последней целой записи
+func (fs *fileStore) replay() (int64, error) {
+	r := bufio.NewReader(fs.f)
+	var good int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				slog.Warn("store: skipped torn record", "path", fs.path, "offset", good)
+			}
+			return good, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		rec, err := decodeRecord(line)
+		if err != nil {
+			// Only the last record may be damaged. Повреждена может быть только последняя запись
+			if _, perr := r.Peek(1); perr == io.EOF {
+				slog.Warn("store: skipped torn record", "path", fs.path, "offset", good, "err", err)
+				return good, nil
+			}
+			return 0, fmt.Errorf("store %s: corrupted record at offset %d: %w", fs.path, good, err)
+		}
+		if err := fs.apply(rec); err != nil {
+			return 0, fmt.Errorf("store %s: record at offset %d: %w", fs.path, good, err)
+		}
+		fs.records++
+		good += int64(len(line))
+	}
+}
+
+func (fs *fileStore) apply(rec *logRecord) error {
+	switch rec.Op {
+	case opPut:
+		o := &pb.Order{}
+		if err := protojson.Unmarshal(rec.Order, o); err != nil {
+			return err
+		}
+		return fs.mem.Put(o)
+	case opDel:
+		fs.mem.Delete(rec.ID)
+		return nil
+	}
+	return fmt.Errorf("unknown operation %q", rec.Op)
+}
+
+func encodeRecord(rec *logRecord) ([]byte, error) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	line := make([]byte, 0, len(data)+10)
+	line = append(line, fmt.Sprintf("%08x ", crc32.ChecksumIEEE(data))...)
+	line = append(line, data...)
+	return append(line, '\n'), nil
+}
+
+func decodeRecord(line []byte) (*logRecord, error) {
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	sum, data, ok := bytes.Cut(line, []byte(" "))
+	if !ok {
+		return nil, errors.New("missing checksum")
+	}
+	want, err := strconv.ParseUint(string(sum), 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("bad checksum: %w", err)
+	}
+	if crc32.ChecksumIEEE(data) != uint32(want) {
+		return nil, errors.New("checksum mismatch")
+	}
+	rec := &logRecord{}
+	if err := json.Unmarshal(data, rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// Appends record and flushes it to disk. Failed write is cut off, so that torn record stays only at the tail
+// Дописывает запись и сбрасывает ее на диск. Неудачная запись отрезается, чтобы поврежденной могла быть только последняя
+func (fs *fileStore) append(rec *logRecord) error {
+	if fs.failed != nil {
+		return fs.failed
+	}
+	line, err := encodeRecord(rec)
+	if err != nil {
+		return err
+	}
+	off, err := fs.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err = fs.f.Write(line); err == nil {
+		err = fs.f.Sync()
+	}
+	if err != nil {
+		return fs.rollback(off, err)
+	}
+	fs.records++
+	return nil
+}
+
+// Cuts log back to off after failed write. If that fails too, store refuses further writes
+// Отрезает журнал до off после неудачной записи. Если и это не удалось, хранилище отказывает в записи
+func (fs *fileStore) rollback(off int64, err error) error {
+	terr := fs.f.Truncate(off)
+	if terr == nil {
+		_, terr = fs.f.Seek(off, io.SeekStart)
+	}
+	if terr != nil {
+		fs.failed = fmt.Errorf("store %s: log is damaged at offset %d: %v", fs.path, off, terr)
+		slog.Error("store: writes are refused", "path", fs.path, "offset", off, "err", terr)
+	}
+	return err
+}
+
+func (fs *fileStore) Get(id string) (*pb.Order, bool) {
+	return fs.mem.Get(id)
+}
+
+func (fs *fileStore) List() []*pb.Order {
+	return fs.mem.List()
+}
+
+func (fs *fileStore) Put(order *pb.Order) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.put(order)
+}
+
+// Check and write are atomic under mutex of writes. Проверка и запись атомарны под мьютексом записи
+func (fs *fileStore) Create(order *pb.Order) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	_, found := fs.mem.Get(order.GetId())
+	if err := checkExists(found, false); err != nil {
+		return err
+	}
+	return fs.put(order)
+}
+
+func (fs *fileStore) Update(order *pb.Order) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	_, found := fs.mem.Get(order.GetId())
+	if err := checkExists(found, true); err != nil {
+		return err
+	}
+	return fs.put(order)
+}
+
+// Appends and applies order, fs.mu is held. Дописывает и применяет заказ под fs.mu
+func (fs *fileStore) put(order *pb.Order) error {
+	data, err := protojson.Marshal(order)
+	if err != nil {
+		return err
+	}
+	if err := fs.append(&logRecord{Op: opPut, Order: data}); err != nil {
+		return err
+	}
+	if err := fs.mem.Put(order); err != nil {
+		return err
+	}
+	fs.compactAfterWrite()
+	return nil
+}
+
+func (fs *fileStore) Delete(id string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if _, ok := fs.mem.Get(id); !ok {
+		return errOrderNotFound
+	}
+	if err := fs.append(&logRecord{Op: opDel, ID: id}); err != nil {
+		return err
+	}
+	if err := fs.mem.Delete(id); err != nil {
+		return err
+	}
+	fs.compactAfterWrite()
+	return nil
+}
+
+// Compacts log when most of its records are outdated
+// Сжимает журнал, когда большая часть записей устарела
+func (fs *fileStore) maybeCompact() error {
+	live := len(fs.mem.List())
+	if fs.records < compactMinRecords || fs.records < 2*live {
+		return nil
+	}
+	return fs.compact()
+}
+
+// Compacts after durable write. Failure is logged, not returned: change is applied, next write retries
+// Сжимает после надежной записи. Ошибка пишется в журнал, а не возвращается: изменение применено, следующая запись повторит
+func (fs *fileStore) compactAfterWrite() {
+	if err := fs.maybeCompact(); err != nil {
+		slog.Error("store: compaction failed", "path", fs.path, "err", err)
+	}
+}
+
+// Writes live orders to temporary file and atomically replaces log
+// Пишет актуальные заказы во временный файл и атомарно подменяет журнал
+func (fs *fileStore) compact() error {
+	tmp := fs.path + ".compact"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	orders := fs.mem.List()
+	w := bufio.NewWriter(f)
+	for _, o := range orders {
+		data, err := protojson.Marshal(o)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		line, err := encodeRecord(&logRecord{Op: opPut, Order: data})
+		if err != nil {
+			f.Close()
+			return err
+		}
+		if _, err := w.Write(line); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := os.Rename(tmp, fs.path); err != nil {
+		f.Close()
+		return err
+	}
+	syncDir(filepath.Dir(fs.path))
+
+	fs.f.Close()
+	fs.f = f
+	fs.records = len(orders)
+	slog.Info("store: compacted", "path", fs.path, "records", fs.records)
+	return nil
+}
+
+// Flushes directory entry after rename. Сбрасывает на диск запись каталога после переименования
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
+
+// Closes log file. Закрывает файл журнала
+func (fs *fileStore) Close() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.f.Close()
+}
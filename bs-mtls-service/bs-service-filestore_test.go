@@ -0,0 +1,265 @@
+// Тестирование файлового хранилища заказов. Testing of file-backed order storage
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	pb "github.com/blablatov/bidistream-mtls-grpc/bs-mtls-proto"
+)
+
+// Orders survive reopening of store. Заказы сохраняются после повторного открытия
+func TestFileStore_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.log")
+	store, err := openFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, o := range sampleOrders() {
+		if err := store.Put(o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Delete("-1"); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	store, err = openFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if got, want := len(store.List()), len(sampleOrders())-1; got != want {
+		t.Errorf("len(List()) = %d, want %d", got, want)
+	}
+	if o, ok := store.Get("102"); !ok || o.GetDestination() != "Mountain View, CA" {
+		t.Errorf("Get(102) = %v, %v", o, ok)
+	}
+	if _, ok := store.Get("-1"); ok {
+		t.Errorf("Get(-1) found deleted order")
+	}
+}
+
+// Torn write at the end of log is skipped. Недописанная запись в конце журнала пропускается
+func TestFileStore_TornWrite(t *testing.T) {
+	for name, tail := range map[string]string{
+		"partial line": `0badc0de {"op":"put","order":{"id":"2`,
+		"bad checksum": "0badc0de {\"op\":\"del\",\"id\":\"1\"}\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "orders.log")
+			store, err := openFileStore(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Put(&pb.Order{Id: "1", Destination: "Moscow"}); err != nil {
+				t.Fatal(err)
+			}
+			store.Close()
+			good, _ := os.Stat(path)
+
+			f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.WriteString(tail)
+			f.Close()
+
+			store, err = openFileStore(path)
+			if err != nil {
+				t.Fatalf("openFileStore() = %v", err)
+			}
+			if _, ok := store.Get("1"); !ok {
+				t.Errorf("Get(1) not found after reload")
+			}
+			// Log is usable after the torn tail is cut. Журнал пригоден для записи после отсечения хвоста
+			if err := store.Put(&pb.Order{Id: "2", Destination: "Moscow"}); err != nil {
+				t.Fatal(err)
+			}
+			store.Close()
+			if fi, _ := os.Stat(path); fi.Size() <= good.Size() {
+				t.Errorf("log size = %d, want more than %d", fi.Size(), good.Size())
+			}
+
+			store, err = openFileStore(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+			if n := len(store.List()); n != 2 {
+				t.Errorf("len(List()) = %d, want 2", n)
+			}
+		})
+	}
+}
+
+// Damaged record in the middle of log is an error. Повреждение в середине журнала является ошибкой
+func TestFileStore_CorruptedMiddle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.log")
+	data := "0badc0de {\"op\":\"del\",\"id\":\"1\"}\n"
+	line, _ := encodeRecord(&logRecord{Op: opDel, ID: "1"})
+	if err := os.WriteFile(path, append([]byte(data), line...), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openFileStore(path); err == nil {
+		t.Errorf("openFileStore() = nil error, want corrupted record")
+	}
+}
+
+// Log file failing writes: half of line is written, or sync or truncate fail
+// Файл журнала со сбоями: записывается половина строки, либо не удаются sync или truncate
+type faultyLog struct {
+	logFile
+	shortWrite, failSync, failTruncate bool
+}
+
+var errDisk = errors.New("disk failure")
+
+func (f *faultyLog) Write(p []byte) (int, error) {
+	if f.shortWrite {
+		n, _ := f.logFile.Write(p[:len(p)/2])
+		return n, errDisk
+	}
+	return f.logFile.Write(p)
+}
+
+func (f *faultyLog) Sync() error {
+	if f.failSync {
+		return errDisk
+	}
+	return f.logFile.Sync()
+}
+
+func (f *faultyLog) Truncate(size int64) error {
+	if f.failTruncate {
+		return errDisk
+	}
+	return f.logFile.Truncate(size)
+}
+
+// Failed write is cut off, later records stay readable. Неудачная запись отрезается, следующие записи читаются
+func TestFileStore_FailedWrite(t *testing.T) {
+	for name, fault := range map[string]faultyLog{
+		"short write": {shortWrite: true},
+		"failed sync": {failSync: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "orders.log")
+			store, err := openFileStore(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Put(&pb.Order{Id: "1", Destination: "Moscow"}); err != nil {
+				t.Fatal(err)
+			}
+			f := fault
+			f.logFile = store.f
+			store.f = &f
+			if err := store.Put(&pb.Order{Id: "2", Destination: "Moscow"}); !errors.Is(err, errDisk) {
+				t.Fatalf("Put() = %v, want disk failure", err)
+			}
+			store.f = f.logFile
+			if err := store.Put(&pb.Order{Id: "3", Destination: "Moscow"}); err != nil {
+				t.Fatal(err)
+			}
+			store.Close()
+
+			store, err = openFileStore(path)
+			if err != nil {
+				t.Fatalf("openFileStore() = %v", err)
+			}
+			defer store.Close()
+			for id, want := range map[string]bool{"1": true, "2": false, "3": true} {
+				if _, ok := store.Get(id); ok != want {
+					t.Errorf("Get(%s) found = %v, want %v", id, ok, want)
+				}
+			}
+		})
+	}
+}
+
+// Log that can't be cut back refuses writes. Журнал, который не удалось отрезать, отказывает в записи
+func TestFileStore_FailedRollback(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.log")
+	store, err := openFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.f = &faultyLog{logFile: store.f, shortWrite: true, failTruncate: true}
+	if err := store.Put(&pb.Order{Id: "1", Destination: "Moscow"}); !errors.Is(err, errDisk) {
+		t.Fatalf("Put() = %v, want disk failure", err)
+	}
+	store.f = store.f.(*faultyLog).logFile
+	if err := store.Put(&pb.Order{Id: "2", Destination: "Moscow"}); err == nil {
+		t.Error("Put() after failed rollback = nil error")
+	}
+}
+
+// Compaction keeps live orders only. Сжатие оставляет только актуальные заказы
+func TestFileStore_Compact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.log")
+	store, err := openFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < compactMinRecords+10; i++ {
+		if err := store.Put(&pb.Order{Id: "1", Price: float32(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if store.records >= compactMinRecords {
+		t.Errorf("records = %d, want log compacted", store.records)
+	}
+	store.Close()
+
+	store, err = openFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if o, _ := store.Get("1"); o.GetPrice() != float32(compactMinRecords+9) {
+		t.Errorf("Get(1).Price = %v, want %v", o.GetPrice(), compactMinRecords+9)
+	}
+}
+
+// Failed compaction does not fail applied write. Неудачное сжатие не делает примененную запись ошибочной
+func TestFileStore_CompactFailed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.log")
+	store, err := openFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Directory at name of temporary file fails compaction. Каталог на месте временного файла мешает сжатию
+	if err := os.MkdirAll(filepath.Join(path+".compact", "busy"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < compactMinRecords+10; i++ {
+		if err := store.Put(&pb.Order{Id: "1", Price: float32(i)}); err != nil {
+			t.Fatalf("Put() with failed compaction = %v", err)
+		}
+	}
+	if err := store.Delete("1"); err != nil {
+		t.Fatalf("Delete() with failed compaction = %v", err)
+	}
+	if store.records < compactMinRecords {
+		t.Errorf("records = %d, want log not compacted", store.records)
+	}
+	store.Close()
+
+	if err := os.RemoveAll(path + ".compact"); err != nil {
+		t.Fatal(err)
+	}
+	store, err = openFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if _, ok := store.Get("1"); ok || store.records != 0 {
+		t.Errorf("after reopen: order 1 found %v, records %d, want deleted and compacted", ok, store.records)
+	}
+}
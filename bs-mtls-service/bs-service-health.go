@@ -0,0 +1,215 @@
+// Стандартная проверка состояния grpc.health.v1: готовность зависит от загруженного хранилища заказов
+// и действующего сертификата сервера, при остановке сервис переходит в NOT_SERVING
+// Standard grpc.health.v1 checking: readiness depends on loaded order store and valid server certificate,
+// service switches to NOT_SERVING at shutdown
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/blablatov/bidistream-mtls-grpc/bs-mtls-proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Period of readiness checks. Период проверки готовности
+const healthCheckPeriod = 10 * time.Second
+
+// Prefix of methods of health service. Префикс методов сервиса состояния
+const healthMethodPrefix = "/grpc.health.v1.Health/"
+
+// Services with status, "" is whole server. Сервисы со статусом, "" - весь сервер
+var healthServices = []string{"", pb.OrderManagement_ServiceDesc.ServiceName}
+
+// Readiness of server reported by health service. Готовность сервера, сообщаемая сервисом состояния
+type readiness struct {
+	health *health.Server
+	cert   func() *tls.Certificate // Current certificate of server, nil - not checked. Текущий сертификат
+	now    func() time.Time
+
+	mu       sync.Mutex
+	store    OrderStore // Nil until store is loaded. Nil, пока хранилище не загружено
+	reason   string     // Last reason of NOT_SERVING. Последняя причина NOT_SERVING
+	draining bool
+
+	stopped  chan struct{} // Closed by stop, ends watches. Закрывается stop, завершает наблюдения
+	stopOnce sync.Once
+}
+
+// Health server starts NOT_SERVING until store is loaded. Сервер состояния начинает с NOT_SERVING до загрузки хранилища
+func newReadiness(cert func() *tls.Certificate) *readiness {
+	r := &readiness{health: health.NewServer(), cert: cert, now: time.Now, reason: "order store is not loaded",
+		stopped: make(chan struct{})}
+	for _, svc := range healthServices {
+		r.health.SetServingStatus(svc, healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+	return r
+}
+
+// Registers health service. Регистрирует сервис состояния
+func (r *readiness) register(s *grpc.Server) {
+	healthpb.RegisterHealthServer(s, healthService{r.health, r.stopped})
+}
+
+// Marks store as loaded. Отмечает хранилище загруженным
+func (r *readiness) storeLoaded(store OrderStore) {
+	r.mu.Lock()
+	r.store = store
+	r.mu.Unlock()
+	r.update()
+}
+
+// Checks readiness, nil if server is ready. Проверяет готовность, nil - сервер готов
+func (r *readiness) check() error {
+	r.mu.Lock()
+	store := r.store
+	r.mu.Unlock()
+	if store == nil {
+		return errors.New("order store is not loaded")
+	}
+	if r.cert == nil {
+		return nil
+	}
+	return certValid(r.cert(), r.now())
+}
+
+// Sets status of services by readiness, changes are logged. Задает статус сервисов по готовности, изменения пишутся в журнал
+func (r *readiness) update() {
+	err := r.check()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.draining {
+		return
+	}
+	st, reason := healthpb.HealthCheckResponse_SERVING, ""
+	if err != nil {
+		st, reason = healthpb.HealthCheckResponse_NOT_SERVING, err.Error()
+	}
+	if reason != r.reason {
+		slog.Info("readiness changed", "status", st.String(), "reason", reason)
+		r.reason = reason
+	}
+	for _, svc := range healthServices {
+		r.health.SetServingStatus(svc, st)
+	}
+}
+
+// Checks readiness with period until ctx is done. Проверяет готовность с периодом, пока ctx не завершен
+func (r *readiness) run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			r.update()
+		}
+	}
+}
+
+// Switches services to NOT_SERVING for rest of shutdown. Переводит сервисы в NOT_SERVING до конца остановки
+func (r *readiness) drain() {
+	r.mu.Lock()
+	r.draining = true
+	r.mu.Unlock()
+	slog.Info("readiness changed", "status", healthpb.HealthCheckResponse_NOT_SERVING.String(), "reason", "server is shutting down")
+	r.health.Shutdown()
+}
+
+// Ends open watches with Unavailable, otherwise GracefulStop waits for them
+// Завершает открытые наблюдения с Unavailable, иначе GracefulStop их ждет
+func (r *readiness) stop() {
+	r.stopOnce.Do(func() { close(r.stopped) })
+}
+
+// Health service with watches ended by stop. Сервис состояния с наблюдениями, завершаемыми stop
+type healthService struct {
+	*health.Server
+	stopped <-chan struct{}
+}
+
+func (h healthService) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-h.stopped:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	err := h.Server.Watch(req, watchStream{stream, ctx})
+	select {
+	case <-h.stopped:
+		return errShuttingDown
+	default:
+		return err
+	}
+}
+
+// Watch stream with own context. Поток наблюдения с собственным контекстом
+type watchStream struct {
+	healthpb.Health_WatchServer
+	ctx context.Context
+}
+
+func (w watchStream) Context() context.Context { return w.ctx }
+
+// Error if certificate is missing, not yet valid or expired at now
+// Ошибка, если сертификата нет, он еще не действует или истек на момент now
+func certValid(cert *tls.Certificate, now time.Time) error {
+	if cert == nil || len(cert.Certificate) == 0 {
+		return errors.New("no server certificate")
+	}
+	leaf := cert.Leaf
+	if leaf == nil {
+		var err error
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return fmt.Errorf("server certificate: %v", err)
+		}
+	}
+	if now.Before(leaf.NotBefore) {
+		return fmt.Errorf("server certificate is not valid before %s", leaf.NotBefore.UTC().Format(time.RFC3339))
+	}
+	if now.After(leaf.NotAfter) {
+		return fmt.Errorf("server certificate expired at %s", leaf.NotAfter.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// Health checks need client certificate only, probes don't have bearer token
+// Проверкам состояния нужен только сертификат клиента, у проб нет токена
+func isHealthMethod(method string) bool {
+	return strings.HasPrefix(method, healthMethodPrefix)
+}
+
+// Unary interceptor skipping health checks. Унарный перехватчик, пропускающий проверки состояния
+func skipHealthUnary(next grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if isHealthMethod(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		return next(ctx, req, info, handler)
+	}
+}
+
+// Stream interceptor skipping health watches. Потоковый перехватчик, пропускающий наблюдение за состоянием
+func skipHealthStream(next grpc.StreamServerInterceptor) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if isHealthMethod(info.FullMethod) {
+			return handler(srv, ss)
+		}
+		return next(srv, ss, info, handler)
+	}
+}
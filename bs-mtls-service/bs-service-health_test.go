@@ -0,0 +1,133 @@
+// Тестирование проверки состояния. Testing of health checks
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"log"
+	"testing"
+	"time"
+
+	pb "github.com/blablatov/bidistream-mtls-grpc/bs-mtls-proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// Certificate valid for an hour from now. Сертификат, действующий час от текущего момента
+func hourCert(now time.Time) *tls.Certificate {
+	return &tls.Certificate{
+		Certificate: [][]byte{{0}},
+		Leaf:        &x509.Certificate{NotBefore: now.Add(-time.Minute), NotAfter: now.Add(time.Hour)},
+	}
+}
+
+// Status of service of health server. Статус сервиса сервера состояния
+func servingStatus(t *testing.T, r *readiness, service string) healthpb.HealthCheckResponse_ServingStatus {
+	t.Helper()
+	resp, err := r.health.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.Status
+}
+
+func TestReadiness(t *testing.T) {
+	now := time.Now()
+	cert := hourCert(now)
+	r := newReadiness(func() *tls.Certificate { return cert })
+	r.now = func() time.Time { return now }
+
+	for _, svc := range healthServices {
+		if st := servingStatus(t, r, svc); st != healthpb.HealthCheckResponse_NOT_SERVING {
+			t.Errorf("%q before store is loaded: %v", svc, st)
+		}
+	}
+	r.storeLoaded(newMemoryStore())
+	for _, svc := range healthServices {
+		if st := servingStatus(t, r, svc); st != healthpb.HealthCheckResponse_SERVING {
+			t.Errorf("%q when ready: %v", svc, st)
+		}
+	}
+
+	// Expired certificate, then rotated one. Истекший сертификат, затем замененный
+	r.now = func() time.Time { return now.Add(2 * time.Hour) }
+	r.update()
+	if st := servingStatus(t, r, pb.OrderManagement_ServiceDesc.ServiceName); st != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("with expired certificate: %v", st)
+	}
+	cert = hourCert(now.Add(2 * time.Hour))
+	r.update()
+	if st := servingStatus(t, r, ""); st != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("with rotated certificate: %v", st)
+	}
+
+	// Drain is final. Остановка окончательна
+	r.drain()
+	r.update()
+	for _, svc := range healthServices {
+		if st := servingStatus(t, r, svc); st != healthpb.HealthCheckResponse_NOT_SERVING {
+			t.Errorf("%q while draining: %v", svc, st)
+		}
+	}
+}
+
+func TestCertValid(t *testing.T) {
+	now := time.Now()
+	if err := certValid(hourCert(now), now); err != nil {
+		t.Error(err)
+	}
+	for name, at := range map[string]time.Time{"early": now.Add(-time.Hour), "expired": now.Add(2 * time.Hour)} {
+		if err := certValid(hourCert(now), at); err == nil {
+			t.Errorf("%s certificate is valid", name)
+		}
+	}
+	if err := certValid(nil, now); err == nil {
+		t.Error("missing certificate is valid")
+	}
+}
+
+// Health is checked without token, orders still need it
+// Состояние проверяется без токена, заказам токен по-прежнему нужен
+func TestHealth_NoToken(t *testing.T) {
+	lis := bufconn.Listen(bufSize)
+	s := grpc.NewServer(interceptorOpts(nil, nil, nil, nil)...)
+	r := newReadiness(nil)
+	r.register(s)
+	pb.RegisterOrderManagementServer(s, newServer(newMemoryStore(sampleOrders()...)))
+	r.storeLoaded(newMemoryStore())
+	go func() {
+		if err := s.Serve(lis); err != nil {
+			log.Printf("failed to serve: %v", err)
+		}
+	}()
+	defer s.Stop()
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(getBufDialer(lis)), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("did not connect: %v", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx,
+		&healthpb.HealthCheckRequest{Service: pb.OrderManagement_ServiceDesc.ServiceName})
+	if err != nil || resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("Check = %v, %v, want SERVING", resp, err)
+	}
+	if _, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: "unknown"}); status.Code(err) != codes.NotFound {
+		t.Errorf("unknown service: %v, want NotFound", err)
+	}
+	stream, err := pb.NewOrderManagementClient(conn).ProcessOrders(ctx)
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("orders without token: %v, want Unauthenticated", err)
+	}
+}
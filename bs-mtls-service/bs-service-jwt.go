@@ -0,0 +1,462 @@
+// Проверка токенов JWT по ключам JWKS. Validation of JWT by keys of JWKS
+// Подпись RS256/ES256/EdDSA, сроки exp/nbf, издатель iss и получатель aud, области scope по методам
+// Signature RS256/ES256/EdDSA, exp/nbf, issuer and audience, scopes mapped to methods
+
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	epb "google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Domain of ErrorInfo details. Домен описаний ErrorInfo
+const errorDomain = "bs-mtls-service"
+
+// Reasons of ErrorInfo of rejected tokens. Причины отклонения токена в ErrorInfo
+const (
+	reasonTokenMissing      = "TOKEN_MISSING"
+	reasonTokenMalformed    = "TOKEN_MALFORMED"
+	reasonTokenExpired      = "TOKEN_EXPIRED"
+	reasonTokenNotYetValid  = "TOKEN_NOT_YET_VALID"
+	reasonTokenSignature    = "TOKEN_SIGNATURE_INVALID"
+	reasonTokenAudience     = "TOKEN_AUDIENCE_INVALID"
+	reasonTokenIssuer       = "TOKEN_ISSUER_INVALID"
+	reasonInsufficientScope = "INSUFFICIENT_SCOPE"
+)
+
+// Allowed clock skew of exp and nbf. Допустимое расхождение часов для exp и nbf
+const jwtLeeway = 30 * time.Second
+
+// Scopes of methods by default. Области доступа методов по умолчанию
+var defaultJWTScopes = map[string][]string{
+	"orders:read": {
+		"/ecommerce.OrderManagement/getOrder",
+		"/ecommerce.OrderManagement/searchOrders",
+	},
+	"orders:write": {
+		"/ecommerce.OrderManagement/addOrder",
+		"/ecommerce.OrderManagement/updateOrder",
+		"/ecommerce.OrderManagement/deleteOrder",
+		"/ecommerce.OrderManagement/processOrders",
+	},
+}
+
+// Claims of validated token. Утверждения проверенного токена
+type tokenClaims struct {
+	jwt.RegisteredClaims
+	Scope string   `json:"scope,omitempty"` // Space separated, RFC 8693. Через пробел
+	Scp   []string `json:"scp,omitempty"`   // List form of some issuers. Списком у некоторых издателей
+}
+
+// Scopes of token. Области доступа токена
+func (c *tokenClaims) scopes() []string {
+	return append(strings.Fields(c.Scope), c.Scp...)
+}
+
+type claimsKey struct{}
+
+// Claims of call put by JWT interceptor, false without JWT
+// Утверждения токена вызова, помещенные перехватчиком JWT, false без JWT
+func claimsFromContext(ctx context.Context) (*tokenClaims, bool) {
+	c, ok := ctx.Value(claimsKey{}).(*tokenClaims)
+	return c, ok
+}
+
+// Subject of token as attribute of log, empty attribute is dropped. Субъект токена как атрибут журнала
+func subjectAttr(ctx context.Context) slog.Attr {
+	if c, ok := claimsFromContext(ctx); ok && c.Subject != "" {
+		return slog.String("subject", c.Subject)
+	}
+	return slog.Attr{}
+}
+
+// Public keys of JWKS file or URL by key ID, reloaded on change
+// Открытые ключи из файла или по адресу JWKS по идентификатору ключа, перечитываются при изменении
+type jwksKeys struct {
+	source string
+	client *http.Client
+	keys   atomic.Value // map[string]crypto.PublicKey
+
+	mu      sync.Mutex
+	modTime time.Time
+}
+
+// Loads keys of file or http(s) URL. Загружает ключи из файла или по адресу http(s)
+func newJWKS(source string) (*jwksKeys, error) {
+	k := &jwksKeys{source: source, client: &http.Client{Timeout: 10 * time.Second}}
+	if err := k.reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func isURL(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+func (k *jwksKeys) reload() error {
+	var data []byte
+	var err error
+	if isURL(k.source) {
+		data, err = k.fetch()
+	} else {
+		k.mu.Lock()
+		if fi, err := os.Stat(k.source); err == nil {
+			k.modTime = fi.ModTime()
+		}
+		k.mu.Unlock()
+		data, err = os.ReadFile(k.source)
+	}
+	if err != nil {
+		return err
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return fmt.Errorf("jwks %s: %w", k.source, err)
+	}
+	k.keys.Store(keys)
+	return nil
+}
+
+func (k *jwksKeys) fetch() ([]byte, error) {
+	resp, err := k.client.Get(k.source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwks %s: %s", k.source, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// Reloads keys until ctx is done: file on change, URL every interval
+// Перечитывает ключи, пока ctx не завершен: файл при изменении, адрес каждый interval
+func (k *jwksKeys) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			k.poll()
+		}
+	}
+}
+
+// Reloads changed file or URL. Перечитывает измененный файл или адрес
+func (k *jwksKeys) poll() {
+	if !isURL(k.source) {
+		fi, err := os.Stat(k.source)
+		k.mu.Lock()
+		changed := err == nil && !fi.ModTime().Equal(k.modTime)
+		k.mu.Unlock()
+		if !changed {
+			return
+		}
+	}
+	if err := k.reload(); err != nil {
+		slog.Error("JWKS reload failed, keeping last good keys", "source", k.source, "err", err)
+	}
+}
+
+// Key of token by kid, single key of set without kid. Ключ токена по kid, единственный ключ без kid
+func (k *jwksKeys) keyFunc(t *jwt.Token) (interface{}, error) {
+	keys := k.keys.Load().(map[string]crypto.PublicKey)
+	kid, _ := t.Header["kid"].(string)
+	key, ok := keys[kid]
+	if !ok && kid == "" && len(keys) == 1 {
+		for _, only := range keys {
+			key, ok = only, true
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	// Algorithm must fit type of key. Алгоритм должен соответствовать типу ключа
+	switch key.(type) {
+	case *rsa.PublicKey:
+		ok = t.Method == jwt.SigningMethodRS256
+	case *ecdsa.PublicKey:
+		ok = t.Method == jwt.SigningMethodES256
+	case ed25519.PublicKey:
+		ok = t.Method == jwt.SigningMethodEdDSA
+	}
+	if !ok {
+		return nil, fmt.Errorf("algorithm %s doesn't fit key %q", t.Method.Alg(), kid)
+	}
+	return key, nil
+}
+
+// Key of JWKS, RFC 7517. Ключ JWKS
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// Parses RSA, EC P-256 and Ed25519 signing keys, others are skipped
+// Разбирает ключи подписи RSA, EC P-256 и Ed25519, остальные пропускаются
+func parseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for i, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %d %q: %w", i, jwk.Kid, err)
+		}
+		if key == nil {
+			continue
+		}
+		if _, dup := keys[jwk.Kid]; dup {
+			return nil, fmt.Errorf("duplicate key %q", jwk.Kid)
+		}
+		keys[jwk.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no signing keys")
+	}
+	return keys, nil
+}
+
+// Public key of supported type, nil for other types. Открытый ключ поддерживаемого типа
+func (jwk jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	b64 := base64.RawURLEncoding.DecodeString
+	switch {
+	case jwk.Kty == "RSA":
+		n, err := b64(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("RSA key is shorter than 2048 bits or has invalid exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case jwk.Kty == "EC" && jwk.Crv == "P-256":
+		x, err := b64(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := b64(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("EC point is not on curve")
+		}
+		return key, nil
+	case jwk.Kty == "OKP" && jwk.Crv == "Ed25519":
+		x, err := b64(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, nil
+}
+
+// Validator of bearer JWT. Проверка токенов JWT
+type jwtValidator struct {
+	keys   *jwksKeys
+	parser *jwt.Parser
+	scopes map[string][]string // Scope to method patterns. Область - шаблоны методов
+}
+
+// Validator of configuration, nil if JWKS is not set. Проверка токенов по настройкам, nil без JWKS
+func newJWTValidator(cfg config) (*jwtValidator, error) {
+	if cfg.JWKS == "" {
+		return nil, nil
+	}
+	keys, err := newJWKS(cfg.JWKS)
+	if err != nil {
+		return nil, err
+	}
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"RS256", "ES256", "EdDSA"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(jwtLeeway),
+	}
+	if cfg.JWTIssuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.JWTIssuer))
+	}
+	if cfg.JWTAudience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.JWTAudience))
+	}
+	scopes := cfg.JWTScopes
+	if len(scopes) == 0 {
+		scopes = defaultJWTScopes
+	}
+	return &jwtValidator{keys: keys, parser: jwt.NewParser(opts...), scopes: scopes}, nil
+}
+
+// Status with ErrorInfo reason. Статус с причиной ErrorInfo
+func tokenError(code codes.Code, reason, msg string) error {
+	st := status.New(code, msg)
+	ds, err := st.WithDetails(&epb.ErrorInfo{Reason: reason, Domain: errorDomain})
+	if err != nil {
+		return st.Err()
+	}
+	return ds.Err()
+}
+
+// Reason of rejected token. Причина отклонения токена
+func tokenReason(err error) string {
+	switch {
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return reasonTokenExpired
+	case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		return reasonTokenNotYetValid
+	case errors.Is(err, jwt.ErrTokenInvalidAudience):
+		return reasonTokenAudience
+	case errors.Is(err, jwt.ErrTokenInvalidIssuer):
+		return reasonTokenIssuer
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid), errors.Is(err, jwt.ErrTokenUnverifiable):
+		return reasonTokenSignature
+	}
+	return reasonTokenMalformed
+}
+
+// Validates token of call and scope of method, returns context with claims
+// Проверяет токен вызова и область метода, возвращает контекст с утверждениями
+func (v *jwtValidator) authenticate(ctx context.Context, method string) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	auth := md["authorization"]
+	if len(auth) == 0 || !strings.HasPrefix(auth[0], "Bearer ") {
+		return nil, tokenError(codes.Unauthenticated, reasonTokenMissing, "missing bearer token")
+	}
+	claims := &tokenClaims{}
+	if _, err := v.parser.ParseWithClaims(strings.TrimPrefix(auth[0], "Bearer "), claims, v.keys.keyFunc); err != nil {
+		reason := tokenReason(err)
+		slog.Warn("token rejected", "reason", reason, "method", method, "err", err)
+		return nil, tokenError(codes.Unauthenticated, reason, "invalid token: "+err.Error())
+	}
+	if !v.allowed(claims.scopes(), method) {
+		slog.Warn("token lacks scope of method", "subject", claims.Subject, "method", method)
+		return nil, tokenError(codes.PermissionDenied, reasonInsufficientScope,
+			fmt.Sprintf("token has no scope allowing %s, need one of %s", method, strings.Join(v.scopesOf(method), ", ")))
+	}
+	return context.WithValue(ctx, claimsKey{}, claims), nil
+}
+
+// Reports whether any scope allows method. Разрешает ли метод любая из областей
+func (v *jwtValidator) allowed(scopes []string, method string) bool {
+	for _, scope := range scopes {
+		if matchAny(v.scopes[scope], method) {
+			return true
+		}
+	}
+	return false
+}
+
+// Scopes allowing method, sorted. Области, разрешающие метод
+func (v *jwtValidator) scopesOf(method string) []string {
+	var scopes []string
+	for scope, patterns := range v.scopes {
+		if matchAny(patterns, method) {
+			scopes = append(scopes, scope)
+		}
+	}
+	sort.Strings(scopes)
+	return scopes
+}
+
+// Unary interceptor of JWT. Унарный перехватчик JWT
+func (v *jwtValidator) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := v.authenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// Stream interceptor of JWT, handler sees context with claims
+// Потоковый перехватчик JWT, обработчик получает контекст с утверждениями
+func (v *jwtValidator) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler) error {
+	ctx, err := v.authenticate(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+}
+
+// Server stream with replaced context. Серверный поток с замененным контекстом
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context { return s.ctx }
+
+// Parses scopes of setting "scope=pattern,pattern;scope=pattern"
+// Разбирает области из строки "scope=pattern,pattern;scope=pattern"
+func parseScopes(s string) (map[string][]string, error) {
+	scopes := make(map[string][]string)
+	for _, e := range splitList(s) {
+		scope, patterns, ok := strings.Cut(e, "=")
+		scope = strings.TrimSpace(scope)
+		if !ok || scope == "" {
+			return nil, fmt.Errorf("scope %q: want scope=method,method", e)
+		}
+		for _, p := range strings.Split(patterns, ",") {
+			if p = strings.TrimSpace(p); p == "" {
+				continue
+			}
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("scope %s: pattern %q: %w", scope, p, err)
+			}
+			scopes[scope] = append(scopes[scope], p)
+		}
+	}
+	return scopes, nil
+}
@@ -0,0 +1,275 @@
+// Тестирование проверки токенов JWT. Testing of JWT validation
+
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	epb "google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	testIssuer   = "https://issuer.example.org"
+	testAudience = "bs-mtls-service"
+)
+
+// Signing key of test issuer. Ключ подписи тестового издателя
+type testSigner struct {
+	kid    string
+	method jwt.SigningMethod
+	key    crypto.Signer
+}
+
+func newSigners(t *testing.T) []testSigner {
+	t.Helper()
+	ec, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ed, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []testSigner{
+		{"ec-1", jwt.SigningMethodES256, ec},
+		{"rsa-1", jwt.SigningMethodRS256, rs},
+		{"ed-1", jwt.SigningMethodEdDSA, ed},
+	}
+}
+
+// JWKS document of public keys. Документ JWKS открытых ключей
+func jwksJSON(t *testing.T, signers ...testSigner) []byte {
+	t.Helper()
+	b64 := base64.RawURLEncoding.EncodeToString
+	var keys []map[string]string
+	for _, s := range signers {
+		jwk := map[string]string{"kid": s.kid, "use": "sig", "alg": s.method.Alg()}
+		switch pub := s.key.Public().(type) {
+		case *ecdsa.PublicKey:
+			jwk["kty"], jwk["crv"] = "EC", "P-256"
+			jwk["x"], jwk["y"] = b64(pub.X.FillBytes(make([]byte, 32))), b64(pub.Y.FillBytes(make([]byte, 32)))
+		case *rsa.PublicKey:
+			jwk["kty"], jwk["n"], jwk["e"] = "RSA", b64(pub.N.Bytes()), b64([]byte{1, 0, 1})
+		case ed25519.PublicKey:
+			jwk["kty"], jwk["crv"], jwk["x"] = "OKP", "Ed25519", b64(pub)
+		}
+		keys = append(keys, jwk)
+	}
+	data, err := json.Marshal(map[string]interface{}{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// Valid claims with scope. Действительные утверждения с областью доступа
+func testClaims(scope string) *tokenClaims {
+	now := time.Now()
+	return &tokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "bs-client",
+			Issuer:    testIssuer,
+			Audience:  jwt.ClaimStrings{testAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		},
+		Scope: scope,
+	}
+}
+
+func sign(t *testing.T, s testSigner, claims jwt.Claims) string {
+	t.Helper()
+	tok := jwt.NewWithClaims(s.method, claims)
+	tok.Header["kid"] = s.kid
+	raw, err := tok.SignedString(s.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func bearerContext(token string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+}
+
+// Reason of ErrorInfo of status. Причина ErrorInfo из статуса
+func errorReason(err error) string {
+	for _, d := range status.Convert(err).Details() {
+		if info, ok := d.(*epb.ErrorInfo); ok {
+			return info.GetReason()
+		}
+	}
+	return ""
+}
+
+func newTestValidator(t *testing.T, signers ...testSigner) *jwtValidator {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(file, jwksJSON(t, signers...), 0600); err != nil {
+		t.Fatal(err)
+	}
+	v, err := newJWTValidator(config{JWKS: file, JWTIssuer: testIssuer, JWTAudience: testAudience})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestJWTValidator_Algorithms(t *testing.T) {
+	signers := newSigners(t)
+	v := newTestValidator(t, signers...)
+	for _, s := range signers {
+		t.Run(s.method.Alg(), func(t *testing.T) {
+			ctx, err := v.authenticate(bearerContext(sign(t, s, testClaims("orders:write"))), methodProcess)
+			if err != nil {
+				t.Fatalf("authenticate() = %v", err)
+			}
+			if c, ok := claimsFromContext(ctx); !ok || c.Subject != "bs-client" {
+				t.Errorf("claimsFromContext() = %v, %v", c, ok)
+			}
+		})
+	}
+}
+
+func TestJWTValidator_Rejected(t *testing.T) {
+	signers := newSigners(t)
+	v := newTestValidator(t, signers...)
+	ec, rs := signers[0], signers[1]
+	other := newSigners(t)[0] // Same kid, other key. Тот же kid, другой ключ
+
+	claims := func(edit func(*tokenClaims)) *tokenClaims {
+		c := testClaims("orders:read orders:write")
+		edit(c)
+		return c
+	}
+	past := jwt.NewNumericDate(time.Now().Add(-time.Hour))
+	future := jwt.NewNumericDate(time.Now().Add(time.Hour))
+
+	tests := []struct {
+		name   string
+		token  string
+		method string
+		code   codes.Code
+		reason string
+	}{
+		{"expired", sign(t, ec, claims(func(c *tokenClaims) { c.ExpiresAt = past })), methodAdd, codes.Unauthenticated, reasonTokenExpired},
+		{"no exp", sign(t, ec, claims(func(c *tokenClaims) { c.ExpiresAt = nil })), methodAdd, codes.Unauthenticated, reasonTokenMalformed},
+		{"not yet valid", sign(t, ec, claims(func(c *tokenClaims) { c.NotBefore = future })), methodAdd, codes.Unauthenticated, reasonTokenNotYetValid},
+		{"audience", sign(t, ec, claims(func(c *tokenClaims) { c.Audience = jwt.ClaimStrings{"other"} })), methodAdd, codes.Unauthenticated, reasonTokenAudience},
+		{"issuer", sign(t, ec, claims(func(c *tokenClaims) { c.Issuer = "https://evil.example.org" })), methodAdd, codes.Unauthenticated, reasonTokenIssuer},
+		{"signature", sign(t, other, testClaims("orders:write")), methodAdd, codes.Unauthenticated, reasonTokenSignature},
+		{"unknown kid", sign(t, testSigner{"ec-9", ec.method, ec.key}, testClaims("orders:write")), methodAdd, codes.Unauthenticated, reasonTokenSignature},
+		{"algorithm of other key", sign(t, testSigner{rs.kid, ec.method, ec.key}, testClaims("orders:write")), methodAdd, codes.Unauthenticated, reasonTokenSignature},
+		{"malformed", "not.a.jwt", methodAdd, codes.Unauthenticated, reasonTokenMalformed},
+		{"scope", sign(t, ec, testClaims("orders:read")), methodProcess, codes.PermissionDenied, reasonInsufficientScope},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.authenticate(bearerContext(tt.token), tt.method)
+			if status.Code(err) != tt.code || errorReason(err) != tt.reason {
+				t.Errorf("authenticate() = %v, reason %q, want %v, %s", err, errorReason(err), tt.code, tt.reason)
+			}
+		})
+	}
+
+	_, err := v.authenticate(context.Background(), methodAdd)
+	if status.Code(err) != codes.Unauthenticated || errorReason(err) != reasonTokenMissing {
+		t.Errorf("authenticate() without token = %v", err)
+	}
+}
+
+// Keys of URL are fetched again on poll. Ключи по адресу перечитываются при опросе
+func TestJWKS_URLReload(t *testing.T) {
+	signers := newSigners(t)
+	doc := jwksJSON(t, signers[0])
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(doc)
+	}))
+	defer srv.Close()
+
+	v, err := newJWTValidator(config{JWKS: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := sign(t, signers[2], testClaims("orders:write"))
+	if _, err := v.authenticate(bearerContext(token), methodAdd); err == nil {
+		t.Fatal("authenticate() with key missing from JWKS = nil error")
+	}
+	doc = jwksJSON(t, signers...)
+	v.keys.poll()
+	if _, err := v.authenticate(bearerContext(token), methodAdd); err != nil {
+		t.Errorf("authenticate() after key rotation = %v", err)
+	}
+}
+
+func TestJWTValidator_StreamInterceptor(t *testing.T) {
+	signers := newSigners(t)
+	v := newTestValidator(t, signers...)
+	var subject string
+	handler := func(_ interface{}, ss grpc.ServerStream) error {
+		c, _ := claimsFromContext(ss.Context())
+		subject = c.Subject
+		return nil
+	}
+	ss := &fakeServerStream{ctx: bearerContext(sign(t, signers[0], testClaims("orders:write")))}
+	if err := v.streamInterceptor(nil, ss, &grpc.StreamServerInfo{FullMethod: methodProcess}, handler); err != nil {
+		t.Fatalf("streamInterceptor() = %v", err)
+	}
+	if subject != "bs-client" {
+		t.Errorf("handler saw subject %q, want bs-client", subject)
+	}
+}
+
+func TestParseJWKS_Invalid(t *testing.T) {
+	for name, data := range map[string]string{
+		"json":        "{",
+		"empty":       `{"keys": []}`,
+		"only enc":    `{"keys": [{"kty": "OKP", "crv": "Ed25519", "use": "enc", "x": "AAAA"}]}`,
+		"short rsa":   `{"keys": [{"kty": "RSA", "n": "AQAB", "e": "AQAB"}]}`,
+		"off curve":   `{"keys": [{"kty": "EC", "crv": "P-256", "x": "AQ", "y": "AQ"}]}`,
+		"ed25519 len": `{"keys": [{"kty": "OKP", "crv": "Ed25519", "x": "AQ"}]}`,
+	} {
+		if _, err := parseJWKS([]byte(data)); err == nil {
+			t.Errorf("parseJWKS(%s) = nil error", name)
+		}
+	}
+}
+
+func TestParseScopes(t *testing.T) {
+	scopes, err := parseScopes("orders:read=/ecommerce.OrderManagement/get*, /ecommerce.OrderManagement/search*; admin=*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scopes["orders:read"]) != 2 || len(scopes["admin"]) != 1 {
+		t.Errorf("parseScopes() = %v", scopes)
+	}
+	for _, s := range []string{"orders:read", "=*", "a=["} {
+		if _, err := parseScopes(s); err == nil {
+			t.Errorf("parseScopes(%q) = nil error", s)
+		}
+	}
+}
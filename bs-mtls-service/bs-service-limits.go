@@ -0,0 +1,285 @@
+// Ограничения клиентов: частота сообщений и число одновременных потоков на удостоверение
+// Limits of clients: rate of messages and concurrent streams per identity
+// Удостоверение - субъект токена JWT, иначе CN сертификата, иначе адрес клиента
+// Identity is subject of JWT, else CN of certificate, else address of peer
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	epb "google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Reasons of ErrorInfo of limited calls. Причины ограничения вызовов в ErrorInfo
+const (
+	reasonRateLimited   = "RATE_LIMITED"
+	reasonStreamsQuota  = "STREAM_QUOTA_EXCEEDED"
+	streamRetryDelay    = time.Second // Retry of stream over quota. Повтор потока сверх квоты
+	limitsReportPeriod  = time.Minute // Usage log and pruning of idle identities. Журнал и очистка простаивающих
+	headerRateLimit     = "x-ratelimit-limit"
+	headerRateRemaining = "x-ratelimit-remaining"
+	headerStreamsLimit  = "x-streams-limit"
+	headerStreamsActive = "x-streams-active"
+)
+
+// Usage of limits by identity. Использование ограничений удостоверением
+type identityUsage struct {
+	Identity  string
+	Streams   int     // Open streams. Открытые потоки
+	Tokens    float64 // Messages available now. Доступно сообщений сейчас
+	Messages  uint64  // Accepted messages. Принятые сообщения
+	Throttled uint64  // Rejected messages and streams. Отклоненные сообщения и потоки
+}
+
+type identityLimits struct {
+	limiter   *rate.Limiter
+	streams   int
+	messages  uint64
+	throttled uint64
+	lastSeen  time.Time
+}
+
+// Token buckets and stream counters of identities. Nil limiter allows all
+// Корзины токенов и счетчики потоков удостоверений. Пустой ограничитель разрешает все
+type callLimiter struct {
+	rate       rate.Limit // Messages per second, Inf - unlimited. Сообщений в секунду
+	burst      int
+	maxStreams int // 0 - unlimited. 0 - без ограничения
+	now        func() time.Time
+
+	mu  sync.Mutex
+	ids map[string]*identityLimits
+}
+
+// Limiter of configuration, nil if no limits are set. Ограничитель из настроек, nil без ограничений
+func newCallLimiter(cfg config) *callLimiter {
+	if cfg.RateLimit <= 0 && cfg.MaxStreams <= 0 {
+		return nil
+	}
+	l := &callLimiter{rate: rate.Inf, maxStreams: cfg.MaxStreams, now: time.Now, ids: make(map[string]*identityLimits)}
+	if cfg.RateLimit > 0 {
+		l.rate, l.burst = rate.Limit(cfg.RateLimit), cfg.RateBurst
+		if l.burst <= 0 {
+			// One second of messages by default. По умолчанию - сообщения за секунду
+			l.burst = int(math.Ceil(cfg.RateLimit))
+		}
+	}
+	return l
+}
+
+// Identity of call for limits. Удостоверение вызова для ограничений
+func callerIdentity(ctx context.Context) string {
+	if c, ok := claimsFromContext(ctx); ok && c.Subject != "" {
+		return "sub:" + c.Subject
+	}
+	if cert, err := peerCertificate(ctx); err == nil && cert.Subject.CommonName != "" {
+		return "cn:" + cert.Subject.CommonName
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		host, _, err := net.SplitHostPort(p.Addr.String())
+		if err != nil {
+			host = p.Addr.String()
+		}
+		return "addr:" + host
+	}
+	return "anonymous"
+}
+
+// State of identity, created on first call. Caller holds mu
+// Состояние удостоверения, создается при первом вызове. Вызывающий удерживает mu
+func (l *callLimiter) identity(id string) *identityLimits {
+	il, ok := l.ids[id]
+	if !ok {
+		il = &identityLimits{limiter: rate.NewLimiter(l.rate, l.burst)}
+		l.ids[id] = il
+	}
+	il.lastSeen = l.now()
+	return il
+}
+
+// Status with ErrorInfo and RetryInfo. Статус с ErrorInfo и RetryInfo
+func limitError(reason string, retry time.Duration, msg string) error {
+	st := status.New(codes.ResourceExhausted, msg)
+	ds, err := st.WithDetails(
+		&epb.ErrorInfo{Reason: reason, Domain: errorDomain},
+		&epb.RetryInfo{RetryDelay: durationpb.New(retry)},
+	)
+	if err != nil {
+		return st.Err()
+	}
+	return ds.Err()
+}
+
+// Takes token of one message, error tells when to retry. Забирает токен сообщения, ошибка сообщает время повтора
+func (l *callLimiter) allow(id string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	il := l.identity(id)
+	now := l.now()
+	r := il.limiter.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		il.throttled++
+		slog.Warn("rate limit exceeded", "identity", id, "retry", delay.String())
+		return limitError(reasonRateLimited, delay,
+			fmt.Sprintf("rate limit of %s exceeded: %g messages per second, burst %d", id, float64(l.rate), l.burst))
+	}
+	il.messages++
+	return nil
+}
+
+// Opens stream of identity, release closes it. Открывает поток удостоверения, release его закрывает
+func (l *callLimiter) openStream(id string) (release func(), err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	il := l.identity(id)
+	if l.maxStreams > 0 && il.streams >= l.maxStreams {
+		il.throttled++
+		slog.Warn("stream quota exceeded", "identity", id, "streams", il.streams)
+		return nil, limitError(reasonStreamsQuota, streamRetryDelay,
+			fmt.Sprintf("%s has %d open streams, limit %d", id, il.streams, l.maxStreams))
+	}
+	il.streams++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			il.streams--
+			il.lastSeen = l.now()
+			l.mu.Unlock()
+		})
+	}, nil
+}
+
+// Headers of current usage of identity. Заголовки текущего использования удостоверением
+func (l *callLimiter) headers(id string) metadata.MD {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	il := l.identity(id)
+	md := metadata.MD{}
+	if l.rate != rate.Inf {
+		md.Set(headerRateLimit, strconv.FormatFloat(float64(l.rate), 'g', -1, 64))
+		md.Set(headerRateRemaining, strconv.Itoa(int(il.limiter.TokensAt(l.now()))))
+	}
+	if l.maxStreams > 0 {
+		md.Set(headerStreamsLimit, strconv.Itoa(l.maxStreams))
+		md.Set(headerStreamsActive, strconv.Itoa(il.streams))
+	}
+	return md
+}
+
+// Current usage sorted by identity. Текущее использование по удостоверениям
+func (l *callLimiter) usage() []identityUsage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	u := make([]identityUsage, 0, len(l.ids))
+	for id, il := range l.ids {
+		tokens := math.Inf(1)
+		if l.rate != rate.Inf {
+			tokens = il.limiter.TokensAt(now)
+		}
+		u = append(u, identityUsage{Identity: id, Streams: il.streams, Tokens: tokens,
+			Messages: il.messages, Throttled: il.throttled})
+	}
+	sort.Slice(u, func(i, j int) bool { return u[i].Identity < u[j].Identity })
+	return u
+}
+
+// Forgets identities without streams idle longer than idle with full bucket, as new ones are the same
+// Забывает удостоверения без потоков, простаивающие дольше idle с полной корзиной, как новые
+func (l *callLimiter) prune(idle time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	for id, il := range l.ids {
+		full := l.rate == rate.Inf || il.limiter.TokensAt(now) >= float64(l.burst)
+		if il.streams == 0 && full && now.Sub(il.lastSeen) > idle {
+			delete(l.ids, id)
+		}
+	}
+}
+
+// Logs usage and prunes idle identities until ctx is done
+// Записывает использование в журнал и очищает простаивающие удостоверения, пока ctx не завершен
+func (l *callLimiter) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			l.report()
+			l.prune(interval)
+		}
+	}
+}
+
+func (l *callLimiter) report() {
+	for _, u := range l.usage() {
+		slog.Info("usage of limits", "identity", u.Identity, "streams", u.Streams,
+			"tokens", math.Round(u.Tokens*10)/10, "messages", u.Messages, "throttled", u.Throttled)
+	}
+}
+
+// Unary interceptor of limits, call counts as one message. Унарный перехватчик ограничений, вызов - одно сообщение
+func (l *callLimiter) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	if l == nil {
+		return handler(ctx, req)
+	}
+	id := callerIdentity(ctx)
+	if err := l.allow(id); err != nil {
+		return nil, err
+	}
+	grpc.SetHeader(ctx, l.headers(id))
+	return handler(ctx, req)
+}
+
+// Stream interceptor of limits: quota of open streams, each received message takes token
+// Потоковый перехватчик ограничений: квота открытых потоков, каждое принятое сообщение забирает токен
+func (l *callLimiter) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler) error {
+	if l == nil {
+		return handler(srv, ss)
+	}
+	id := callerIdentity(ss.Context())
+	release, err := l.openStream(id)
+	if err != nil {
+		return err
+	}
+	defer release()
+	ss.SetHeader(l.headers(id))
+	return handler(srv, &limitedStream{ServerStream: ss, limiter: l, id: id})
+}
+
+// Server stream taking token of each received message. Серверный поток, забирающий токен каждого сообщения
+type limitedStream struct {
+	grpc.ServerStream
+	limiter *callLimiter
+	id      string
+}
+
+func (s *limitedStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return s.limiter.allow(s.id)
+}
@@ -0,0 +1,167 @@
+// Тестирование ограничений клиентов. Testing of limits of clients
+
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	epb "google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Limiter with manual clock. Ограничитель с ручными часами
+func newTestLimiter(cfg config) (*callLimiter, *time.Time) {
+	l := newCallLimiter(cfg)
+	now := time.Date(2023, 2, 1, 12, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+// Retry delay of RetryInfo of status. Задержка повтора RetryInfo из статуса
+func retryDelay(err error) (time.Duration, bool) {
+	for _, d := range status.Convert(err).Details() {
+		if info, ok := d.(*epb.RetryInfo); ok {
+			return info.GetRetryDelay().AsDuration(), true
+		}
+	}
+	return 0, false
+}
+
+func TestCallLimiter_Rate(t *testing.T) {
+	l, now := newTestLimiter(config{RateLimit: 2})
+	for i := 0; i < 2; i++ {
+		if err := l.allow("cn:bs-client"); err != nil {
+			t.Fatalf("allow() #%d within burst = %v", i, err)
+		}
+	}
+	err := l.allow("cn:bs-client")
+	if status.Code(err) != codes.ResourceExhausted || errorReason(err) != reasonRateLimited {
+		t.Fatalf("allow() over burst = %v", err)
+	}
+	if d, ok := retryDelay(err); !ok || d != 500*time.Millisecond {
+		t.Errorf("RetryInfo delay = %v, %v, want 500ms", d, ok)
+	}
+	if err := l.allow("cn:other"); err != nil {
+		t.Errorf("allow() of other identity = %v", err)
+	}
+
+	*now = now.Add(500 * time.Millisecond)
+	if err := l.allow("cn:bs-client"); err != nil {
+		t.Errorf("allow() after retry delay = %v", err)
+	}
+	u := l.usage()
+	if len(u) != 2 || u[0].Identity != "cn:bs-client" || u[0].Messages != 3 || u[0].Throttled != 1 {
+		t.Errorf("usage() = %+v", u)
+	}
+}
+
+func TestCallLimiter_Streams(t *testing.T) {
+	l, now := newTestLimiter(config{MaxStreams: 1})
+	release, err := l.openStream("sub:bs-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = l.openStream("sub:bs-client")
+	if status.Code(err) != codes.ResourceExhausted || errorReason(err) != reasonStreamsQuota {
+		t.Fatalf("openStream() over quota = %v", err)
+	}
+	if _, ok := retryDelay(err); !ok {
+		t.Error("openStream() over quota has no RetryInfo")
+	}
+	if md := l.headers("sub:bs-client"); md.Get(headerStreamsActive)[0] != "1" || len(md.Get(headerRateLimit)) != 0 {
+		t.Errorf("headers() = %v", md)
+	}
+	release()
+	release() // Second release is ignored. Повторное закрытие не учитывается
+	if release, err = l.openStream("sub:bs-client"); err != nil {
+		t.Fatalf("openStream() after release = %v", err)
+	}
+
+	// Identity with open stream is kept. Удостоверение с открытым потоком сохраняется
+	*now = now.Add(time.Hour)
+	l.prune(time.Minute)
+	if len(l.usage()) != 1 {
+		t.Errorf("prune() removed identity with open stream")
+	}
+	release()
+	*now = now.Add(time.Hour)
+	l.prune(time.Minute)
+	if u := l.usage(); len(u) != 0 {
+		t.Errorf("usage() after prune = %+v", u)
+	}
+}
+
+// Stream reading messages and recording header. Поток, читающий сообщения и запоминающий заголовок
+type recvStream struct {
+	fakeServerStream
+	header metadata.MD
+}
+
+func (s *recvStream) SetHeader(md metadata.MD) error { s.header = md; return nil }
+func (s *recvStream) RecvMsg(m interface{}) error    { return nil }
+
+func TestCallLimiter_StreamInterceptor(t *testing.T) {
+	l, _ := newTestLimiter(config{RateLimit: 1, RateBurst: 3, MaxStreams: 2})
+	ctx := peerContext(&x509.Certificate{Subject: pkix.Name{CommonName: "bs-client"}})
+	var received int
+	var recvErr error
+	handler := func(_ interface{}, ss grpc.ServerStream) error {
+		for recvErr == nil {
+			if recvErr = ss.RecvMsg(nil); recvErr == nil {
+				received++
+			}
+		}
+		return recvErr
+	}
+	ss := &recvStream{fakeServerStream: fakeServerStream{ctx: ctx}}
+	err := l.streamInterceptor(nil, ss, &grpc.StreamServerInfo{FullMethod: methodProcess}, handler)
+	if status.Code(err) != codes.ResourceExhausted || received != 3 {
+		t.Errorf("streamInterceptor() = %v after %d messages, want ResourceExhausted after 3", err, received)
+	}
+	if ss.header.Get(headerRateLimit)[0] != "1" || ss.header.Get(headerRateRemaining)[0] != "3" ||
+		ss.header.Get(headerStreamsLimit)[0] != "2" {
+		t.Errorf("header = %v", ss.header)
+	}
+	if u := l.usage(); len(u) != 1 || u[0].Identity != "cn:bs-client" || u[0].Streams != 0 {
+		t.Errorf("usage() after stream = %+v", u)
+	}
+}
+
+func TestCallerIdentity(t *testing.T) {
+	addr := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 7), Port: 40000}
+	withClaims := context.WithValue(peerContext(&x509.Certificate{Subject: pkix.Name{CommonName: "bs-client"}}),
+		claimsKey{}, &tokenClaims{RegisteredClaims: jwt.RegisteredClaims{Subject: "svc-1"}})
+	for want, ctx := range map[string]context.Context{
+		"sub:svc-1":     withClaims,
+		"cn:bs-client":  peerContext(&x509.Certificate{Subject: pkix.Name{CommonName: "bs-client"}}),
+		"addr:10.0.0.7": peer.NewContext(context.Background(), &peer.Peer{Addr: addr}),
+		"anonymous":     context.Background(),
+	} {
+		if got := callerIdentity(ctx); got != want {
+			t.Errorf("callerIdentity() = %q, want %q", got, want)
+		}
+	}
+}
+
+// Without limits calls pass. Без ограничений вызовы проходят
+func TestCallLimiter_Nil(t *testing.T) {
+	l := newCallLimiter(defaultConfig())
+	if l != nil {
+		t.Fatalf("newCallLimiter(defaults) = %v, want nil", l)
+	}
+	_, err := l.unaryInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: methodAdd},
+		func(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil })
+	if err != nil {
+		t.Errorf("unaryInterceptor() of nil limiter = %v", err)
+	}
+}
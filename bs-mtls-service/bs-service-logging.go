@@ -0,0 +1,37 @@
+// Журнал потока: каждая запись несет ID потока, метод, адрес и CN сертификата клиента и ID трассы
+// Logger of stream: each record carries stream ID, method, peer address and CN of client certificate and trace ID
+
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/blablatov/bidistream-mtls-grpc/bs-logging"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type streamIDKey struct{}
+
+// Returns context with new stream ID and logger of stream. Возвращает контекст с новым ID потока и журналом потока
+func streamLogger(ctx context.Context, method string) (context.Context, *slog.Logger) {
+	id := newStreamID()
+	args := []interface{}{"stream", id, "method", method, "peer", peerAddr(ctx)}
+	if cert, err := peerCertificate(ctx); err == nil {
+		args = append(args, "cn", cert.Subject.CommonName)
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		args = append(args, "trace", sc.TraceID().String())
+	}
+	l := logging.FromContext(ctx).With(args...)
+	return logging.NewContext(context.WithValue(ctx, streamIDKey{}, id), l), l
+}
+
+// ID of stream in context, new one for stream without interceptor. Same ID ties log and audit records
+// ID потока из контекста, новый для потока без перехватчика. Один ID связывает записи журнала и аудита
+func streamID(ctx context.Context) string {
+	if id, ok := ctx.Value(streamIDKey{}).(string); ok {
+		return id
+	}
+	return newStreamID()
+}
@@ -0,0 +1,105 @@
+// Тестирование журнала потоков. Testing of logger of streams
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/blablatov/bidistream-mtls-grpc/bs-audit/audit"
+	"github.com/blablatov/bidistream-mtls-grpc/bs-logging"
+)
+
+// Sets default logger of level writing JSON to buffer. Задает журнал по умолчанию уровня, пишущий JSON в буфер
+func captureLog(t *testing.T, level string) *syncBuffer {
+	t.Helper()
+	var buf syncBuffer
+	l, err := logging.New(&buf, level, logging.FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := slog.Default()
+	slog.SetDefault(l)
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	return &buf
+}
+
+// Log records by message. Записи журнала по сообщению
+func logRecords(t *testing.T, b *syncBuffer) map[string][]map[string]interface{} {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	recs := map[string][]map[string]interface{}{}
+	sc := bufio.NewScanner(bytes.NewReader(b.buf.Bytes()))
+	for sc.Scan() {
+		var rec map[string]interface{}
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatal(err)
+		}
+		msg, _ := rec["msg"].(string)
+		recs[msg] = append(recs[msg], rec)
+	}
+	return recs
+}
+
+// Stream is logged as closed after client sees its end. Закрытие потока пишется после его завершения у клиента
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatal("condition is not met")
+}
+
+func TestStreamLogger(t *testing.T) {
+	logs := captureLog(t, "debug")
+	var auditBuf syncBuffer
+	client := newMetricsClient(t, nil, withAudit(audit.New(&auditBuf)))
+	if err := processIDs(t, client, "102"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return len(logRecords(t, logs)["stream closed"]) == 1 })
+
+	recs := logRecords(t, logs)
+	open := recs["stream opened"]
+	if len(open) != 1 || open[0]["method"] != methodProcess || open[0]["peer"] == nil {
+		t.Fatalf("stream opened records %v", open)
+	}
+	id := open[0]["stream"]
+	for _, msg := range []string{"message received", "message sent", "order received", "shipping", "stream closed"} {
+		if len(recs[msg]) == 0 {
+			t.Errorf("no %q record", msg)
+		}
+		for _, rec := range recs[msg] {
+			if rec["stream"] != id || rec["method"] != methodProcess {
+				t.Errorf("%q record %v is not of stream %v", msg, rec, id)
+			}
+		}
+	}
+	// Audit and log share ID of stream. Аудит и журнал используют один ID потока
+	if a := auditBuf.records(t); len(a) == 0 || a[0].Stream != id {
+		t.Errorf("audit records %v, want stream %v", a, id)
+	}
+}
+
+// Messages of streams are not logged at info. Сообщения потоков не пишутся на уровне info
+func TestStreamLogger_Info(t *testing.T) {
+	logs := captureLog(t, "info")
+	client := newMetricsClient(t, nil)
+	if err := processIDs(t, client, "102", "104"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return len(logRecords(t, logs)["stream closed"]) == 1 })
+	recs := logRecords(t, logs)
+	for _, msg := range []string{"message received", "message sent", "order received", "shipping"} {
+		if len(recs[msg]) > 0 {
+			t.Errorf("%q is logged at info", msg)
+		}
+	}
+}
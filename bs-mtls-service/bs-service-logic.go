@@ -6,129 +6,353 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
 
 	"google.golang.org/grpc/codes"
 
+	"github.com/blablatov/bidistream-mtls-grpc/bs-audit/audit"
+	"github.com/blablatov/bidistream-mtls-grpc/bs-logging"
 	pb "github.com/blablatov/bidistream-mtls-grpc/bs-mtls-proto"
+	"github.com/blablatov/bidistream-mtls-grpc/bs-tracing"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"go.opentelemetry.io/otel/trace"
 	epb "google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
-var orderMap = make(map[string]pb.Order)
+// Description of unknown order ID. Описание неизвестного ID заказа
+const msgOrderNotFound = "Order ID received is not found"
 
 // mСервер реализует order_management
 type mserver struct {
-	orderMap map[string]*pb.Order
+	store      OrderStore       // Repository of orders. Репозиторий заказов
+	policy     batchPolicy      // Batching of ProcessOrders. Политика объединения заказов в партии
+	lenient    bool             // Rejected IDs don't end stream. Отклоненные ID не завершают поток
+	validators []OrderValidator // Checks of orders. Проверки заказов
+	audit      *audit.Logger    // Audit trail of streams, nil - off. Журнал аудита потоков, nil - выключен
+	streams    *streamRegistry  // Active streams of admin port, nil - off. Активные потоки служебного порта
+
+	draining  chan struct{} // Closed at shutdown. Закрывается при остановке сервера
+	drainOnce sync.Once
+}
+
+// Option of server. Параметр сервера
+type serverOption func(*mserver)
+
+// Sets default batching policy of streams. Задает политику объединения заказов для потоков
+func withBatchPolicy(p batchPolicy) serverOption {
+	return func(s *mserver) { s.policy = p }
+}
+
+// Selects lenient mode: rejected IDs are reported in stream instead of ending it
+// Задает мягкий режим: отклоненные ID сообщаются в потоке вместо его завершения
+func withLenient(lenient bool) serverOption {
+	return func(s *mserver) { s.lenient = lenient }
+}
+
+// Replaces validation chain of orders. Заменяет цепочку проверок заказов
+func withValidators(validators ...OrderValidator) serverOption {
+	return func(s *mserver) { s.validators = validators }
+}
+
+// Creates server on top of store. Создает сервер поверх хранилища заказов
+func newServer(store OrderStore, opts ...serverOption) *mserver {
+	s := &mserver{
+		store:      store,
+		policy:     defaultBatchPolicy,
+		validators: defaultValidators,
+		draining:   make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Builds status with details of invalid field. Создает статус ошибки с описанием недопустимого поля
+func fieldError(code codes.Code, msg, field, desc string) error {
+	return violationsError(code, msg, []*epb.BadRequest_FieldViolation{{Field: field, Description: desc}})
+}
+
+// Builds status with all violations in one BadRequest. Создает статус ошибки со всеми нарушениями
+func violationsError(code codes.Code, msg string, violations []*epb.BadRequest_FieldViolation) error {
+	errorStatus := status.New(code, msg)
+	ds, err := errorStatus.WithDetails(&epb.BadRequest{FieldViolations: violations})
+	if err != nil {
+		return errorStatus.Err()
+	}
+	return ds.Err()
+}
+
+// Unary RPC, adds new order. Унарный RPC, добавляет новый заказ
+func (s *mserver) AddOrder(ctx context.Context, orderReq *pb.Order) (*wrappers.StringValue, error) {
+	if orderReq.GetId() == "" {
+		return nil, fieldError(codes.InvalidArgument, "Order ID is empty - Invalid information",
+			"ID", "Order ID is required")
+	}
+	if violations := validateOrder(orderReq, s.validators); len(violations) > 0 {
+		return nil, violationsError(codes.InvalidArgument, "Order is not valid - Invalid information", violations)
+	}
+	switch err := s.store.Create(orderReq); err {
+	case nil:
+	case errOrderExists:
+		return nil, fieldError(codes.AlreadyExists, "Order ID already exists",
+			"ID", fmt.Sprintf("Order ID already exists : %s", orderReq.GetId()))
+	default:
+		return nil, status.Errorf(codes.Internal, "failed to store order %s: %v", orderReq.GetId(), err)
+	}
+	slog.Info("order added", "id", orderReq.GetId(), subjectAttr(ctx))
+	return &wrappers.StringValue{Value: orderReq.GetId()}, nil
+}
+
+// Unary RPC, returns order by ID. Унарный RPC, возвращает заказ по ID
+func (s *mserver) GetOrder(ctx context.Context, orderId *wrappers.StringValue) (*pb.Order, error) {
+	ord, ok := s.store.Get(orderId.GetValue())
+	if !ok {
+		return nil, fieldError(codes.NotFound, "Order ID received is not found - Invalid information",
+			"ID", fmt.Sprintf("Order ID received is not found : %s", orderId.GetValue()))
+	}
+	return ord, nil
+}
+
+// Unary RPC, replaces existing order. Унарный RPC, заменяет существующий заказ
+func (s *mserver) UpdateOrder(ctx context.Context, orderReq *pb.Order) (*wrappers.StringValue, error) {
+	if _, ok := s.store.Get(orderReq.GetId()); !ok {
+		return nil, fieldError(codes.NotFound, "Order ID received is not found - Invalid information",
+			"ID", fmt.Sprintf("Order ID received is not found : %s", orderReq.GetId()))
+	}
+	if violations := validateOrder(orderReq, s.validators); len(violations) > 0 {
+		return nil, violationsError(codes.InvalidArgument, "Order is not valid - Invalid information", violations)
+	}
+	// Order may be deleted since check. Заказ мог быть удален после проверки
+	switch err := s.store.Update(orderReq); err {
+	case nil:
+	case errOrderNotFound:
+		return nil, fieldError(codes.NotFound, "Order ID received is not found - Invalid information",
+			"ID", fmt.Sprintf("Order ID received is not found : %s", orderReq.GetId()))
+	default:
+		return nil, status.Errorf(codes.Internal, "failed to store order %s: %v", orderReq.GetId(), err)
+	}
+	slog.Info("order updated", "id", orderReq.GetId(), subjectAttr(ctx))
+	return &wrappers.StringValue{Value: orderReq.GetId()}, nil
+}
+
+// Unary RPC, deletes order by ID. Унарный RPC, удаляет заказ по ID
+func (s *mserver) DeleteOrder(ctx context.Context, orderId *wrappers.StringValue) (*wrappers.StringValue, error) {
+	switch err := s.store.Delete(orderId.GetValue()); err {
+	case nil:
+	case errOrderNotFound:
+		return nil, fieldError(codes.NotFound, "Order ID received is not found - Invalid information",
+			"ID", fmt.Sprintf("Order ID received is not found : %s", orderId.GetValue()))
+	default:
+		return nil, status.Errorf(codes.Internal, "failed to delete order %s: %v", orderId.GetValue(), err)
+	}
+	slog.Info("order deleted", "id", orderId.GetValue(), subjectAttr(ctx))
+	return orderId, nil
+}
+
+// Server-side Streaming RPC, finds orders by item. Потоковый RPC на стороне сервера, ищет заказы по товару
+func (s *mserver) SearchOrders(searchQuery *wrappers.StringValue, stream pb.OrderManagement_SearchOrdersServer) error {
+	query := strings.ToLower(searchQuery.GetValue())
+	if query == "" {
+		return fieldError(codes.InvalidArgument, "Search query is empty - Invalid information",
+			"value", "Search query is required")
+	}
+	for _, ord := range s.store.List() {
+		for _, item := range ord.GetItems() {
+			if strings.Contains(strings.ToLower(item), query) {
+				// Sends matched order. Отправляем найденный заказ в поток
+				if err := stream.Send(ord); err != nil {
+					return err
+				}
+				logging.FromContext(stream.Context()).Debug("matching order found", "id", ord.GetId())
+				break
+			}
+		}
+	}
+	return nil
 }
 
 // Bi-directional Streaming RPC
 // Двунаправленный потоковый RPC
-func (s *mserver) ProcessOrders(stream pb.OrderManagement_ProcessOrdersServer) error {
+func (s *mserver) ProcessOrders(stream pb.OrderManagement_ProcessOrdersServer) (err error) {
+	a := s.auditStream(stream)
+	defer func() { a.close(err) }()
+	l := logging.FromContext(stream.Context())
 
-	batchMarker := 1
-	var combinedShipmentMap = make(map[string]pb.CombinedShipment)
-	for {
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	policy, err := s.policy.withMetadata(md)
+	if err != nil {
+		return err
+	}
+	batcher := newShipmentBatcher(policy)
+	st := s.streams.open(stream.Context())
+	defer st.close()
+	received := 0
 
-		switch {
-		case stream.Context().Err() == context.Canceled:
-			log.Printf("Context Cacelled for this stream: -> %s", stream.Context().Err())
-			log.Printf("Stopped processing any more order of this stream!")
-			return stream.Context().Err()
+	// Reads IDs in goroutine, so that timer of batches can fire while client is idle
+	// Читаем ID в горутине, чтобы таймер партий срабатывал, пока клиент молчит
+	recvc := make(chan recvResult)
+	go func() {
+		for {
+			orderId, err := stream.Recv()
+			select {
+			case recvc <- recvResult{orderId, err}:
+			case <-stream.Context().Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
 
-		case stream.Context().Err() == context.DeadlineExceeded:
-			log.Printf("Deadline was exceeded for this stream: -> %s", stream.Context().Err())
-			log.Printf("Stopped processing any more order of this stream!")
-			return stream.Context().Err()
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
 
-		default:
-			// Err of ID. Проверка ID
-			orderId, err := stream.Recv() // Reads IDs. Читаем ID заказов из входящего потока
-			log.Printf("Reading Proc order : %s", orderId)
+	for {
+		st.update(received, batcher)
+		if deadline, ok := batcher.nextDeadline(); ok {
+			timer.Reset(time.Until(deadline))
+		}
 
-			if orderId == nil {
+		select {
+		case <-stream.Context().Done():
+			// Cancelled by client or deadline exceeded. Отменен клиентом или истек срок
+			l.Info("stopped processing orders of stream", "err", stream.Context().Err())
+			return stream.Context().Err()
+
+		case <-s.draining:
+			// Server is shutting down: pending shipments are sent, then stream ends
+			// Сервер останавливается: отправляем ожидающие партии и завершаем поток
+			l.Info("draining stream, server is shutting down")
+			if err := sendShipments(stream, a, batcher.flushAll(pb.FlushTrigger_FLUSH_TRIGGER_SHUTDOWN)); err != nil {
 				return err
 			}
+			return errShuttingDown
 
-			if k, ok := orderMap[orderId.Value]; !ok {
-				log.Printf("Order ID is invalid! -> Received Order ID %v", k)
-				errorStatus := status.New(codes.InvalidArgument, "Order ID received is not found - Invalid information")
-				ds, err := errorStatus.WithDetails(
-					&epb.BadRequest_FieldViolation{
-						Field:       "ID",
-						Description: fmt.Sprintf("Order ID received is not found %s : %s", orderId, orderId.Value),
-					},
-				)
-				if err == nil {
-					return errorStatus.Err()
-				}
-				return ds.Err()
+		case <-st.flushRequests():
+			// Force-flush at admin port. Принудительная отправка через служебный порт
+			l.Info("flushing stream by administrator")
+			if err := sendShipments(stream, a, batcher.flushAll(pb.FlushTrigger_FLUSH_TRIGGER_ADMIN)); err != nil {
+				return err
 			}
 
-			if orderId.String() == `value:"-1"` {
-				log.Printf("Order ID is invalid! -> Received Order ID %s", orderId)
+		case <-st.cancelled():
+			// Cancel at admin port, pending orders are dropped. Отмена через служебный порт, ожидающие заказы сбрасываются
+			l.Warn("stream cancelled by administrator")
+			return errCancelledByAdmin
 
-				errorStatus := status.New(codes.InvalidArgument, "Order ID received is not valid  - Invalid information")
-				ds, err := errorStatus.WithDetails(
-					&epb.BadRequest_FieldViolation{
-						Field:       "ID",
-						Description: fmt.Sprintf("Order ID received is not valid %s : %s", orderId, orderId.Value),
-					},
-				)
-				if err == nil {
-					return errorStatus.Err()
-				}
-				return ds.Err()
+		case <-timer.C:
+			// Batches waiting too long. Партии, ожидающие слишком долго
+			if err := sendShipments(stream, a, batcher.expired(time.Now())); err != nil {
+				return err
 			}
 
+		case r := <-recvc:
+			orderId, err := r.orderId, r.err
+
 			// Checks to Err EOF
 			if err == io.EOF { // Reads IDs to EOF. Продолжаем читать, пока не обнаружим конец потока
 				// Client has sent all the messages. Send remaining shipments
-				log.Printf("EOF : %s", orderId)
-				for _, shipment := range combinedShipmentMap {
-					// If EOF sends all data of groups
-					// При обнаружении конца потока отправляем клиенту все сгруппированные оставшиеся данные
-					if err := stream.Send(&shipment); err != nil {
-						return err
-					}
+				// При обнаружении конца потока отправляем клиенту все сгруппированные оставшиеся данные
+				l.Debug("end of stream of client")
+				if err := sendShipments(stream, a, batcher.flushAll(pb.FlushTrigger_FLUSH_TRIGGER_END_OF_STREAM)); err != nil {
+					return err
 				}
 				return nil //Closes stream. Сервер завершает поток, возвращая nil
 			}
 			if err != nil {
-				log.Println(err)
 				return err
 			}
+			received++
+			l.Debug("order received", "id", orderId.GetValue())
 
-			// Logic makes group of orders. Логика для объединения заказов в партии на основе адреса доставки
-			destination := orderMap[orderId.GetValue()].Destination
-			shipment, found := combinedShipmentMap[destination]
-
-			if found {
-				ord := orderMap[orderId.GetValue()]
-				shipment.OrdersList = append(shipment.OrdersList, &ord)
-				combinedShipmentMap[destination] = shipment
-			} else {
-				comShip := pb.CombinedShipment{Id: "cmb - " + (orderMap[orderId.GetValue()].Destination), Status: "Processed!"}
-				ord := orderMap[orderId.GetValue()]
-				comShip.OrdersList = append(shipment.OrdersList, &ord)
-				combinedShipmentMap[destination] = comShip
-				log.Print(len(comShip.OrdersList), comShip.GetId())
+			// Err of ID. Проверка ID
+			ord, ok := s.store.Get(orderId.GetValue())
+			if !ok {
+				l.Info("order ID is not found", "id", orderId.GetValue())
+				if err := s.rejectOrder(stream, a, batcher, orderId.GetValue(), pb.RejectReason_REJECT_REASON_NOT_FOUND,
+					"Order ID received is not found - Invalid information",
+					&epb.BadRequest_FieldViolation{
+						Field:       "ID",
+						Description: fmt.Sprintf("%s: %s", msgOrderNotFound, orderId.GetValue()),
+					},
+				); err != nil {
+					return err
+				}
+				continue
 			}
 
-			if batchMarker == orderBatchSize {
-				// Передаем клиенту поток заказов, объединенных в партии, group orderBatchSize
-				for _, comb := range combinedShipmentMap {
-					// Group of orders. Передаем клиенту партию объединенных заказов
-					log.Printf("Shipping : %v -> %v", comb.Id, len(comb.OrdersList))
-					if err := stream.Send(&comb); err != nil { // Writes group of orders. Запись объединенных заказов в поток
-						return err
-					}
+			if violations := validateOrder(ord, s.validators); len(violations) > 0 {
+				l.Info("order is invalid", "id", orderId.GetValue(), "violations", len(violations))
+				if err := s.rejectOrder(stream, a, batcher, orderId.GetValue(), pb.RejectReason_REJECT_REASON_INVALID,
+					"Order ID received is not valid  - Invalid information", violations...); err != nil {
+					return err
 				}
-				batchMarker = 0
-				combinedShipmentMap = make(map[string]pb.CombinedShipment)
-			} else {
-				batchMarker++
+				continue
 			}
+
+			// Logic makes group of orders. Логика для объединения заказов в партии на основе адреса доставки
+			a.order(orderId.GetValue(), audit.Accepted, "")
+			if comb := batcher.add(ord, time.Now()); comb != nil {
+				if err := sendShipments(stream, a, []*pb.CombinedShipment{comb}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// Rejects order ID. In strict mode returns error ending stream with rejected ID in details, in lenient mode
+// sends rejected ID to client and returns nil, so that stream continues
+// Отклоняет ID заказа. В строгом режиме возвращает ошибку, завершающую поток, с отклоненным ID в деталях,
+// в мягком режиме отправляет клиенту отклоненный ID и поток продолжается
+func (s *mserver) rejectOrder(stream pb.OrderManagement_ProcessOrdersServer, a *streamAudit, batcher *shipmentBatcher,
+	id string, reason pb.RejectReason, msg string, violations ...*epb.BadRequest_FieldViolation) error {
+	a.order(id, audit.Rejected, violationsReason(violations))
+	rejected := &pb.RejectedOrder{Id: id, Violations: violations, Reason: reason}
+	if !s.lenient {
+		errorStatus := status.New(codes.InvalidArgument, msg)
+		ds, err := errorStatus.WithDetails(&epb.BadRequest{FieldViolations: violations}, rejected)
+		if err != nil {
+			return errorStatus.Err()
+		}
+		return ds.Err()
+	}
+	return sendShipments(stream, a, []*pb.CombinedShipment{batcher.stamp(&pb.CombinedShipment{
+		Id:       "rej - " + id,
+		Status:   "Rejected!",
+		Rejected: rejected,
+	})})
+}
+
+// Result of stream.Recv. Результат чтения входящего потока
+type recvResult struct {
+	orderId *wrappers.StringValue
+	err     error
+}
+
+// Передаем клиенту поток заказов, объединенных в партии. Sends group of orders
+// Отправленные партии записываются в журнал аудита. Sent shipments are audited
+// Выпуск партии отмечается событием flush спана потока. Flush of shipment is event of span of stream
+func sendShipments(stream pb.OrderManagement_ProcessOrdersServer, a *streamAudit, shipments []*pb.CombinedShipment) error {
+	span := trace.SpanFromContext(stream.Context())
+	for _, comb := range shipments {
+		// Group of orders. Передаем клиенту партию объединенных заказов
+		logging.FromContext(stream.Context()).Debug("shipping", "shipment", comb.Id, "orders", len(comb.OrdersList), "trigger", comb.Trigger)
+		if comb.GetRejected() == nil {
+			messageEvent(span, tracing.EventFlush, comb)
+		}
+		if err := stream.Send(comb); err != nil { // Writes group of orders. Запись объединенных заказов в поток
+			return err
 		}
+		a.shipment(comb)
 	}
+	return nil
 }
@@ -0,0 +1,196 @@
+// Тестирование унарных и потоковых методов работы с заказами. Testing of order CRUD methods
+
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	pb "github.com/blablatov/bidistream-mtls-grpc/bs-mtls-proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	epb "google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// Starts server with own store and returns client. Запускает сервер с хранилищем и возвращает клиента
+func newBufConnClient(t *testing.T, store OrderStore, opts ...serverOption) pb.OrderManagementClient {
+	t.Helper()
+	lis := bufconn.Listen(bufSize)
+	s := grpc.NewServer()
+	pb.RegisterOrderManagementServer(s, newServer(store, opts...))
+	go func() {
+		if err := s.Serve(lis); err != nil {
+			log.Printf("failed to serve: %v", err)
+		}
+	}()
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(getBufDialer(lis)), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("did not connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewOrderManagementClient(conn)
+}
+
+// Checks status code and presence of field violation. Проверяет код статуса и описание нарушения
+func checkFieldError(t *testing.T, err error, code codes.Code) {
+	t.Helper()
+	st := status.Convert(err)
+	if st.Code() != code {
+		t.Fatalf("code = %v, want %v (err %v)", st.Code(), code, err)
+	}
+	for _, d := range st.Details() {
+		if br, ok := d.(*epb.BadRequest); ok && len(br.GetFieldViolations()) > 0 {
+			return
+		}
+	}
+	t.Errorf("details of %v have no BadRequest field violations", err)
+}
+
+func TestServer_OrderCRUD(t *testing.T) {
+	client := newBufConnClient(t, newMemoryStore(sampleOrders()...))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	order := &pb.Order{Id: "201", Items: []string{"Sensor_03"}, Destination: "Moscow", Price: 20.00}
+	if res, err := client.AddOrder(ctx, order); err != nil || res.GetValue() != "201" {
+		t.Fatalf("AddOrder() = %v, %v", res, err)
+	}
+	_, err := client.AddOrder(ctx, order)
+	checkFieldError(t, err, codes.AlreadyExists)
+	_, err = client.AddOrder(ctx, &pb.Order{})
+	checkFieldError(t, err, codes.InvalidArgument)
+
+	got, err := client.GetOrder(ctx, &wrappers.StringValue{Value: "201"})
+	if err != nil || got.GetDestination() != "Moscow" {
+		t.Fatalf("GetOrder(201) = %v, %v", got, err)
+	}
+
+	order.Destination = "Moscow, ru-central1-a"
+	if _, err := client.UpdateOrder(ctx, order); err != nil {
+		t.Fatalf("UpdateOrder() = %v", err)
+	}
+	got, _ = client.GetOrder(ctx, &wrappers.StringValue{Value: "201"})
+	if got.GetDestination() != "Moscow, ru-central1-a" {
+		t.Errorf("Destination after update = %q", got.GetDestination())
+	}
+	_, err = client.UpdateOrder(ctx, &pb.Order{Id: "404"})
+	checkFieldError(t, err, codes.NotFound)
+
+	if _, err := client.DeleteOrder(ctx, &wrappers.StringValue{Value: "201"}); err != nil {
+		t.Fatalf("DeleteOrder(201) = %v", err)
+	}
+	_, err = client.GetOrder(ctx, &wrappers.StringValue{Value: "201"})
+	checkFieldError(t, err, codes.NotFound)
+	_, err = client.DeleteOrder(ctx, &wrappers.StringValue{Value: "201"})
+	checkFieldError(t, err, codes.NotFound)
+}
+
+func TestServer_SearchOrders(t *testing.T) {
+	client := newBufConnClient(t, newMemoryStore(sampleOrders()...))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	searchStream, err := client.SearchOrders(ctx, &wrappers.StringValue{Value: "google"})
+	if err != nil {
+		t.Fatalf("SearchOrders() = %v", err)
+	}
+	var ids []string
+	for {
+		order, err := searchStream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Recv() = %v", err)
+		}
+		ids = append(ids, order.GetId())
+	}
+	if len(ids) != 2 || ids[0] != "102" || ids[1] != "104" {
+		t.Errorf("SearchOrders(google) = %v, want [102 104]", ids)
+	}
+}
+
+// Strict mode ends stream on unknown ID. Строгий режим завершает поток на неизвестном ID
+func TestServer_ProcessOrdersStrict(t *testing.T) {
+	client := newBufConnClient(t, newMemoryStore(sampleOrders()...))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	streamProcOrder, err := client.ProcessOrders(ctx)
+	if err != nil {
+		t.Fatalf("ProcessOrders(_) = _, %v", err)
+	}
+	if err := streamProcOrder.Send(&wrappers.StringValue{Value: "999"}); err != nil {
+		t.Fatalf("Send(999) = %v", err)
+	}
+	_, err = streamProcOrder.Recv()
+	checkFieldError(t, err, codes.InvalidArgument)
+}
+
+// Lenient mode reports rejected IDs and keeps stream open
+// Мягкий режим сообщает об отклоненных ID и не завершает поток
+func TestServer_ProcessOrdersLenient(t *testing.T) {
+	client := newBufConnClient(t, newMemoryStore(sampleOrders()...),
+		withBatchPolicy(batchPolicy{}), withLenient(true))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	streamProcOrder, err := client.ProcessOrders(ctx)
+	if err != nil {
+		t.Fatalf("ProcessOrders(_) = _, %v", err)
+	}
+	for _, id := range []string{"102", "999", "-1", "104"} {
+		if err := streamProcOrder.Send(&wrappers.StringValue{Value: id}); err != nil {
+			t.Fatalf("Send(%s) = %v", id, err)
+		}
+	}
+	if err := streamProcOrder.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+
+	var rejected []string
+	var shipped int
+	for {
+		comb, err := streamProcOrder.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Recv() = %v", err)
+		}
+		if r := comb.GetRejected(); r != nil {
+			if len(r.GetViolations()) == 0 {
+				t.Errorf("rejected %s has no violations", r.GetId())
+			}
+			// Description of unknown ID is stable text. Описание неизвестного ID - стабильный текст
+			if want := msgOrderNotFound + ": 999"; r.GetId() == "999" && r.GetViolations()[0].GetDescription() != want {
+				t.Errorf("violation of 999 = %q, want %q", r.GetViolations()[0].GetDescription(), want)
+			}
+			want := pb.RejectReason_REJECT_REASON_INVALID
+			if r.GetId() == "999" {
+				want = pb.RejectReason_REJECT_REASON_NOT_FOUND
+			}
+			if r.GetReason() != want {
+				t.Errorf("reason of rejected %s = %v, want %v", r.GetId(), r.GetReason(), want)
+			}
+			rejected = append(rejected, r.GetId())
+			continue
+		}
+		shipped += len(comb.GetOrdersList())
+	}
+	if len(rejected) != 2 || rejected[0] != "999" || rejected[1] != "-1" {
+		t.Errorf("rejected = %v, want [999 -1]", rejected)
+	}
+	if shipped != 2 {
+		t.Errorf("shipped %d orders, want 2", shipped)
+	}
+}
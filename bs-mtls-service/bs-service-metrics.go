@@ -0,0 +1,211 @@
+// Метрики Prometheus потоков, заказов и партий на отдельном HTTP-порту /metrics
+// Prometheus metrics of streams, orders and shipments on separate HTTP port /metrics
+// Собираются потоковым перехватчиком по принятым и отправленным сообщениям
+// Collected by stream interceptor from received and sent messages
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/blablatov/bidistream-mtls-grpc/bs-mtls-proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc/status"
+)
+
+// Outcomes of received order IDs. Исходы полученных ID заказов
+const (
+	outcomeAccepted = "accepted"
+	outcomeUnknown  = "unknown" // Not found in store. Не найден в хранилище
+	outcomeInvalid  = "invalid" // Failed validation. Не прошел проверку
+)
+
+// Metrics of service in own registry. Метрики сервиса в собственном реестре
+type serverMetrics struct {
+	registry *prometheus.Registry
+
+	orders            *prometheus.CounterVec // By outcome. По исходу
+	shipments         prometheus.Counter
+	triggers          *prometheus.CounterVec // By flush trigger. По причине отправки
+	ordersPerShipment prometheus.Histogram
+	streamDuration    *prometheus.HistogramVec // By method. По методу
+	activeStreams     *prometheus.GaugeVec     // By method. По методу
+	pendingOrders     prometheus.Gauge
+}
+
+func newServerMetrics() *serverMetrics {
+	m := &serverMetrics{
+		registry: prometheus.NewRegistry(),
+		orders: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "bs_orders_received_total",
+			Help: "Order IDs received by ProcessOrders by outcome: accepted (counted when shipped), unknown or invalid.",
+		}, []string{"outcome"}),
+		shipments: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "bs_shipments_total",
+			Help: "Combined shipments emitted, rejected IDs excluded.",
+		}),
+		triggers: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "bs_shipment_flushes_total",
+			Help: "Emitted shipments by flush trigger.",
+		}, []string{"trigger"}),
+		ordersPerShipment: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "bs_shipment_orders",
+			Help:    "Orders per emitted shipment.",
+			Buckets: []float64{1, 2, 5, 10, 20, 50, 100},
+		}),
+		streamDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "bs_stream_duration_seconds",
+			Help:    "Duration of streams.",
+			Buckets: prometheus.ExponentialBuckets(0.01, 4, 8),
+		}, []string{"method"}),
+		activeStreams: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "bs_streams_active",
+			Help: "Open streams.",
+		}, []string{"method"}),
+		pendingOrders: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "bs_pending_orders",
+			Help: "Received order IDs not yet shipped or rejected.",
+		}),
+	}
+	m.registry.MustRegister(m.orders, m.shipments, m.triggers, m.ordersPerShipment,
+		m.streamDuration, m.activeStreams, m.pendingOrders,
+		collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	for _, outcome := range []string{outcomeAccepted, outcomeUnknown, outcomeInvalid} {
+		m.orders.WithLabelValues(outcome)
+	}
+	return m
+}
+
+// Handler of /metrics. Обработчик /metrics
+func (m *serverMetrics) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	return mux
+}
+
+// Serves metrics on addr until ctx is done. Listen errors are returned at once
+// Отдает метрики на addr, пока ctx не завершен. Ошибка прослушивания возвращается сразу
+func (m *serverMetrics) serve(ctx context.Context, addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: m.handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	go func() {
+		if err := srv.Serve(lis); !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("metrics server failed", "err", err)
+		}
+	}()
+	slog.Info("serving metrics", "url", "http://"+lis.Addr().String()+"/metrics")
+	return nil
+}
+
+// Metrics of one stream. Nil stats of nil metrics do nothing
+// Метрики одного потока. Пустая статистика пустых метрик ничего не делает
+type streamStats struct {
+	m      *serverMetrics
+	method string
+	start  time.Time
+
+	mu      sync.Mutex
+	pending int  // Received, not yet shipped or rejected. Получены, но не отправлены и не отклонены
+	closed  bool // Late receives of reader after close are ignored. Поздние чтения после закрытия не учитываются
+}
+
+// Opens stream of method. Открывает поток метода
+func (m *serverMetrics) openStream(method string) *streamStats {
+	if m == nil {
+		return nil
+	}
+	m.activeStreams.WithLabelValues(method).Inc()
+	return &streamStats{m: m, method: method, start: time.Now()}
+}
+
+// Counts received order ID. Учитывает полученный ID заказа
+func (s *streamStats) received(msg interface{}) {
+	if _, ok := msg.(*wrappers.StringValue); s == nil || !ok {
+		return
+	}
+	s.addPending(1)
+}
+
+// Counts sent shipment or rejected ID, outcome of rejected ID is its reason
+// Учитывает отправленную партию или отклоненный ID, исход отклоненного ID - его причина
+func (s *streamStats) sent(msg interface{}) {
+	comb, ok := msg.(*pb.CombinedShipment)
+	if s == nil || !ok {
+		return
+	}
+	if r := comb.GetRejected(); r != nil {
+		s.rejected(r)
+		return
+	}
+	n := len(comb.GetOrdersList())
+	s.m.orders.WithLabelValues(outcomeAccepted).Add(float64(n))
+	s.m.shipments.Inc()
+	s.m.triggers.WithLabelValues(triggerLabel(comb.GetTrigger())).Inc()
+	s.m.ordersPerShipment.Observe(float64(n))
+	s.addPending(-n)
+}
+
+func (s *streamStats) rejected(r *pb.RejectedOrder) {
+	s.m.orders.WithLabelValues(rejectOutcome(r.GetReason())).Inc()
+	s.addPending(-1)
+}
+
+func (s *streamStats) addPending(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.pending += n
+	s.m.pendingOrders.Add(float64(n))
+}
+
+// Closes stream, rejected ID in details of error ends stream in strict mode, pending IDs are dropped
+// Закрывает поток, отклоненный ID в деталях ошибки завершает поток в строгом режиме, ожидающие ID отбрасываются
+func (s *streamStats) close(err error) {
+	if s == nil {
+		return
+	}
+	for _, d := range status.Convert(err).Details() {
+		if r, ok := d.(*pb.RejectedOrder); ok {
+			s.rejected(r)
+		}
+	}
+	s.mu.Lock()
+	dropped := s.pending
+	s.pending, s.closed = 0, true
+	s.mu.Unlock()
+	s.m.pendingOrders.Sub(float64(dropped))
+	s.m.activeStreams.WithLabelValues(s.method).Dec()
+	s.m.streamDuration.WithLabelValues(s.method).Observe(time.Since(s.start).Seconds())
+}
+
+// Label of reason of rejection. Метка причины отклонения
+func rejectOutcome(reason pb.RejectReason) string {
+	if reason == pb.RejectReason_REJECT_REASON_NOT_FOUND {
+		return outcomeUnknown
+	}
+	return outcomeInvalid
+}
+
+// Label of trigger, e.g. max_orders. Метка причины отправки, например max_orders
+func triggerLabel(t pb.FlushTrigger) string {
+	return strings.ToLower(strings.TrimPrefix(t.String(), "FLUSH_TRIGGER_"))
+}
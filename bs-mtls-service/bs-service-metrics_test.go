@@ -0,0 +1,151 @@
+// Тестирование метрик Prometheus. Testing of Prometheus metrics
+
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/blablatov/bidistream-mtls-grpc/bs-mtls-proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// Client of server with metrics interceptor. Клиент сервера с перехватчиком метрик
+func newMetricsClient(t *testing.T, m *serverMetrics, opts ...serverOption) pb.OrderManagementClient {
+	t.Helper()
+	lis := bufconn.Listen(bufSize)
+	s := grpc.NewServer(grpc.StreamInterceptor(orderServerStreamInterceptor(m)))
+	pb.RegisterOrderManagementServer(s, newServer(newMemoryStore(sampleOrders()...), opts...))
+	go func() {
+		if err := s.Serve(lis); err != nil {
+			log.Printf("failed to serve: %v", err)
+		}
+	}()
+	t.Cleanup(s.Stop)
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(getBufDialer(lis)), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("did not connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewOrderManagementClient(conn)
+}
+
+// Sends IDs, reads stream to end. Отправляет ID и читает поток до конца
+func processIDs(t *testing.T, client pb.OrderManagementClient, ids ...string) error {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := client.ProcessOrders(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		if err := stream.Send(&wrappers.StringValue{Value: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stream.CloseSend()
+	for {
+		if _, err := stream.Recv(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// Stream is closed by interceptor after client sees its end. Перехватчик закрывает поток после его завершения у клиента
+func waitStreamsClosed(t *testing.T, m *serverMetrics) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if testutil.ToFloat64(m.activeStreams.WithLabelValues(methodProcess)) == 0 {
+			return
+		}
+	}
+	t.Fatal("stream is still active")
+}
+
+func TestMetrics_ProcessOrders(t *testing.T) {
+	m := newServerMetrics()
+	client := newMetricsClient(t, m, withBatchPolicy(batchPolicy{MaxOrders: 2}), withLenient(true))
+	if err := processIDs(t, client, "102", "999", "-1", "104", "105"); err != nil {
+		t.Fatal(err)
+	}
+	waitStreamsClosed(t, m)
+
+	for name, tt := range map[string]struct {
+		got, want float64
+	}{
+		"accepted":      {testutil.ToFloat64(m.orders.WithLabelValues(outcomeAccepted)), 3},
+		"unknown":       {testutil.ToFloat64(m.orders.WithLabelValues(outcomeUnknown)), 1},
+		"invalid":       {testutil.ToFloat64(m.orders.WithLabelValues(outcomeInvalid)), 1},
+		"shipments":     {testutil.ToFloat64(m.shipments), 2},
+		"max_orders":    {testutil.ToFloat64(m.triggers.WithLabelValues("max_orders")), 1},
+		"end_of_stream": {testutil.ToFloat64(m.triggers.WithLabelValues("end_of_stream")), 1},
+		"pending":       {testutil.ToFloat64(m.pendingOrders), 0},
+	} {
+		if tt.got != tt.want {
+			t.Errorf("%s = %v, want %v", name, tt.got, tt.want)
+		}
+	}
+	if n := testutil.CollectAndCount(m.ordersPerShipment); n != 1 {
+		t.Errorf("orders per shipment series = %d", n)
+	}
+
+	// Strict mode ends stream on unknown ID. Строгий режим завершает поток на неизвестном ID
+	client = newMetricsClient(t, m)
+	if err := processIDs(t, client, "999"); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("ProcessOrders(999) = %v", err)
+	}
+	waitStreamsClosed(t, m)
+	if got := testutil.ToFloat64(m.orders.WithLabelValues(outcomeUnknown)); got != 2 {
+		t.Errorf("unknown after strict stream = %v, want 2", got)
+	}
+
+	rec := httptest.NewRecorder()
+	m.handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{
+		`bs_orders_received_total{outcome="accepted"} 3`,
+		`bs_shipment_orders_sum 3`,
+		`bs_stream_duration_seconds_count{method="/ecommerce.OrderManagement/processOrders"} 2`,
+		`bs_streams_active{method="/ecommerce.OrderManagement/processOrders"} 0`,
+		"go_goroutines",
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("/metrics has no %s", want)
+		}
+	}
+}
+
+// ID read after stream is closed does not stay pending. ID, прочитанный после закрытия потока, не остается ожидающим
+func TestMetrics_ReceivedAfterClose(t *testing.T) {
+	m := newServerMetrics()
+	s := m.openStream(methodProcess)
+	s.received(&wrappers.StringValue{Value: "102"})
+	s.close(errCancelledByAdmin)
+	s.received(&wrappers.StringValue{Value: "103"})
+	if got := testutil.ToFloat64(m.pendingOrders); got != 0 {
+		t.Errorf("pending after close = %v, want 0", got)
+	}
+}
+
+// Without metrics stats are not collected. Без метрик статистика не собирается
+func TestMetrics_Nil(t *testing.T) {
+	var m *serverMetrics
+	s := m.openStream(methodProcess)
+	s.received(&wrappers.StringValue{Value: "102"})
+	s.sent(&pb.CombinedShipment{Rejected: &pb.RejectedOrder{Id: "999"}})
+	s.sent(&pb.CombinedShipment{})
+	s.close(nil)
+}
@@ -0,0 +1,59 @@
+// Плавная остановка сервера. Graceful shutdown of server
+
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Ends drained streams, client may retry IDs not yet shipped
+// Завершает потоки при остановке, клиент может повторить неотправленные ID
+var errShuttingDown = status.Error(codes.Unavailable, "server is shutting down")
+
+// Tells active streams to flush pending shipments and finish. New streams end at once
+// Сообщает активным потокам отправить ожидающие партии и завершиться. Новые потоки завершаются сразу
+func (s *mserver) drain() {
+	s.drainOnce.Do(func() { close(s.draining) })
+}
+
+// Reports NOT_SERVING and waits grace, so that balancers and clients stop sending new calls while listener is open.
+// Then stops accepting connections, drains streams and waits for them up to timeout, 0 - no limit.
+// Remaining RPCs are cancelled by Stop. Returns true if Stop was needed
+// Сообщает NOT_SERVING и ждет grace, чтобы балансировщики и клиенты перестали слать новые вызовы, пока порт открыт.
+// Затем прекращает прием соединений, завершает потоки и ждет их не дольше timeout, 0 - без ограничения.
+// Оставшиеся вызовы прерываются Stop. Возвращает true, если потребовался Stop
+func gracefulStop(s *grpc.Server, notServing func(), grace time.Duration, drain func(), timeout time.Duration) (forced bool) {
+	notServing()
+	if grace > 0 {
+		slog.Info("reported not serving, waiting before closing listener", "grace", grace.String())
+		time.Sleep(grace)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+	drain()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case <-done:
+		return false
+	case <-expired:
+		slog.Warn("drain timeout exceeded, stopping server", "timeout", timeout.String())
+		s.Stop()
+		<-done
+		return true
+	}
+}
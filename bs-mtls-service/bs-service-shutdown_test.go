@@ -0,0 +1,175 @@
+// Тестирование плавной остановки сервера. Testing of graceful shutdown
+
+package main
+
+import (
+	"context"
+	"log"
+	"testing"
+	"time"
+
+	pb "github.com/blablatov/bidistream-mtls-grpc/bs-mtls-proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// Starts server and returns it with client. Запускает сервер и возвращает его вместе с клиентом
+func startBufConnServer(t *testing.T, srv pb.OrderManagementServer) (*grpc.Server, pb.OrderManagementClient) {
+	t.Helper()
+	lis := bufconn.Listen(bufSize)
+	s := grpc.NewServer()
+	pb.RegisterOrderManagementServer(s, srv)
+	go func() {
+		if err := s.Serve(lis); err != nil {
+			log.Printf("failed to serve: %v", err)
+		}
+	}()
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(getBufDialer(lis)), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("did not connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return s, pb.NewOrderManagementClient(conn)
+}
+
+// Stream open during shutdown receives its partial shipments, then Unavailable
+// Поток, открытый во время остановки, получает накопленные партии, затем Unavailable
+func TestGracefulStop_DrainsStream(t *testing.T) {
+	srv := newServer(newMemoryStore(sampleOrders()...),
+		withBatchPolicy(batchPolicy{}), withLenient(true))
+	s, client := startBufConnServer(t, srv)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := client.ProcessOrders(ctx)
+	if err != nil {
+		t.Fatalf("ProcessOrders() = %v", err)
+	}
+	// Unknown ID is rejected after 102 and 103 are batched. Неизвестный ID отклоняется после 102 и 103
+	for _, id := range []string{"102", "103", "999"} {
+		if err := stream.Send(&wrappers.StringValue{Value: id}); err != nil {
+			t.Fatalf("Send(%s) = %v", id, err)
+		}
+	}
+	if comb, err := stream.Recv(); err != nil || comb.GetRejected() == nil {
+		t.Fatalf("Recv() = %v, %v, want rejection", comb, err)
+	}
+
+	stopped := make(chan bool)
+	go func() { stopped <- gracefulStop(s, func() {}, 0, srv.drain, 5*time.Second) }()
+
+	var orders int
+	for {
+		comb, err := stream.Recv()
+		if err != nil {
+			if status.Code(err) != codes.Unavailable {
+				t.Fatalf("Recv() error = %v, want Unavailable", err)
+			}
+			break
+		}
+		if comb.GetTrigger() != pb.FlushTrigger_FLUSH_TRIGGER_SHUTDOWN {
+			t.Errorf("trigger = %v, want SHUTDOWN", comb.GetTrigger())
+		}
+		orders += len(comb.GetOrdersList())
+	}
+	if orders != 2 {
+		t.Errorf("drained orders = %d, want 2", orders)
+	}
+	if forced := <-stopped; forced {
+		t.Errorf("gracefulStop() forced, want graceful")
+	}
+}
+
+// Stream ignoring drain is cancelled after timeout. Поток, не реагирующий на остановку, прерывается
+type stuckServer struct {
+	pb.OrderManagementServer
+}
+
+func (stuckServer) ProcessOrders(stream pb.OrderManagement_ProcessOrdersServer) error {
+	<-stream.Context().Done()
+	return stream.Context().Err()
+}
+
+func TestGracefulStop_Timeout(t *testing.T) {
+	s, client := startBufConnServer(t, stuckServer{})
+	stream, err := client.ProcessOrders(context.Background())
+	if err != nil {
+		t.Fatalf("ProcessOrders() = %v", err)
+	}
+	if err := stream.Send(&wrappers.StringValue{Value: "102"}); err != nil {
+		t.Fatalf("Send() = %v", err)
+	}
+	time.Sleep(50 * time.Millisecond) // Handler starts. Запуск обработчика
+
+	if forced := gracefulStop(s, func() {}, 0, func() {}, 100*time.Millisecond); !forced {
+		t.Errorf("gracefulStop() = graceful, want forced")
+	}
+	if _, err := stream.Recv(); err == nil {
+		t.Errorf("Recv() after Stop = nil error")
+	}
+}
+
+// Health reports NOT_SERVING while listener still accepts connections
+// Состояние NOT_SERVING видно, пока порт еще принимает соединения
+func TestGracefulStop_NotServingFirst(t *testing.T) {
+	lis := bufconn.Listen(bufSize)
+	s := grpc.NewServer()
+	r := newReadiness(nil)
+	r.register(s)
+	srv := newServer(newMemoryStore(sampleOrders()...))
+	pb.RegisterOrderManagementServer(s, srv)
+	r.storeLoaded(newMemoryStore())
+	go func() {
+		if err := s.Serve(lis); err != nil {
+			log.Printf("failed to serve: %v", err)
+		}
+	}()
+	t.Cleanup(s.Stop)
+	dial := func() healthpb.HealthClient {
+		conn, err := grpc.DialContext(context.Background(), "bufnet",
+			grpc.WithContextDialer(getBufDialer(lis)), grpc.WithInsecure())
+		if err != nil {
+			t.Fatalf("did not connect: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return healthpb.NewHealthClient(conn)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	watch, err := dial().Watch(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := watch.Recv(); err != nil || resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("Watch() = %v, %v, want SERVING", resp, err)
+	}
+
+	stopped := make(chan bool)
+	go func() {
+		stopped <- gracefulStop(s, r.drain, 500*time.Millisecond, func() { r.stop(); srv.drain() }, 5*time.Second)
+	}()
+
+	if resp, err := watch.Recv(); err != nil || resp.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("Watch() after stop = %v, %v, want NOT_SERVING", resp, err)
+	}
+	// New connection is still accepted during grace. Новое соединение принимается во время ожидания
+	resp, err := dial().Check(ctx, &healthpb.HealthCheckRequest{Service: pb.OrderManagement_ServiceDesc.ServiceName})
+	if err != nil || resp.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("Check() during grace = %v, %v, want NOT_SERVING", resp, err)
+	}
+	// Watch ends when listener closes, so it does not hold shutdown. Наблюдение завершается с закрытием порта и не задерживает остановку
+	if _, err := watch.Recv(); status.Code(err) != codes.Unavailable {
+		t.Errorf("Watch() after grace = %v, want Unavailable", err)
+	}
+	if forced := <-stopped; forced {
+		t.Errorf("gracefulStop() forced, want graceful")
+	}
+}
@@ -0,0 +1,116 @@
+// Хранилище заказов gRPC-сервиса. Order storage of gRPC-service
+
+package main
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	pb "github.com/blablatov/bidistream-mtls-grpc/bs-mtls-proto"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	errOrderNotFound = errors.New("order not found")
+	errOrderExists   = errors.New("order already exists")
+)
+
+// OrderStore is a repository of orders used by methods of service
+// Репозиторий заказов, используемый методами сервиса.
+// Возвращаемые заказы нельзя изменять, они могут разделяться между потоками
+type OrderStore interface {
+	Get(id string) (*pb.Order, bool)
+	Put(order *pb.Order) error
+	Create(order *pb.Order) error // errOrderExists if ID is taken. errOrderExists, если ID занят
+	Update(order *pb.Order) error // errOrderNotFound if ID is absent. errOrderNotFound, если ID нет
+	List() []*pb.Order
+	Delete(id string) error
+}
+
+// In-memory store, safe for concurrent use. Хранилище в памяти, безопасное для конкурентного доступа
+type memoryStore struct {
+	mu     sync.RWMutex
+	orders map[string]*pb.Order
+}
+
+// Creates in-memory store with initial orders. Создает хранилище в памяти с начальными заказами
+func newMemoryStore(orders ...*pb.Order) *memoryStore {
+	m := &memoryStore{orders: make(map[string]*pb.Order, len(orders))}
+	for _, o := range orders {
+		m.orders[o.GetId()] = proto.Clone(o).(*pb.Order)
+	}
+	return m
+}
+
+func (m *memoryStore) Get(id string) (*pb.Order, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	o, ok := m.orders[id]
+	return o, ok
+}
+
+// Stores a copy of order, so caller may reuse it. Сохраняет копию заказа
+func (m *memoryStore) Put(order *pb.Order) error {
+	o := proto.Clone(order).(*pb.Order)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.orders[o.GetId()] = o
+	return nil
+}
+
+// Stores copy of new order, check and write are atomic. Сохраняет копию нового заказа, проверка и запись атомарны
+func (m *memoryStore) Create(order *pb.Order) error {
+	return m.putIf(order, false)
+}
+
+// Replaces existing order, check and write are atomic. Заменяет существующий заказ, проверка и запись атомарны
+func (m *memoryStore) Update(order *pb.Order) error {
+	return m.putIf(order, true)
+}
+
+// Stores copy of order if it exists or, with exists false, if it is absent
+// Сохраняет копию заказа, если он есть или, при exists равном false, если его нет
+func (m *memoryStore) putIf(order *pb.Order, exists bool) error {
+	o := proto.Clone(order).(*pb.Order)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := checkExists(m.orders[o.GetId()] != nil, exists); err != nil {
+		return err
+	}
+	m.orders[o.GetId()] = o
+	return nil
+}
+
+// Error if found order does not match expected existence. Ошибка, если наличие заказа не совпадает с ожидаемым
+func checkExists(found, exists bool) error {
+	switch {
+	case found && !exists:
+		return errOrderExists
+	case !found && exists:
+		return errOrderNotFound
+	}
+	return nil
+}
+
+// Returns orders sorted by ID. Возвращает заказы, упорядоченные по ID
+func (m *memoryStore) List() []*pb.Order {
+	m.mu.RLock()
+	list := make([]*pb.Order, 0, len(m.orders))
+	for _, o := range m.orders {
+		list = append(list, o)
+	}
+	m.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].GetId() < list[j].GetId() })
+	return list
+}
+
+func (m *memoryStore) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.orders[id]; !ok {
+		return errOrderNotFound
+	}
+	delete(m.orders, id)
+	return nil
+}
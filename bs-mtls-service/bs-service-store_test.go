@@ -0,0 +1,131 @@
+// Тестирование хранилища заказов. Testing of order storage
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/blablatov/bidistream-mtls-grpc/bs-mtls-proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+)
+
+// Concurrent access to in-memory store. Конкурентный доступ к хранилищу в памяти
+func TestMemoryStore_Concurrent(t *testing.T) {
+	store := newMemoryStore(&pb.Order{Id: "1", Destination: "Moscow"})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := fmt.Sprint(i + 100)
+			if err := store.Put(&pb.Order{Id: id, Destination: "San Jose, CA"}); err != nil {
+				t.Errorf("Put(%s) = %v", id, err)
+			}
+			if _, ok := store.Get(id); !ok {
+				t.Errorf("Get(%s) not found", id)
+			}
+			store.List()
+		}(i)
+	}
+	wg.Wait()
+
+	if n := len(store.List()); n != 51 {
+		t.Errorf("len(List()) = %d, want 51", n)
+	}
+	if err := store.Delete("1"); err != nil {
+		t.Errorf("Delete(1) = %v", err)
+	}
+	if err := store.Delete("1"); err != errOrderNotFound {
+		t.Errorf("Delete(1) twice = %v, want %v", err, errOrderNotFound)
+	}
+}
+
+// Concurrent creates of one ID: one wins, update of deleted order fails
+// Конкурентное создание одного ID: успешно одно, изменение удаленного заказа не проходит
+func TestOrderStore_CreateUpdate(t *testing.T) {
+	file, err := openFileStore(filepath.Join(t.TempDir(), "orders.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	for name, store := range map[string]OrderStore{"memory": newMemoryStore(), "file": file} {
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		created := 0
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				err := store.Create(&pb.Order{Id: "1", Destination: fmt.Sprint(i)})
+				if err != nil && err != errOrderExists {
+					t.Errorf("%s: Create(1) = %v", name, err)
+				}
+				if err == nil {
+					mu.Lock()
+					created++
+					mu.Unlock()
+				}
+			}(i)
+		}
+		wg.Wait()
+		if created != 1 {
+			t.Errorf("%s: %d concurrent creates of 1 succeeded, want 1", name, created)
+		}
+
+		if err := store.Update(&pb.Order{Id: "1", Destination: "Moscow"}); err != nil {
+			t.Errorf("%s: Update(1) = %v", name, err)
+		}
+		if err := store.Delete("1"); err != nil {
+			t.Fatalf("%s: Delete(1) = %v", name, err)
+		}
+		if err := store.Update(&pb.Order{Id: "1"}); err != errOrderNotFound {
+			t.Errorf("%s: Update(1) after delete = %v, want %v", name, err, errOrderNotFound)
+		}
+		if _, ok := store.Get("1"); ok {
+			t.Errorf("%s: deleted order 1 is back", name)
+		}
+	}
+}
+
+// Stored order is a copy of argument. Хранилище сохраняет копию заказа
+func TestMemoryStore_PutCopies(t *testing.T) {
+	store := newMemoryStore()
+	o := &pb.Order{Id: "7", Destination: "Moscow"}
+	if err := store.Put(o); err != nil {
+		t.Fatal(err)
+	}
+	o.Destination = "Texas, CA"
+	if got, _ := store.Get("7"); got.GetDestination() != "Moscow" {
+		t.Errorf("Get(7).Destination = %q, want %q", got.GetDestination(), "Moscow")
+	}
+}
+
+// Server built with own fixtures. Сервер с собственным набором заказов
+func TestServer_ProcessOrdersOwnStore(t *testing.T) {
+	client := newBufConnClient(t, newMemoryStore(
+		&pb.Order{Id: "1", Items: []string{"Sensor_02"}, Destination: "Moscow", Price: 10.00},
+	))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	streamProcOrder, err := client.ProcessOrders(ctx)
+	if err != nil {
+		t.Fatalf("ProcessOrders(_) = _, %v", err)
+	}
+	if err := streamProcOrder.Send(&wrappers.StringValue{Value: "1"}); err != nil {
+		t.Fatalf("Send(1) = %v", err)
+	}
+	shipment, err := streamProcOrder.Recv()
+	if err != nil {
+		t.Fatalf("Recv() = %v", err)
+	}
+	if shipment.GetId() != "cmb - Moscow" || len(shipment.GetOrdersList()) != 1 {
+		t.Errorf("Recv() = %v, want one order to Moscow", shipment)
+	}
+}
@@ -0,0 +1,64 @@
+// Настройки TLS сервера. TLS configuration of server
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+
+	"github.com/blablatov/bidistream-mtls-grpc/bs-certwatch"
+	"github.com/blablatov/bidistream-mtls-grpc/bs-revocation"
+)
+
+// Builds mTLS configuration: certificates are reloaded, clients are checked by CRLs, OCSP response is stapled.
+// Background reloads live until ctx is done
+// Создает настройки mTLS: сертификаты перезагружаются, клиенты проверяются по CRL, ответ OCSP прикрепляется.
+// Фоновые перезагрузки работают, пока ctx не завершен.
+// Provider of certificates is returned for readiness checks. Поставщик сертификатов возвращается для проверок готовности
+func serverTLSConfig(ctx context.Context, cfg config) (*tls.Config, *certwatch.Provider, error) {
+	// Reading opened/closed keys and CA of clients to enable TLS, rotated files are reloaded
+	// Считываем ключи и сертификаты УЦ клиентов, чтобы включить TLS, замененные файлы перезагружаются
+	certs, err := certwatch.New(certwatch.Options{
+		CertFile: cfg.CertFile,
+		KeyFile:  cfg.KeyFile,
+		CAFile:   cfg.CAFile,
+		Interval: cfg.CertReload,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if cfg.CertReload > 0 {
+		go certs.Run(ctx)
+	}
+
+	base := &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert}
+
+	// Revoked client certificates are rejected. Отозванные сертификаты клиентов отклоняются
+	if cfg.CRLDir != "" {
+		crls, err := revocation.NewCRLChecker(cfg.CRLDir)
+		if err != nil {
+			return nil, nil, err
+		}
+		if cfg.CertReload > 0 {
+			go crls.Run(ctx, cfg.CertReload)
+		}
+		base.VerifyPeerCertificate = crls.VerifyPeerCertificate
+	}
+
+	// Issuer of server certificate is taken from its chain or CA file
+	// Издатель сертификата сервера берется из его цепочки или файла УЦ
+	if cfg.OCSPStaple {
+		issuers, err := revocation.LoadCertificates(cfg.CAFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		stapler := revocation.NewStapler(revocation.StaplerOptions{
+			Certificate: certs.Certificate,
+			Issuers:     issuers,
+			Responder:   cfg.OCSPResponder,
+		})
+		go stapler.Run(ctx)
+		base.GetCertificate = stapler.GetCertificate
+	}
+	return certs.ServerConfig(base), certs, nil
+}
@@ -0,0 +1,37 @@
+// Трассировка потоков OpenTelemetry: спан потока продолжает контекст W3C клиента из метаданных gRPC
+// OpenTelemetry tracing of streams: span of stream continues W3C trace context of client from gRPC metadata
+// Сообщения потока и выпуск партий записываются событиями спана
+// Messages of stream and flushes of shipments are recorded as events of span
+
+package main
+
+import (
+	"context"
+
+	"github.com/blablatov/bidistream-mtls-grpc/bs-tracing"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/status"
+)
+
+// Starts span of stream, child of span of client if there is one
+// Начинает спан потока, дочерний к спану клиента, если он есть
+func startStreamSpan(ctx context.Context, method string) (context.Context, trace.Span) {
+	return tracing.Tracer().Start(tracing.Extract(ctx), method, trace.WithSpanKind(trace.SpanKindServer))
+}
+
+// Ends span with status of stream. Завершает спан со статусом потока
+func endStreamSpan(span trace.Span, err error) {
+	span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int(int(status.Code(err))))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, status.Convert(err).Message())
+	}
+	span.End()
+}
+
+// Event of message with its order or shipment ID. Событие сообщения с ID его заказа или партии
+func messageEvent(span trace.Span, name string, m interface{}) {
+	span.AddEvent(name, trace.WithAttributes(tracing.Attributes(m)...))
+}
@@ -0,0 +1,157 @@
+// Тестирование трассировки потоков. Testing of tracing of streams
+
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/blablatov/bidistream-mtls-grpc/bs-tracing"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Spans of stream after server has ended them. Спаны потока после их завершения сервером
+func waitSpans(t *testing.T, exp *tracetest.InMemoryExporter, n int) tracetest.SpanStubs {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if spans := exp.GetSpans(); len(spans) >= n {
+			return spans
+		}
+	}
+	t.Fatalf("recorded %d spans, want %d", len(exp.GetSpans()), n)
+	return nil
+}
+
+// Value of attribute of event. Значение атрибута события
+func eventAttr(attrs []attribute.KeyValue, key attribute.Key) string {
+	for _, kv := range attrs {
+		if kv.Key == key {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func TestTracing_ProcessOrders(t *testing.T) {
+	exp, restore := tracing.SetupMemory()
+	defer restore()
+	client := newMetricsClient(t, nil, withBatchPolicy(batchPolicy{MaxOrders: 2}), withLenient(true))
+
+	// Span of client is passed in metadata. Спан клиента передается в метаданных
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ctx, clientSpan := tracing.Tracer().Start(ctx, "client")
+	stream, err := client.ProcessOrders(tracing.Inject(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{"102", "999", "104", "105"}
+	for _, id := range ids {
+		if err := stream.Send(&wrappers.StringValue{Value: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stream.CloseSend()
+	var shipped []string
+	for {
+		comb, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if comb.GetRejected() == nil {
+			shipped = append(shipped, comb.GetId())
+		}
+	}
+	clientSpan.End()
+
+	spans := waitSpans(t, exp, 2)
+	var server tracetest.SpanStub
+	for _, s := range spans {
+		if s.Name == methodProcess {
+			server = s
+		}
+	}
+	if server.SpanKind != trace.SpanKindServer {
+		t.Fatalf("no server span of %s in %v", methodProcess, spans)
+	}
+	if !server.Parent.IsRemote() || server.Parent.SpanID() != clientSpan.SpanContext().SpanID() ||
+		server.SpanContext.TraceID() != clientSpan.SpanContext().TraceID() {
+		t.Errorf("server span parent %v, want remote client span %v", server.Parent, clientSpan.SpanContext())
+	}
+
+	// Received IDs in order, flushes and sends of same shipments. Полученные ID по порядку, выпуски и отправки тех же партий
+	var recv, flushed, sent []string
+	rejected := ""
+	for _, e := range server.Events {
+		switch e.Name {
+		case tracing.EventRecv:
+			recv = append(recv, eventAttr(e.Attributes, tracing.OrderID))
+		case tracing.EventFlush:
+			flushed = append(flushed, eventAttr(e.Attributes, tracing.ShipmentID))
+		case tracing.EventSend:
+			if id := eventAttr(e.Attributes, tracing.Rejected); id != "" {
+				rejected = id
+				continue
+			}
+			sent = append(sent, eventAttr(e.Attributes, tracing.ShipmentID))
+		}
+	}
+	if !equalStrings(recv, ids) {
+		t.Errorf("recv events %v, want %v", recv, ids)
+	}
+	if len(shipped) != 2 || !equalStrings(flushed, shipped) || !equalStrings(sent, shipped) {
+		t.Errorf("flush events %v, send events %v, want %v", flushed, sent, shipped)
+	}
+	if rejected != "999" {
+		t.Errorf("rejected send event of %q, want 999", rejected)
+	}
+	if server.Status.Code != otelcodes.Unset {
+		t.Errorf("status of span %v, want unset", server.Status)
+	}
+}
+
+// Failed stream is recorded as error of span. Ошибка потока записывается в спан
+func TestTracing_StrictError(t *testing.T) {
+	exp, restore := tracing.SetupMemory()
+	defer restore()
+	client := newMetricsClient(t, nil)
+	if err := processIDs(t, client, "999"); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("ProcessOrders(999) = %v", err)
+	}
+	server := waitSpans(t, exp, 1)[0]
+	if server.Parent.IsValid() {
+		t.Errorf("span without client context has parent %v", server.Parent)
+	}
+	if server.Status.Code != otelcodes.Error || len(server.Events) == 0 || server.Events[len(server.Events)-1].Name != "exception" {
+		t.Errorf("span status %v, events %v, want error", server.Status, server.Events)
+	}
+	for _, kv := range server.Attributes {
+		if kv.Key == semconv.RPCGRPCStatusCodeKey && kv.Value.AsInt64() != int64(codes.InvalidArgument) {
+			t.Errorf("status code attribute %v", kv.Value.AsInt64())
+		}
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
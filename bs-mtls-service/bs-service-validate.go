@@ -0,0 +1,88 @@
+// Проверка заказов перед обработкой. Validation of orders before processing
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	pb "github.com/blablatov/bidistream-mtls-grpc/bs-mtls-proto"
+	epb "google.golang.org/genproto/googleapis/rpc/errdetails"
+)
+
+// OrderValidator checks one rule of order and returns its violation or nil
+// Проверяет одно правило заказа и возвращает нарушение или nil
+type OrderValidator func(*pb.Order) *epb.BadRequest_FieldViolation
+
+// Syntax of order ID. Синтаксис ID заказа
+var orderIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.]{0,63}$`)
+
+// Built-in validators used by default. Встроенные проверки по умолчанию
+var defaultValidators = []OrderValidator{validateID, validateItems, validatePrice}
+
+// Runs all validators and collects violations. Выполняет все проверки и собирает нарушения
+func validateOrder(ord *pb.Order, validators []OrderValidator) []*epb.BadRequest_FieldViolation {
+	var violations []*epb.BadRequest_FieldViolation
+	for _, v := range validators {
+		if fv := v(ord); fv != nil {
+			violations = append(violations, fv)
+		}
+	}
+	return violations
+}
+
+// ID is alphanumeric and doesn't start with sign. ID состоит из букв и цифр и не начинается со знака
+func validateID(ord *pb.Order) *epb.BadRequest_FieldViolation {
+	if !orderIDPattern.MatchString(ord.GetId()) {
+		return &epb.BadRequest_FieldViolation{
+			Field:       "ID",
+			Description: fmt.Sprintf("Order ID is not valid : %q", ord.GetId()),
+		}
+	}
+	return nil
+}
+
+// Order has at least one item. Заказ содержит хотя бы один товар
+func validateItems(ord *pb.Order) *epb.BadRequest_FieldViolation {
+	if len(ord.GetItems()) == 0 {
+		return &epb.BadRequest_FieldViolation{
+			Field:       "items",
+			Description: fmt.Sprintf("Order %s has no items", ord.GetId()),
+		}
+	}
+	return nil
+}
+
+// Price is positive. Стоимость положительна
+func validatePrice(ord *pb.Order) *epb.BadRequest_FieldViolation {
+	if ord.GetPrice() <= 0 {
+		return &epb.BadRequest_FieldViolation{
+			Field:       "price",
+			Description: fmt.Sprintf("Price of order %s is not positive : %v", ord.GetId(), ord.GetPrice()),
+		}
+	}
+	return nil
+}
+
+// Destination must be in allow list, if it is not empty, and must not be in deny list
+// Адрес доставки должен быть в списке разрешенных, если он задан, и не должен быть в списке запрещенных
+func validateDestination(allow, deny []string) OrderValidator {
+	allowed := make(map[string]bool, len(allow))
+	for _, d := range allow {
+		allowed[d] = true
+	}
+	denied := make(map[string]bool, len(deny))
+	for _, d := range deny {
+		denied[d] = true
+	}
+	return func(ord *pb.Order) *epb.BadRequest_FieldViolation {
+		destination := ord.GetDestination()
+		if denied[destination] || (len(allowed) > 0 && !allowed[destination]) {
+			return &epb.BadRequest_FieldViolation{
+				Field:       "destination",
+				Description: fmt.Sprintf("Destination of order %s is not allowed : %q", ord.GetId(), destination),
+			}
+		}
+		return nil
+	}
+}
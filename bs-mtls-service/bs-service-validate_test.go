@@ -0,0 +1,106 @@
+// Тестирование проверок заказов. Testing of order validation
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/blablatov/bidistream-mtls-grpc/bs-mtls-proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	epb "google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestValidateOrder(t *testing.T) {
+	validators := append(append([]OrderValidator{}, defaultValidators...),
+		validateDestination(nil, []string{"Mount RU, CA"}))
+	tests := []struct {
+		name   string
+		order  *pb.Order
+		fields []string
+	}{
+		{
+			name:  "valid",
+			order: &pb.Order{Id: "102", Items: []string{"Google Pixel 3A"}, Destination: "Mountain View, CA", Price: 1800.00},
+		},
+		{
+			name:   "negative ID",
+			order:  &pb.Order{Id: "-1", Items: []string{"ID Err"}, Destination: "Moscow", Price: 300.00},
+			fields: []string{"ID"},
+		},
+		{
+			name:   "all violations",
+			order:  &pb.Order{Id: "", Destination: "Mount RU, CA"},
+			fields: []string{"ID", "items", "price", "destination"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations := validateOrder(tt.order, validators)
+			if len(violations) != len(tt.fields) {
+				t.Fatalf("validateOrder() = %v, want fields %v", violations, tt.fields)
+			}
+			for i, fv := range violations {
+				if fv.GetField() != tt.fields[i] {
+					t.Errorf("violation %d field = %q, want %q", i, fv.GetField(), tt.fields[i])
+				}
+			}
+		})
+	}
+}
+
+func TestValidateDestination_Allow(t *testing.T) {
+	v := validateDestination([]string{"Moscow"}, nil)
+	if fv := v(&pb.Order{Id: "11", Destination: "Moscow"}); fv != nil {
+		t.Errorf("allowed destination: %v", fv)
+	}
+	if fv := v(&pb.Order{Id: "10", Destination: "Texas, CA"}); fv == nil {
+		t.Errorf("destination not in allow list passed")
+	}
+}
+
+// All violations are returned in one BadRequest. Все нарушения возвращаются в одном BadRequest
+func TestServer_AddOrderViolations(t *testing.T) {
+	client := newBufConnClient(t, newMemoryStore())
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	_, err := client.AddOrder(ctx, &pb.Order{Id: "-5", Destination: "Moscow"})
+	st := status.Convert(err)
+	if st.Code() != codes.InvalidArgument || len(st.Details()) != 1 {
+		t.Fatalf("AddOrder() = %v, want InvalidArgument with one detail", err)
+	}
+	if br, ok := st.Details()[0].(*epb.BadRequest); !ok || len(br.GetFieldViolations()) != 3 {
+		t.Errorf("details = %v, want BadRequest with 3 violations", st.Details())
+	}
+}
+
+// Stored order with invalid ID is rejected in stream. Сохраненный заказ с недопустимым ID отклоняется
+func TestServer_ProcessOrdersInvalidID(t *testing.T) {
+	client := newBufConnClient(t, newMemoryStore(sampleOrders()...))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	streamProcOrder, err := client.ProcessOrders(ctx)
+	if err != nil {
+		t.Fatalf("ProcessOrders(_) = _, %v", err)
+	}
+	if err := streamProcOrder.Send(&wrappers.StringValue{Value: "-1"}); err != nil {
+		t.Fatalf("Send(-1) = %v", err)
+	}
+	_, err = streamProcOrder.Recv()
+	checkFieldError(t, err, codes.InvalidArgument)
+	// Rejected ID is typed detail of error. Отклоненный ID - типизированная деталь ошибки
+	var rejected *pb.RejectedOrder
+	for _, d := range status.Convert(err).Details() {
+		if r, ok := d.(*pb.RejectedOrder); ok {
+			rejected = r
+		}
+	}
+	if rejected.GetId() != "-1" || rejected.GetReason() != pb.RejectReason_REJECT_REASON_INVALID {
+		t.Errorf("rejected detail = %v, want -1 with reason INVALID", rejected)
+	}
+}
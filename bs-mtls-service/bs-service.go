@@ -5,17 +5,22 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
-	"crypto/x509"
-	"io/ioutil"
-	"log"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
 	"net"
-	"path/filepath"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/blablatov/bidistream-mtls-grpc/bs-logging"
 	pb "github.com/blablatov/bidistream-mtls-grpc/bs-mtls-proto"
+	"github.com/blablatov/bidistream-mtls-grpc/bs-tracing"
 	"github.com/grpc-ecosystem/go-grpc-middleware"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
@@ -25,101 +30,245 @@ import (
 )
 
 var (
-	crtFile            = filepath.Join("..", "bs-mcerts", "server.crt")
-	keyFile            = filepath.Join("..", "bs-mcerts", "server.key")
-	caFile             = filepath.Join("..", "bs-mcerts", "ca.crt")
 	errMissingMetadata = status.Errorf(codes.InvalidArgument, "missing metadata")
 	errInvalidToken    = status.Errorf(codes.Unauthenticated, "invalid token")
+
+	// Expected bearer token, set from configuration. Ожидаемый токен, задается настройками
+	authToken = defaultConfig().Token
 )
 
 const (
-	port           = ":50051"
-	orderBatchSize = 1 // Group of orders. Заказы обрабатываются группами.
+	port           = ":50051" // Default port. Порт по умолчанию
+	orderBatchSize = 1        // Default group of orders. Заказы по умолчанию обрабатываются группами.
+
+	drainTimeout = 10 * time.Second // Default wait of streams at shutdown. Ожидание потоков при остановке
+	drainGrace   = 2 * time.Second  // Default wait after NOT_SERVING at shutdown. Ожидание после NOT_SERVING при остановке
+	auditMaxSize = 100              // MB of audit log before rotation. МБ журнала аудита до ротации
 )
 
 func main() {
-	log.SetPrefix("Server event: ")
-	log.SetFlags(log.Lshortfile)
-
-	// Reading opened/closed keys to enable TLS
-	// Считываем и анализируем открытый/закрытый ключи и создаем сертификат, чтобы включить TLS
-	cert, err := tls.LoadX509KeyPair(crtFile, keyFile)
+	// Configuration from file, environment and flags. Настройки из файла, окружения и флагов
+	cfg, err := loadConfig(os.Args[1:], os.LookupEnv, os.Stderr)
+	if err == flag.ErrHelp {
+		return
+	}
 	if err != nil {
-		log.Fatalf("failed to load key pair: %s", err)
+		fatal("failed to load config", err)
 	}
-
-	// Create a certificate pool from the certificate authority
-	// Генерируем пул сертификатов в удостоверяющем центре
-	certPool := x509.NewCertPool()
-	ca, err := ioutil.ReadFile(caFile)
+	// Leveled text or JSON log, also of standard log package. Журнал с уровнями, также и стандартного пакета log
+	logger, err := logging.New(os.Stderr, cfg.LogLevel, cfg.LogFormat)
 	if err != nil {
-		log.Fatalf("could not read ca certificate: %s", err)
+		fatal("failed to set up logging", err)
 	}
+	slog.SetDefault(logger)
+	slog.Info("effective config", "config", cfg.String())
+	authToken = cfg.Token
 
-	// Append the client certificates from the CA
-	// Добавляем клиентские сертификаты из удостоверяющего центра в сгенерированный пул
-	if ok := certPool.AppendCertsFromPEM(ca); !ok {
-		log.Fatalf("failed to append client certs")
+	// SIGTERM of container or Ctrl+C starts graceful shutdown, second signal kills process
+	// SIGTERM контейнера или Ctrl+C запускают плавную остановку, повторный сигнал завершает процесс
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// TLS with rotated certificates and revocation checks. TLS с заменой сертификатов и проверкой отзыва
+	tlsConfig, certs, err := serverTLSConfig(ctx, cfg)
+	if err != nil {
+		fatal("failed to set up TLS", err)
 	}
 
 	opts := []grpc.ServerOption{
 		// Enable TLS for all incoming connections. Включаем TLS для всех входящих соединений путем
 		grpc.Creds( // Create the TLS credentials. Создание аутентификационных данных TLS
-			credentials.NewTLS(&tls.Config{
-				ClientAuth:   tls.RequireAndVerifyClientCert,
-				Certificates: []tls.Certificate{cert},
-				ClientCAs:    certPool,
-			},
-			)),
-		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(
-			// Registers unary interceptor to gRPC-server. Регистрация унарного перехватчика
-			// Будет направлять клиентские запросы к функции ensureValidBasicCredentials
-			grpc.UnaryServerInterceptor(ensureValidToken),
-		)),
-		grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(
-			// Регистрация дополнительного потокового перехватчика на gRPC-сервере
-			// Будет направлять клиентские запросы к функции orderServerStreamInterceptor
-			grpc.StreamServerInterceptor(orderServerStreamInterceptor),
-		)),
+			credentials.NewTLS(tlsConfig),
+		),
 	}
 
+	// Access policy by client certificate, reloaded with certificates
+	// Политика доступа по сертификату клиента, перечитывается вместе с сертификатами
+	var acl *aclAuthorizer
+	if cfg.ACLFile != "" {
+		if acl, err = newACLAuthorizer(cfg.ACLFile); err != nil {
+			fatal("failed to load acl", err)
+		}
+		if cfg.CertReload > 0 {
+			go acl.run(ctx, cfg.CertReload)
+		}
+	}
+	// Bearer JWTs of JWKS, static token without it. Токены JWT по ключам JWKS, без них - постоянный токен
+	jwtv, err := newJWTValidator(cfg)
+	if err != nil {
+		fatal("failed to load jwks", err)
+	}
+	if jwtv != nil && cfg.CertReload > 0 {
+		go jwtv.keys.run(ctx, cfg.CertReload)
+	}
+	// Rate and stream limits of client identities. Ограничения частоты и потоков удостоверений клиентов
+	limits := newCallLimiter(cfg)
+	if limits != nil {
+		go limits.run(ctx, limitsReportPeriod)
+	}
+	// Metrics on separate HTTP port. Метрики на отдельном HTTP-порту
+	var metrics *serverMetrics
+	if cfg.MetricsAddr != "" {
+		metrics = newServerMetrics()
+		if err := metrics.serve(ctx, cfg.MetricsAddr); err != nil {
+			fatal("failed to serve metrics", err)
+		}
+	}
+	// Channelz, pprof and active streams on admin port. Channelz, pprof и активные потоки на служебном порту
+	var streams *streamRegistry
+	if cfg.AdminAddr != "" {
+		streams = newStreamRegistry()
+		if err := newAdminServer(streams).serve(ctx, cfg.AdminAddr, cfg.AdminRemote); err != nil {
+			fatal("failed to serve admin", err)
+		}
+	}
+	// Spans of streams, flushed at exit. Спаны потоков, сбрасываются при выходе
+	shutdownTracing, err := tracing.Setup(ctx, cfg.TraceExporter, cfg.TraceEndpoint, "bs-mtls-service", os.Stdout)
+	if err != nil {
+		fatal("failed to set up tracing", err)
+	}
+	opts = append(opts, interceptorOpts(jwtv, acl, limits, metrics)...)
+
 	// Creates new gRPC server, sends him data of authentification
 	// Создаем новый экземпляр gRPC-сервера, передавая ему аутентификационные данные
 	s := grpc.NewServer(opts...)
 
+	// Health of services, ready with loaded store and valid certificate
+	// Состояние сервисов, готовность при загруженном хранилище и действующем сертификате
+	ready := newReadiness(certs.Certificate)
+	ready.register(s)
+
 	// Register realise of service on created gRPC-server via generated of AP
 	// Регистрируем реализованный сервис на созданном gRPCсервере с помощью сгенерированных AP
-	pb.RegisterOrderManagementServer(s, &mserver{})
-
-	initSampleData()
+	store, err := openStore(cfg.Store)
+	if err != nil {
+		fatal("failed to open store", err)
+	}
+	// Audit trail of order streams. Журнал аудита потоков заказов
+	auditLog, closeAudit, err := openAudit(cfg)
+	if err != nil {
+		fatal("failed to open audit log", err)
+	}
+	srv := newServer(store,
+		withBatchPolicy(cfg.batchPolicy()),
+		withLenient(cfg.Lenient),
+		withValidators(cfg.validators()...),
+		withAudit(auditLog),
+		withStreamRegistry(streams),
+	)
+	pb.RegisterOrderManagementServer(s, srv)
+	ready.storeLoaded(store)
+	go ready.run(ctx, healthCheckPeriod)
 
 	// Начинаем прослушивать TCP на порту 50051. Listen on TCP port
-	lis, err := net.Listen("tcp", port)
+	lis, err := net.Listen("tcp", cfg.Port)
 	if err != nil {
-		log.Fatalf("failed to listen: %v", err)
+		fatal("failed to listen", err)
 	}
-	log.Printf("Starting gRPC listener on port " + port)
+	slog.Info("starting gRPC listener", "port", cfg.Port)
 
 	// Binds gRPC server to listener, waiting for messages on port 50051
 	// Привязываем gRPC-сервер к прослушивателю, ожидающему сообщений на порту 50051
-	if err := s.Serve(lis); err != nil {
-		log.Fatalf("failed to serve: %v", err)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.Serve(lis)
+	}()
+	select {
+	case err := <-serveErr:
+		fatal("failed to serve", err)
+	case <-ctx.Done():
+	}
+	stop()
+
+	slog.Info("shutting down", "drain_grace", cfg.DrainGrace.String(), "drain_timeout", cfg.DrainTimeout.String())
+	// Probes see NOT_SERVING before listener closes and while streams drain
+	// Пробы видят NOT_SERVING до закрытия порта и пока потоки завершаются
+	if gracefulStop(s, ready.drain, cfg.DrainGrace, func() { ready.stop(); srv.drain() }, cfg.DrainTimeout) {
+		slog.Warn("server stopped, some RPCs were cancelled")
+	} else {
+		slog.Info("server stopped gracefully")
+	}
+	if c, ok := store.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			slog.Error("failed to close store", "err", err)
+		}
+	}
+	if closeAudit != nil {
+		if err := closeAudit(); err != nil {
+			slog.Error("failed to close audit log", "err", err)
+		}
+	}
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(flushCtx); err != nil {
+		slog.Error("failed to flush spans", "err", err)
+	}
+}
+
+// Logs error and exits. Записывает ошибку и завершает процесс
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
+}
+
+// Interceptors of gRPC-server, shared with tests. Перехватчики gRPC-сервера, общие с тестами
+// Nil validator checks static token, nil authorizer allows all verified clients, nil limiter allows all calls,
+// nil metrics are not collected
+// Пустой валидатор проверяет постоянный токен, пустой авторизатор разрешает всех проверенных клиентов,
+// пустой ограничитель разрешает все вызовы, пустые метрики не собираются
+func interceptorOpts(jwtv *jwtValidator, acl *aclAuthorizer, limits *callLimiter, metrics *serverMetrics) []grpc.ServerOption {
+	unaryAuth, streamAuth := grpc.UnaryServerInterceptor(ensureValidToken), grpc.StreamServerInterceptor(ensureValidTokenStream)
+	if jwtv != nil {
+		unaryAuth, streamAuth = jwtv.unaryInterceptor, jwtv.streamInterceptor
+	}
+	// Health checks are authorized by certificate and acl only. Проверки состояния авторизуются только сертификатом и acl
+	unaryAuth, streamAuth = skipHealthUnary(unaryAuth), skipHealthStream(streamAuth)
+	return []grpc.ServerOption{
+		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(
+			// Registers unary interceptor to gRPC-server. Регистрация унарного перехватчика
+			// Будет направлять клиентские запросы к функции ensureValidBasicCredentials
+			unaryAuth,
+			// Identity of client certificate. Удостоверение сертификата клиента
+			acl.unaryInterceptor,
+			// Limits of authenticated identity. Ограничения проверенного удостоверения
+			limits.unaryInterceptor,
+		)),
+		grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(
+			// Token is checked before any message of stream is read. Токен проверяется до чтения сообщений потока
+			streamAuth,
+			acl.streamInterceptor,
+			limits.streamInterceptor,
+			// Регистрация дополнительного потокового перехватчика на gRPC-сервере
+			// Будет направлять клиентские запросы к функции orderServerStreamInterceptor
+			orderServerStreamInterceptor(metrics),
+		)),
 	}
 }
 
-func initSampleData() {
-	orderMap["102"] = pb.Order{Id: "102", Items: []string{"Google Pixel 3A", "Mac Book Pro"}, Destination: "Mountain View, CA", Price: 1800.00}
-	orderMap["103"] = pb.Order{Id: "103", Items: []string{"Apple Watch S4"}, Destination: "San Jose, CA", Price: 400.00}
-	orderMap["104"] = pb.Order{Id: "104", Items: []string{"Google Home Mini", "Google Nest Hub"}, Destination: "Mountain View, CA", Price: 400.00}
-	orderMap["105"] = pb.Order{Id: "105", Items: []string{"Amazon Echo"}, Destination: "San Jose, CA", Price: 30.00}
-	orderMap["106"] = pb.Order{Id: "106", Items: []string{"Ozon Echo", "Apple iPhone XS"}, Destination: "Mountain View, CA", Price: 300.00}
-	orderMap["-1"] = pb.Order{Id: "-1", Items: []string{"ID Err", "Aguarius not iPhone XZ"}, Destination: "Mount RU, CA fuck", Price: 300.00}
-
-	orderMap["10"] = pb.Order{Id: "10", Items: []string{"Dallas", "Texas Instruments"}, Destination: "Texas, CA", Price: 500.00}
-	orderMap["11"] = pb.Order{Id: "11", Items: []string{"Sensor_01"}, Destination: "Moscow", Price: 400.00}
-	orderMap["12"] = pb.Order{Id: "12", Items: []string{"Message_01", "Yandex Cloud"}, Destination: "Moscow, ru-central1-a", Price: 1.00}
-	orderMap["13"] = pb.Order{Id: "13", Items: []string{"Stream_1"}, Destination: "Moscow, Yandex Cloud", Price: 10.00}
-	orderMap["14"] = pb.Order{Id: "14", Items: []string{"Message_02", "Yandex Cloud"}, Destination: "Moscow, ru-central1-b", Price: 1.00}
+// Fills store with sample orders. Заполняет хранилище тестовыми заказами
+func initSampleData(store OrderStore) error {
+	for _, o := range sampleOrders() {
+		if err := store.Put(o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sampleOrders() []*pb.Order {
+	return []*pb.Order{
+		{Id: "102", Items: []string{"Google Pixel 3A", "Mac Book Pro"}, Destination: "Mountain View, CA", Price: 1800.00},
+		{Id: "103", Items: []string{"Apple Watch S4"}, Destination: "San Jose, CA", Price: 400.00},
+		{Id: "104", Items: []string{"Google Home Mini", "Google Nest Hub"}, Destination: "Mountain View, CA", Price: 400.00},
+		{Id: "105", Items: []string{"Amazon Echo"}, Destination: "San Jose, CA", Price: 30.00},
+		{Id: "106", Items: []string{"Ozon Echo", "Apple iPhone XS"}, Destination: "Mountain View, CA", Price: 300.00},
+		{Id: "-1", Items: []string{"ID Err", "Aguarius not iPhone XZ"}, Destination: "Mount RU, CA fuck", Price: 300.00},
+		{Id: "10", Items: []string{"Dallas", "Texas Instruments"}, Destination: "Texas, CA", Price: 500.00},
+		{Id: "11", Items: []string{"Sensor_01"}, Destination: "Moscow", Price: 400.00},
+		{Id: "12", Items: []string{"Message_01", "Yandex Cloud"}, Destination: "Moscow, ru-central1-a", Price: 1.00},
+		{Id: "13", Items: []string{"Stream_1"}, Destination: "Moscow, Yandex Cloud", Price: 10.00},
+		{Id: "14", Items: []string{"Message_02", "Yandex Cloud"}, Destination: "Moscow, ru-central1-b", Price: 1.00},
+	}
 }
 
 // Validates the authorization. Валидация токена
@@ -130,7 +279,7 @@ func valid(authorization []string) bool {
 	token := strings.TrimPrefix(authorization[0], "Bearer ")
 	// Performs validation of token matching an arbitrary string
 	// Выполняем проверку токена, соответствующего нашему произвольно заданному
-	return token == "blablatok-tokblabla-blablatok"
+	return token == authToken
 }
 
 // Определяем функцию ensureValidToken для проверки подлинности токена. Validate token
@@ -150,44 +299,90 @@ func ensureValidToken(ctx context.Context, req interface{},
 	return handler(ctx, req)
 }
 
+// Stream variant of ensureValidToken. Потоковый вариант функции ensureValidToken
+// Проверяет токен из метаданных потока до вызова обработчика handler
+func ensureValidTokenStream(srv interface{}, ss grpc.ServerStream,
+	info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	md, ok := metadata.FromIncomingContext(ss.Context())
+	if !ok {
+		return errMissingMetadata
+	}
+	if !valid(md["authorization"]) {
+		return errInvalidToken
+	}
+	// Continue execution of handler after ensuring a valid token
+	// Если токен действителен, продолжаем выполнение обработчика
+	return handler(srv, ss)
+}
+
 // Stream wraps around the embedded grpc.Server Stream, and intercepts the RecvMsg and SendMsg method call
 // Обертка вокруг встроенного интерфейса grpc.ServerStream, перехватывает вызовы методов RecvMsg и SendMsg
 type wrappedStream struct {
 	grpc.ServerStream
+	ctx   context.Context // Context with span and logger of stream. Контекст со спаном и журналом потока
+	span  trace.Span
+	log   *slog.Logger
+	stats *streamStats // Metrics of stream, nil - off. Метрики потока, nil - выключены
+}
+
+// Context of stream carries its span and logger to handler. Контекст потока передает его спан и журнал обработчику
+func (w *wrappedStream) Context() context.Context {
+	return w.ctx
 }
 
 // RecvMsg wrapper function, handles received gRPC streaming messages
 // Функция обертки RecvMsg, обрабатывает принимаемые сообщения потокового gRPC
 func (w *wrappedStream) RecvMsg(m interface{}) error {
-	log.Printf("====== [Server Stream Interceptor Wrapper] Receive a message (Type: %T) at %s",
-		m, time.Now().Format(time.RFC3339))
-	return w.ServerStream.RecvMsg(m)
+	err := w.ServerStream.RecvMsg(m)
+	if err == nil {
+		// Each message only at debug level. Каждое сообщение только на уровне debug
+		w.log.Debug("message received", "type", fmt.Sprintf("%T", m))
+		messageEvent(w.span, tracing.EventRecv, m)
+		w.stats.received(m)
+	}
+	return err
 }
 
 // The wrapper function SendMsg, handles the sent messages of the streaming gRPC
 // Функция обертки SendMsg, обрабатывает отправляемые сообщения потокового gRPC
 func (w *wrappedStream) SendMsg(m interface{}) error {
-	log.Printf("====== [Server Stream Interceptor Wrapper] Send a message (Type: %T) at %v",
-		m, time.Now().Format(time.RFC3339))
-	return w.ServerStream.SendMsg(m)
+	err := w.ServerStream.SendMsg(m)
+	if err == nil {
+		w.log.Debug("message sent", "type", fmt.Sprintf("%T", m))
+		messageEvent(w.span, tracing.EventSend, m)
+		w.stats.sent(m)
+	}
+	return err
 }
 
 // Creating wrapper function. Создание экземпляра функции-обертки
-func newWrappedStream(s grpc.ServerStream) grpc.ServerStream {
-	return &wrappedStream{s}
+func newWrappedStream(ctx context.Context, s grpc.ServerStream, span trace.Span, stats *streamStats) grpc.ServerStream {
+	return &wrappedStream{s, ctx, span, logging.FromContext(ctx), stats}
 }
 
-// Creates streaming interceptor. Реализация потокового перехватчика
-func orderServerStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
-	handler grpc.StreamHandler) error {
-	// Pre-processing. Этап предобработки
-	log.Println("====== [Server Stream Interceptor] ", info.FullMethod)
+// Creates streaming interceptor tracing stream and collecting metrics, nil metrics - only log and spans.
+// Span of stream continues trace context of client, logger of stream is attached to its context
+// Реализация потокового перехватчика, трассирующего поток и собирающего метрики, пустые метрики - только журнал и спаны.
+// Спан потока продолжает контекст трассировки клиента, журнал потока добавляется в его контекст
+func orderServerStreamInterceptor(metrics *serverMetrics) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler) error {
+		// Pre-processing. Этап предобработки
+		ctx, span := startStreamSpan(ss.Context(), info.FullMethod)
+		ctx, l := streamLogger(ctx, info.FullMethod)
+		l.Info("stream opened")
 
-	// Invoking the StreamHandler to complete the execution of RPC invocation
-	// Вызов метода потокового RPC с помощью обертки.
-	err := handler(srv, newWrappedStream(ss))
-	if err != nil {
-		log.Printf("RPC failed with error %v", err)
+		// Invoking the StreamHandler to complete the execution of RPC invocation
+		// Вызов метода потокового RPC с помощью обертки.
+		stats := metrics.openStream(info.FullMethod)
+		err := handler(srv, newWrappedStream(ctx, ss, span, stats))
+		stats.close(err)
+		endStreamSpan(span, err)
+		if err != nil {
+			l.Warn("stream failed", "code", status.Code(err).String(), "err", err)
+		} else {
+			l.Info("stream closed")
+		}
+		return err
 	}
-	return err
 }
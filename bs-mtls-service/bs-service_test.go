@@ -16,8 +16,11 @@ import (
 	pb "github.com/blablatov/bidistream-mtls-grpc/bs-mtls-proto"
 	"github.com/golang/protobuf/ptypes/wrappers"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
 	"google.golang.org/grpc/test/bufconn"
 )
 
@@ -35,8 +38,7 @@ func initGRPCServerHTTP2() {
 		log.Fatalf("failed to listen: %v", err)
 	}
 	s := grpc.NewServer()
-	pb.RegisterOrderManagementServer(s, &mserver{})
-	initSampleData()
+	pb.RegisterOrderManagementServer(s, newServer(newMemoryStore(sampleOrders()...)))
 	// Register reflection service on gRPC server.
 	reflection.Register(s)
 	go func() {
@@ -58,8 +60,7 @@ func getBufDialer(listener *bufconn.Listener) func(context.Context, string) (net
 func initGRPCServerBuffConn() {
 	listener = bufconn.Listen(bufSize)
 	s := grpc.NewServer()
-	pb.RegisterOrderManagementServer(s, &mserver{})
-	initSampleData()
+	pb.RegisterOrderManagementServer(s, newServer(newMemoryStore(sampleOrders()...)))
 	// Register reflection service on gRPC server.
 	reflection.Register(s)
 	go func() {
@@ -69,6 +70,66 @@ func initGRPCServerBuffConn() {
 	}()
 }
 
+// Initialization of BufConn with interceptors of production server
+// Имитация запуска сервера с перехватчиками, как в рабочем сервере
+func initGRPCServerBuffConnAuth() *bufconn.Listener {
+	lis := bufconn.Listen(bufSize)
+	s := grpc.NewServer(interceptorOpts(nil, nil, nil, nil)...)
+	pb.RegisterOrderManagementServer(s, newServer(newMemoryStore(sampleOrders()...)))
+	go func() {
+		if err := s.Serve(lis); err != nil {
+			log.Fatalf("failed to serve: %v", err)
+		}
+	}()
+	return lis
+}
+
+// Test of token validation on the ProcessOrders stream
+// Тест проверки токена в потоке ProcessOrders
+func TestServer_ProcessOrdersStreamAuth(t *testing.T) {
+	lis := initGRPCServerBuffConnAuth()
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(getBufDialer(lis)), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("did not connect: %v", err)
+	}
+	defer conn.Close()
+	client := pb.NewOrderManagementClient(conn)
+
+	tests := []struct {
+		name  string
+		token string
+		code  codes.Code
+	}{
+		{name: "accepted", token: "Bearer blablatok-tokblabla-blablatok", code: codes.OK},
+		{name: "missing", token: "", code: codes.Unauthenticated},
+		{name: "invalid", token: "Bearer tokblabla", code: codes.Unauthenticated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+			if tt.token != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, "authorization", tt.token)
+			}
+
+			streamProcOrder, err := client.ProcessOrders(ctx)
+			if err != nil {
+				t.Fatalf("%v.ProcessOrders(_) = _, %v", client, err)
+			}
+			if err := streamProcOrder.Send(&wrappers.StringValue{Value: "102"}); err != nil && err != io.EOF {
+				t.Fatalf("Send(102) = %v", err)
+			}
+
+			_, err = streamProcOrder.Recv()
+			if got := status.Code(err); got != tt.code {
+				t.Errorf("Recv() code = %v, want %v (err %v)", got, tt.code, err)
+			}
+		})
+	}
+}
+
 // Conventional test that starts a gRPC server and client test the service with RPC
 func TestServer_ProcessOrders(t *testing.T) {
 	log.SetPrefix("Client-test event: ")
@@ -0,0 +1,179 @@
+// Package revocation checks revocation of certificates by CRL files and stapled OCSP responses.
+// Пакет revocation проверяет отзыв сертификатов по файлам CRL и прикрепленным ответам OCSP.
+package revocation
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// CRLChecker verifies peer chains against CRLs of directory. Files *.crl and *.pem, DER or PEM.
+// CRL is applied if it is issued and signed by issuer of certificate. Safe for concurrent use.
+// CRL past its NextUpdate is still applied and logged once: rejecting all peers of CA would stop
+// service while publisher of CRL is late, and stale list still knows revoked certificates
+// Проверяет цепочки клиента по спискам отзыва из каталога. Файлы *.crl и *.pem, DER или PEM.
+// Список применяется, если выпущен и подписан издателем сертификата.
+// Список после его NextUpdate по-прежнему применяется и однократно журналируется: отказ всем клиентам УЦ
+// остановил бы сервис при опоздании издателя списка, а устаревший список все равно знает отозванные сертификаты
+type CRLChecker struct {
+	dir  string
+	crls atomic.Value // []*loadedCRL
+
+	// Reload events, nil - log.Printf. Журнал перезагрузок
+	Logf func(format string, args ...interface{})
+}
+
+// NewCRLChecker loads CRLs of directory. Загружает списки отзыва из каталога
+func NewCRLChecker(dir string) (*CRLChecker, error) {
+	c := &CRLChecker{dir: dir, Logf: log.Printf}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// CRL of directory. Список отзыва из каталога
+type loadedCRL struct {
+	*x509.RevocationList
+	stale atomic.Bool // Staleness is logged. Устаревание записано в журнал
+}
+
+// Identity of CRL across reloads: issuer and number. Идентичность списка между перечитываниями: издатель и номер
+func (crl *loadedCRL) key() string {
+	if crl.Number == nil {
+		return string(crl.RawIssuer)
+	}
+	return string(crl.RawIssuer) + "#" + crl.Number.String()
+}
+
+// Reload reads directory again. On error current CRLs are kept
+// Перечитывает каталог. При ошибке текущие списки сохраняются
+func (c *CRLChecker) Reload() error {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	var crls []*loadedCRL
+	for _, e := range entries {
+		ext := strings.ToLower(filepath.Ext(e.Name()))
+		if e.IsDir() || (ext != ".crl" && ext != ".pem") {
+			continue
+		}
+		list, err := loadCRLs(filepath.Join(c.dir, e.Name()))
+		if err != nil {
+			return err
+		}
+		for _, crl := range list {
+			crls = append(crls, &loadedCRL{RevocationList: crl})
+		}
+	}
+	// Reloaded list keeps logged staleness. Перечитанный список сохраняет отметку об устаревании
+	logged := make(map[string]bool)
+	if prev, ok := c.crls.Load().([]*loadedCRL); ok {
+		for _, crl := range prev {
+			if crl.stale.Load() {
+				logged[crl.key()] = true
+			}
+		}
+	}
+	for _, crl := range crls {
+		crl.stale.Store(logged[crl.key()])
+	}
+	c.crls.Store(crls)
+	return nil
+}
+
+// Reads DER or PEM file. Читает файл DER или PEM
+func loadCRLs(file string) ([]*x509.RevocationList, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Contains(data, []byte("-----BEGIN")) {
+		crl, err := x509.ParseRevocationList(data)
+		if err != nil {
+			return nil, fmt.Errorf("crl %s: %w", file, err)
+		}
+		return []*x509.RevocationList{crl}, nil
+	}
+	var crls []*x509.RevocationList
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "X509 CRL" {
+			continue
+		}
+		crl, err := x509.ParseRevocationList(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("crl %s: %w", file, err)
+		}
+		crls = append(crls, crl)
+	}
+	if len(crls) == 0 {
+		return nil, fmt.Errorf("crl %s: no X509 CRL blocks", file)
+	}
+	return crls, nil
+}
+
+// Run reloads directory every interval until ctx is done, failures are logged
+// Перечитывает каталог каждый interval, пока ctx не завершен, ошибки журналируются
+func (c *CRLChecker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.Reload(); err != nil {
+				c.Logf("revocation: CRL reload failed, keeping last good lists: %v", err)
+			}
+		}
+	}
+}
+
+// VerifyPeerCertificate is tls.Config.VerifyPeerCertificate, called after chains are verified
+// Для tls.Config.VerifyPeerCertificate, вызывается после проверки цепочек
+func (c *CRLChecker) VerifyPeerCertificate(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	crls := c.crls.Load().([]*loadedCRL)
+	now := time.Now()
+	for _, chain := range verifiedChains {
+		for i := 0; i+1 < len(chain); i++ {
+			if err := c.checkCRLs(crls, chain[i], chain[i+1], now); err != nil {
+				c.Logf("revocation: rejected peer: %v", err)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Checks certificate against CRLs of its issuer. Проверяет сертификат по спискам его издателя
+func (c *CRLChecker) checkCRLs(crls []*loadedCRL, cert, issuer *x509.Certificate, now time.Time) error {
+	for _, crl := range crls {
+		if !bytes.Equal(crl.RawIssuer, cert.RawIssuer) || crl.CheckSignatureFrom(issuer) != nil {
+			continue
+		}
+		if !crl.NextUpdate.IsZero() && now.After(crl.NextUpdate) && !crl.stale.Swap(true) {
+			c.Logf("revocation: CRL of %q is stale since %s, still applied", issuer.Subject.CommonName, crl.NextUpdate)
+		}
+		for _, revoked := range crl.RevokedCertificates {
+			if revoked.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return fmt.Errorf("certificate %q serial %s is revoked", cert.Subject.CommonName, cert.SerialNumber)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+// Тестирование проверки отзыва по спискам CRL. Testing of revocation by CRL
+
+package revocation
+
+import (
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blablatov/bidistream-mtls-grpc/bs-certs/pki"
+)
+
+// Issues certificate signed by parent, self-signed CA if parent is nil
+// Выпускает сертификат, подписанный parent, или самоподписанный УЦ
+func issue(t *testing.T, cn string, parent *pki.Cert, ocspURL string) *pki.Cert {
+	t.Helper()
+	if parent == nil {
+		ca, err := pki.NewCA(cn, pki.ECDSA, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ca
+	}
+	req := pki.Request{CommonName: cn, DNSNames: []string{cn}, Server: true, Client: true, Validity: time.Hour}
+	if ocspURL != "" {
+		req.OCSPServer = []string{ocspURL}
+	}
+	c, err := parent.Issue(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// Writes CRL of ca revoking certs. Записывает список отзыва УЦ
+func writeCRL(t *testing.T, file string, ca *pki.Cert, revoked ...*pki.Cert) {
+	t.Helper()
+	var certs []*x509.Certificate
+	for _, c := range revoked {
+		certs = append(certs, c.Cert)
+	}
+	crl, err := ca.RevocationList(time.Hour, certs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, crl, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCRLChecker(t *testing.T) {
+	ca := issue(t, "ca", nil, "")
+	good := issue(t, "good", ca, "")
+	leaked := issue(t, "leaked", ca, "")
+	dir := t.TempDir()
+
+	c, err := NewCRLChecker(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Logf = t.Logf
+	verify := func(tc *pki.Cert) error {
+		return c.VerifyPeerCertificate(nil, [][]*x509.Certificate{{tc.Cert, ca.Cert}})
+	}
+	if err := verify(leaked); err != nil {
+		t.Fatalf("VerifyPeerCertificate() without CRL = %v", err)
+	}
+
+	writeCRL(t, filepath.Join(dir, "ca.crl"), ca, leaked)
+	if err := c.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(leaked); err == nil {
+		t.Errorf("VerifyPeerCertificate(leaked) = nil error")
+	}
+	if err := verify(good); err != nil {
+		t.Errorf("VerifyPeerCertificate(good) = %v", err)
+	}
+
+	// CRL of other CA with same name is ignored. Список другого УЦ с тем же именем не применяется
+	other := issue(t, "ca", nil, "")
+	writeCRL(t, filepath.Join(dir, "ca.crl"), other, good)
+	if err := c.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(good); err != nil {
+		t.Errorf("VerifyPeerCertificate(good) with foreign CRL = %v", err)
+	}
+
+	// Broken file keeps last good lists. Испорченный файл оставляет прежние списки
+	writeCRL(t, filepath.Join(dir, "ca.crl"), ca, leaked)
+	if err := c.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "broken.pem"), []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Reload(); err == nil {
+		t.Errorf("Reload() with broken file = nil error")
+	}
+	if err := verify(leaked); err == nil {
+		t.Errorf("broken file replaced CRLs")
+	}
+}
+
+// Stale CRL is still applied and logged once, also across reloads
+// Устаревший список по-прежнему применяется и журналируется однократно, в том числе после перечитывания
+func TestCRLChecker_Stale(t *testing.T) {
+	ca := issue(t, "ca", nil, "")
+	good := issue(t, "good", ca, "")
+	leaked := issue(t, "leaked", ca, "")
+	dir := t.TempDir()
+	expired, err := ca.RevocationList(-time.Minute, leaked.Cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "ca.crl"), expired, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewCRLChecker(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logged []string
+	c.Logf = func(format string, args ...interface{}) { logged = append(logged, format) }
+	verify := func(tc *pki.Cert) error {
+		return c.VerifyPeerCertificate(nil, [][]*x509.Certificate{{tc.Cert, ca.Cert}})
+	}
+
+	for i := 0; i < 3; i++ {
+		if i > 0 {
+			if err := c.Reload(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := verify(good); err != nil {
+			t.Errorf("VerifyPeerCertificate(good) with stale CRL = %v", err)
+		}
+		if err := verify(leaked); err == nil {
+			t.Errorf("VerifyPeerCertificate(leaked) with stale CRL = nil error")
+		}
+	}
+	stale := 0
+	for _, f := range logged {
+		if strings.Contains(f, "stale") {
+			stale++
+		}
+	}
+	if stale != 1 {
+		t.Errorf("stale CRL logged %d times over two reloads, want 1: %q", stale, logged)
+	}
+}
+
+func TestNewCRLChecker_MissingDir(t *testing.T) {
+	if _, err := NewCRLChecker(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("NewCRLChecker(missing) = nil error")
+	}
+}
@@ -0,0 +1,260 @@
+package revocation
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// Period of checking staple freshness. Период проверки свежести прикрепленного ответа
+const stapleCheckInterval = time.Minute
+
+// VerifyStaple returns tls.Config.VerifyConnection of client checking stapled OCSP response of server.
+// Present response is always verified, missing one is an error if required
+// Возвращает VerifyConnection клиента, проверяющий прикрепленный ответ OCSP сервера.
+// Имеющийся ответ проверяется всегда, отсутствующий - ошибка, если ответ обязателен
+func VerifyStaple(required bool) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if len(cs.OCSPResponse) == 0 {
+			if required {
+				return errors.New("revocation: server sent no stapled OCSP response")
+			}
+			return nil
+		}
+		if len(cs.VerifiedChains) == 0 || len(cs.VerifiedChains[0]) < 2 {
+			return errors.New("revocation: no verified chain to check OCSP response")
+		}
+		chain := cs.VerifiedChains[0]
+		return checkResponse(cs.OCSPResponse, chain[0], chain[1], time.Now())
+	}
+}
+
+// Checks signature, status and validity period of response. Проверяет подпись, статус и срок ответа
+func checkResponse(raw []byte, leaf, issuer *x509.Certificate, now time.Time) error {
+	resp, err := ocsp.ParseResponseForCert(raw, leaf, issuer)
+	if err != nil {
+		return fmt.Errorf("revocation: invalid OCSP response: %w", err)
+	}
+	if now.Before(resp.ThisUpdate) || (!resp.NextUpdate.IsZero() && now.After(resp.NextUpdate)) {
+		return errors.New("revocation: OCSP response is outside its validity period")
+	}
+	switch resp.Status {
+	case ocsp.Good:
+		return nil
+	case ocsp.Revoked:
+		return fmt.Errorf("revocation: certificate %q serial %s is revoked", leaf.Subject.CommonName, leaf.SerialNumber)
+	default:
+		return fmt.Errorf("revocation: OCSP status of certificate %q is unknown", leaf.Subject.CommonName)
+	}
+}
+
+// Options of stapler. Параметры прикрепления ответов OCSP
+type StaplerOptions struct {
+	// Current certificate of server, e.g. certwatch.Provider.Certificate. Текущий сертификат сервера
+	Certificate func() *tls.Certificate
+
+	// Candidates of issuer, if chain of certificate lacks it. Возможные издатели, если их нет в цепочке
+	Issuers []*x509.Certificate
+
+	// URL of responder, empty - from certificate. Адрес ответчика, пусто - из сертификата
+	Responder string
+
+	HTTPClient *http.Client                             // Nil - client with 10s timeout
+	Logf       func(format string, args ...interface{}) // Nil - log.Printf
+}
+
+// Stapler fetches OCSP responses for server certificate and staples them to handshakes
+// Получает ответы OCSP для сертификата сервера и прикрепляет их к рукопожатиям
+type Stapler struct {
+	opts StaplerOptions
+
+	mu      sync.Mutex
+	source  *tls.Certificate // Certificate of staple. Сертификат, для которого получен ответ
+	stapled *tls.Certificate // Copy with staple. Копия с прикрепленным ответом
+	resp    *ocsp.Response
+	expired bool // Expiry of staple is logged. Истечение ответа записано в журнал
+}
+
+// NewStapler creates stapler, call Refresh or Run to fetch responses
+// Создает объект, ответы получают Refresh или Run
+func NewStapler(o StaplerOptions) *Stapler {
+	if o.HTTPClient == nil {
+		o.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if o.Logf == nil {
+		o.Logf = log.Printf
+	}
+	return &Stapler{opts: o}
+}
+
+// GetCertificate is tls.Config.GetCertificate of server. Certificate without fresh response is served as is,
+// response past its NextUpdate is dropped, since clients reject it
+// Для tls.Config.GetCertificate сервера. Сертификат без свежего ответа отдается как есть,
+// ответ после его NextUpdate не прикрепляется, так как клиенты его отклоняют
+func (s *Stapler) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cur := s.opts.Certificate()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.source != cur || s.stapled == nil {
+		return cur, nil
+	}
+	if !s.resp.NextUpdate.IsZero() && time.Now().After(s.resp.NextUpdate) {
+		if !s.expired {
+			s.expired = true
+			s.opts.Logf("revocation: OCSP staple expired at %s, serving certificate without it", s.resp.NextUpdate)
+		}
+		return cur, nil
+	}
+	return s.stapled, nil
+}
+
+// Refresh fetches response if certificate changed or half of validity of response passed
+// Получает ответ, если сертификат изменился или прошла половина срока ответа
+func (s *Stapler) Refresh() error {
+	cur := s.opts.Certificate()
+	s.mu.Lock()
+	fresh := s.source == cur && s.resp != nil && time.Now().Before(halfLife(s.resp))
+	s.mu.Unlock()
+	if fresh {
+		return nil
+	}
+
+	leaf, issuer, err := s.chain(cur)
+	if err != nil {
+		return err
+	}
+	raw, resp, err := s.fetch(leaf, issuer)
+	if err != nil {
+		return err
+	}
+	if resp.Status != ocsp.Good {
+		s.opts.Logf("revocation: OCSP status of server certificate %s is not good: %d", leaf.SerialNumber, resp.Status)
+	}
+	stapled := *cur
+	stapled.OCSPStaple = raw
+
+	s.mu.Lock()
+	s.source, s.stapled, s.resp, s.expired = cur, &stapled, resp, false
+	s.mu.Unlock()
+	return nil
+}
+
+// Run refreshes response until ctx is done, failures are logged
+// Обновляет ответ, пока ctx не завершен, ошибки журналируются
+func (s *Stapler) Run(ctx context.Context) {
+	if err := s.Refresh(); err != nil {
+		s.opts.Logf("revocation: OCSP staple refresh failed: %v", err)
+	}
+	ticker := time.NewTicker(stapleCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Refresh(); err != nil {
+				s.opts.Logf("revocation: OCSP staple refresh failed: %v", err)
+			}
+		}
+	}
+}
+
+// Time to refresh response. Время обновления ответа
+func halfLife(resp *ocsp.Response) time.Time {
+	if resp.NextUpdate.IsZero() {
+		return resp.ThisUpdate.Add(stapleCheckInterval)
+	}
+	return resp.ThisUpdate.Add(resp.NextUpdate.Sub(resp.ThisUpdate) / 2)
+}
+
+// Leaf and issuer from chain of certificate or options. Конечный сертификат и издатель
+func (s *Stapler) chain(cert *tls.Certificate) (leaf, issuer *x509.Certificate, err error) {
+	if cert == nil || len(cert.Certificate) == 0 {
+		return nil, nil, errors.New("revocation: no server certificate")
+	}
+	if leaf = cert.Leaf; leaf == nil {
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, nil, err
+		}
+	}
+	candidates := s.opts.Issuers
+	for _, der := range cert.Certificate[1:] {
+		if c, err := x509.ParseCertificate(der); err == nil {
+			candidates = append([]*x509.Certificate{c}, candidates...)
+		}
+	}
+	for _, c := range candidates {
+		if bytes.Equal(leaf.RawIssuer, c.RawSubject) && leaf.CheckSignatureFrom(c) == nil {
+			return leaf, c, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("revocation: issuer of %q not found", leaf.Subject.CommonName)
+}
+
+// Requests response of responder. Запрашивает ответ у ответчика
+func (s *Stapler) fetch(leaf, issuer *x509.Certificate) ([]byte, *ocsp.Response, error) {
+	url := s.opts.Responder
+	if url == "" {
+		if len(leaf.OCSPServer) == 0 {
+			return nil, nil, errors.New("revocation: certificate has no OCSP responder")
+		}
+		url = leaf.OCSPServer[0]
+	}
+	req, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	httpResp, err := s.opts.HTTPClient.Post(url, "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("revocation: responder %s: %s", url, httpResp.Status)
+	}
+	raw, err := io.ReadAll(io.LimitReader(httpResp.Body, 1<<20))
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := ocsp.ParseResponseForCert(raw, leaf, issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("revocation: invalid OCSP response: %w", err)
+	}
+	return raw, resp, nil
+}
+
+// LoadCertificates reads PEM certificates, e.g. issuers of stapler. Читает сертификаты PEM
+func LoadCertificates(file string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs, nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+}
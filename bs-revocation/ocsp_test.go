@@ -0,0 +1,173 @@
+// Тестирование прикрепления ответов OCSP с локальным ответчиком
+// Testing of OCSP stapling with local responder
+
+package revocation
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/blablatov/bidistream-mtls-grpc/bs-certs/pki"
+	"golang.org/x/crypto/ocsp"
+)
+
+// Local responder answering with status. Локальный ответчик OCSP с заданным статусом
+func newResponder(t *testing.T, ca *pki.Cert, status *int32, requests *int32) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		body, _ := io.ReadAll(r.Body)
+		req, err := ocsp.ParseRequest(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		tmpl := ocsp.Response{
+			Status:       int(atomic.LoadInt32(status)),
+			SerialNumber: req.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Minute),
+			NextUpdate:   time.Now().Add(time.Hour),
+		}
+		if tmpl.Status == ocsp.Revoked {
+			tmpl.RevokedAt = time.Now().Add(-time.Minute)
+		}
+		resp, err := ocsp.CreateResponse(ca.Cert, ca.Cert, tmpl, ca.Key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		w.Write(resp)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func tlsCertificate(tc *pki.Cert) *tls.Certificate {
+	c := tc.TLSCertificate()
+	return &c
+}
+
+// Handshake of client with stapling server. Рукопожатие клиента с сервером, прикрепляющим ответы
+func handshake(t *testing.T, ca *pki.Cert, stapler *Stapler, required bool) error {
+	t.Helper()
+	sc, cc := net.Pipe()
+	defer sc.Close()
+	defer cc.Close()
+	go tls.Server(sc, &tls.Config{GetCertificate: stapler.GetCertificate}).Handshake()
+	return tls.Client(cc, &tls.Config{
+		ServerName:       "server",
+		RootCAs:          ca.Pool(),
+		VerifyConnection: VerifyStaple(required),
+	}).Handshake()
+}
+
+func TestStapler(t *testing.T) {
+	ca := issue(t, "ca", nil, "")
+	var status, requests int32 = ocsp.Good, 0
+	responder := newResponder(t, ca, &status, &requests)
+	server := tlsCertificate(issue(t, "server", ca, responder.URL))
+
+	stapler := NewStapler(StaplerOptions{
+		Certificate: func() *tls.Certificate { return server },
+		Issuers:     []*x509.Certificate{ca.Cert},
+		Logf:        t.Logf,
+	})
+
+	// No staple yet. Ответ еще не получен
+	if err := handshake(t, ca, stapler, true); err == nil {
+		t.Fatalf("handshake() requiring staple without it = nil error")
+	}
+	if err := handshake(t, ca, stapler, false); err != nil {
+		t.Fatalf("handshake() not requiring staple = %v", err)
+	}
+
+	if err := stapler.Refresh(); err != nil {
+		t.Fatalf("Refresh() = %v", err)
+	}
+	if err := handshake(t, ca, stapler, true); err != nil {
+		t.Fatalf("handshake() with good staple = %v", err)
+	}
+
+	// Fresh response is not fetched again. Свежий ответ повторно не запрашивается
+	if err := stapler.Refresh(); err != nil || atomic.LoadInt32(&requests) != 1 {
+		t.Errorf("Refresh() = %v, requests = %d, want 1", err, requests)
+	}
+
+	// New certificate, revoked by responder. Новый сертификат, отозванный ответчиком
+	atomic.StoreInt32(&status, ocsp.Revoked)
+	server = tlsCertificate(issue(t, "server", ca, responder.URL))
+	if err := stapler.Refresh(); err != nil {
+		t.Fatalf("Refresh() = %v", err)
+	}
+	if err := handshake(t, ca, stapler, false); err == nil {
+		t.Errorf("handshake() with revoked staple = nil error")
+	}
+}
+
+// Expired staple is dropped from handshakes. Истекший ответ не прикрепляется к рукопожатиям
+func TestStapler_Expired(t *testing.T) {
+	ca := issue(t, "ca", nil, "")
+	var status, requests int32 = ocsp.Good, 0
+	responder := newResponder(t, ca, &status, &requests)
+	server := tlsCertificate(issue(t, "server", ca, responder.URL))
+
+	var logged int32
+	stapler := NewStapler(StaplerOptions{
+		Certificate: func() *tls.Certificate { return server },
+		Issuers:     []*x509.Certificate{ca.Cert},
+		Logf:        func(string, ...interface{}) { atomic.AddInt32(&logged, 1) },
+	})
+	if err := stapler.Refresh(); err != nil {
+		t.Fatalf("Refresh() = %v", err)
+	}
+	stapler.mu.Lock()
+	stapler.resp.NextUpdate = time.Now().Add(-time.Second)
+	stapler.mu.Unlock()
+
+	if cert, err := stapler.GetCertificate(nil); err != nil || cert != server || len(cert.OCSPStaple) != 0 {
+		t.Errorf("GetCertificate() with expired staple = %v, %v, want certificate without staple", cert, err)
+	}
+	if err := handshake(t, ca, stapler, false); err != nil {
+		t.Errorf("handshake() with expired staple not required = %v", err)
+	}
+	if err := handshake(t, ca, stapler, true); err == nil {
+		t.Errorf("handshake() requiring staple after expiry = nil error")
+	}
+	if n := atomic.LoadInt32(&logged); n != 1 {
+		t.Errorf("expiry logged %d times, want 1", n)
+	}
+}
+
+func TestStapler_NoIssuer(t *testing.T) {
+	ca := issue(t, "ca", nil, "")
+	server := tlsCertificate(issue(t, "server", ca, "http://127.0.0.1:1"))
+	stapler := NewStapler(StaplerOptions{Certificate: func() *tls.Certificate { return server }})
+	if err := stapler.Refresh(); err == nil {
+		t.Error("Refresh() without issuer = nil error")
+	}
+}
+
+func TestCheckResponse_Expired(t *testing.T) {
+	ca := issue(t, "ca", nil, "")
+	leaf := issue(t, "server", ca, "")
+	raw, err := ocsp.CreateResponse(ca.Cert, ca.Cert, ocsp.Response{
+		Status:       ocsp.Good,
+		SerialNumber: leaf.Cert.SerialNumber,
+		ThisUpdate:   time.Now().Add(-2 * time.Hour),
+		NextUpdate:   time.Now().Add(-time.Hour),
+	}, ca.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkResponse(raw, leaf.Cert, ca.Cert, time.Now()); err == nil {
+		t.Error("checkResponse(expired) = nil error")
+	}
+}
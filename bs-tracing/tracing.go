@@ -0,0 +1,193 @@
+// Package tracing sets up OpenTelemetry tracing of order streams and carries W3C trace context in gRPC metadata.
+// Spans are exported to OTLP collector or as JSON to writer, tests record them in memory.
+// Пакет tracing настраивает трассировку OpenTelemetry потоков заказов и передает контекст W3C в метаданных gRPC.
+// Спаны экспортируются в коллектор OTLP или как JSON в writer, тесты записывают их в память.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	pb "github.com/blablatov/bidistream-mtls-grpc/bs-mtls-proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+)
+
+// Instrumentation name of tracers. Имя инструментирования трассировщиков
+const Name = "github.com/blablatov/bidistream-mtls-grpc"
+
+// Exporters of spans. Экспортеры спанов
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout" // JSON to writer. JSON в writer
+	ExporterOTLP   = "otlp"   // OTLP over gRPC. OTLP поверх gRPC
+)
+
+// Events of spans. События спанов
+const (
+	EventRecv  = "recv"
+	EventSend  = "send"
+	EventFlush = "flush" // Shipment is emitted by batcher. Партия выпущена группировщиком
+)
+
+// Attributes of events. Атрибуты событий
+const (
+	OrderID          = attribute.Key("order.id")
+	ShipmentID       = attribute.Key("shipment.id")
+	ShipmentSequence = attribute.Key("shipment.sequence")
+	ShipmentTrigger  = attribute.Key("shipment.trigger")
+	ShipmentOrders   = attribute.Key("shipment.orders") // Number of orders. Число заказов
+	Rejected         = attribute.Key("order.rejected")  // ID of rejected order. ID отклоненного заказа
+)
+
+// ValidExporter reports whether name is known exporter, "" is none
+// Сообщает, известен ли экспортер, "" - без экспорта
+func ValidExporter(name string) bool {
+	switch name {
+	case "", ExporterNone, ExporterStdout, ExporterOTLP:
+		return true
+	}
+	return false
+}
+
+// NewExporter returns exporter of name, nil for none. Endpoint of OTLP is host:port, http:// prefix turns off TLS,
+// empty endpoint is taken from OTEL_EXPORTER_OTLP_ENDPOINT
+// Возвращает экспортер по имени, nil - без экспорта. Адрес OTLP host:port, префикс http:// отключает TLS,
+// пустой адрес берется из OTEL_EXPORTER_OTLP_ENDPOINT
+func NewExporter(ctx context.Context, name, endpoint string, w io.Writer) (sdktrace.SpanExporter, error) {
+	switch name {
+	case "", ExporterNone:
+		return nil, nil
+	case ExporterStdout:
+		return stdouttrace.New(stdouttrace.WithWriter(w))
+	case ExporterOTLP:
+		var opts []otlptracegrpc.Option
+		switch {
+		case strings.HasPrefix(endpoint, "http://"):
+			opts = append(opts, otlptracegrpc.WithEndpoint(strings.TrimPrefix(endpoint, "http://")), otlptracegrpc.WithInsecure())
+		case endpoint != "":
+			opts = append(opts, otlptracegrpc.WithEndpoint(strings.TrimPrefix(endpoint, "https://")))
+		}
+		return otlptracegrpc.New(ctx, opts...)
+	}
+	return nil, fmt.Errorf("tracing: unknown exporter %q", name)
+}
+
+// NewProvider returns provider of service batching spans to exporter
+// Возвращает провайдер сервиса, отправляющий спаны экспортеру пакетами
+func NewProvider(exp sdktrace.SpanExporter, service string, opts ...sdktrace.TracerProviderOption) *sdktrace.TracerProvider {
+	res := resource.NewSchemaless(semconv.ServiceName(service))
+	return sdktrace.NewTracerProvider(append([]sdktrace.TracerProviderOption{
+		sdktrace.WithBatcher(exp), sdktrace.WithResource(res),
+	}, opts...)...)
+}
+
+// Setup installs provider of exporter as global. Without exporter spans are not recorded,
+// but trace context of peer is still passed on. Shutdown flushes spans
+// Устанавливает глобальный провайдер экспортера. Без экспортера спаны не записываются,
+// но контекст трассировки клиента передается дальше. Shutdown сбрасывает спаны
+func Setup(ctx context.Context, name, endpoint, service string, w io.Writer) (shutdown func(context.Context) error, err error) {
+	exp, err := NewExporter(ctx, name, endpoint, w)
+	if err != nil || exp == nil {
+		return func(context.Context) error { return nil }, err
+	}
+	tp := NewProvider(exp, service)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// SetupMemory installs provider recording spans in memory at their end, tests assert span tree by it.
+// Restore returns previous provider
+// Устанавливает провайдер, записывающий спаны в память при их завершении, тесты проверяют по нему дерево спанов.
+// Restore возвращает прежний провайдер
+func SetupMemory() (exp *tracetest.InMemoryExporter, restore func()) {
+	prev := otel.GetTracerProvider()
+	exp = tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp)))
+	return exp, func() { otel.SetTracerProvider(prev) }
+}
+
+// Tracer of global provider. Трассировщик глобального провайдера
+func Tracer() trace.Tracer {
+	return otel.Tracer(Name)
+}
+
+// W3C trace context, traceparent and tracestate keys. Контекст трассировки W3C, ключи traceparent и tracestate
+var propagator propagation.TextMapPropagator = propagation.TraceContext{}
+
+// Carrier of trace context in gRPC metadata. Переносчик контекста трассировки в метаданных gRPC
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	if v := metadata.MD(c).Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// Inject adds trace context of span in ctx to outgoing metadata
+// Добавляет контекст трассировки спана из ctx в исходящие метаданные
+func Inject(ctx context.Context) context.Context {
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.MD{}
+	}
+	propagator.Inject(ctx, metadataCarrier(md))
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+// Extract returns ctx with remote span of incoming metadata, if there is one
+// Возвращает ctx с удаленным спаном из входящих метаданных, если он есть
+func Extract(ctx context.Context) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	return propagator.Extract(ctx, metadataCarrier(md))
+}
+
+// Attributes of message: order ID or shipment with its orders or rejected ID
+// Атрибуты сообщения: ID заказа или партия с ее заказами либо отклоненный ID
+func Attributes(m interface{}) []attribute.KeyValue {
+	switch m := m.(type) {
+	case *wrappers.StringValue:
+		return []attribute.KeyValue{OrderID.String(m.GetValue())}
+	case *pb.CombinedShipment:
+		if r := m.GetRejected(); r != nil {
+			return []attribute.KeyValue{Rejected.String(r.GetId())}
+		}
+		return []attribute.KeyValue{
+			ShipmentID.String(m.GetId()),
+			ShipmentSequence.Int64(int64(m.GetSequence())),
+			ShipmentTrigger.String(m.GetTrigger().String()),
+			ShipmentOrders.Int(len(m.GetOrdersList())),
+		}
+	}
+	return nil
+}
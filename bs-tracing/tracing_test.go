@@ -0,0 +1,86 @@
+// Тестирование трассировки. Testing of tracing
+
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	pb "github.com/blablatov/bidistream-mtls-grpc/bs-mtls-proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestInjectExtract(t *testing.T) {
+	exp, restore := SetupMemory()
+	defer restore()
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer t")
+	ctx, span := Tracer().Start(ctx, "client")
+	out, _ := metadata.FromOutgoingContext(Inject(ctx))
+	span.End()
+	if len(out.Get("traceparent")) != 1 || len(out.Get("authorization")) != 1 {
+		t.Fatalf("outgoing metadata = %v, want traceparent and authorization", out)
+	}
+
+	// Server sees incoming metadata of client. Сервер видит входящие метаданные клиента
+	remote := trace.SpanContextFromContext(Extract(metadata.NewIncomingContext(context.Background(), out)))
+	if !remote.IsRemote() || remote.TraceID() != span.SpanContext().TraceID() || remote.SpanID() != span.SpanContext().SpanID() {
+		t.Errorf("extracted %v, want remote %v", remote, span.SpanContext())
+	}
+	if n := len(exp.GetSpans()); n != 1 {
+		t.Errorf("recorded %d spans, want 1", n)
+	}
+
+	// No metadata, no parent. Без метаданных нет родителя
+	if sc := trace.SpanContextFromContext(Extract(context.Background())); sc.IsValid() {
+		t.Errorf("extracted %v from empty context", sc)
+	}
+}
+
+func TestAttributes(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		msg  interface{}
+		want []attribute.KeyValue
+	}{
+		{"order", &wrappers.StringValue{Value: "102"}, []attribute.KeyValue{OrderID.String("102")}},
+		{"shipment", &pb.CombinedShipment{Id: "cmb - 1", Sequence: 3, Trigger: pb.FlushTrigger_FLUSH_TRIGGER_MAX_ORDERS,
+			OrdersList: []*pb.Order{{Id: "102"}, {Id: "104"}}}, []attribute.KeyValue{
+			ShipmentID.String("cmb - 1"), ShipmentSequence.Int64(3),
+			ShipmentTrigger.String("FLUSH_TRIGGER_MAX_ORDERS"), ShipmentOrders.Int(2),
+		}},
+		{"rejected", &pb.CombinedShipment{Id: "rej - 1", Rejected: &pb.RejectedOrder{Id: "1"}},
+			[]attribute.KeyValue{Rejected.String("1")}},
+		{"other", "message", nil},
+	} {
+		got := Attributes(tc.msg)
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: attributes = %v, want %v", tc.name, got, tc.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("%s: attribute %d = %v, want %v", tc.name, i, got[i], tc.want[i])
+			}
+		}
+	}
+}
+
+func TestNewExporter(t *testing.T) {
+	for _, name := range []string{"", ExporterNone} {
+		if exp, err := NewExporter(context.Background(), name, "", nil); exp != nil || err != nil {
+			t.Errorf("%q: exporter %v, %v, want none", name, exp, err)
+		}
+	}
+	if _, err := NewExporter(context.Background(), "jaeger", "", nil); err == nil || ValidExporter("jaeger") {
+		t.Error("unknown exporter is accepted")
+	}
+	exp, err := NewExporter(context.Background(), ExporterOTLP, "http://127.0.0.1:4317", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp.Shutdown(context.Background())
+}